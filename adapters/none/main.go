@@ -1,9 +1,64 @@
 package none
 
 import (
-	adapter "grim/adapters"
+	"grim"
+	"image"
+	"os"
 )
 
-func Init() *adapter.Adapter {
-	return &adapter.Adapter{}
+// Init returns a adapter that doesn't open a window or draw anything, it's for running grim headless in tests
+// and on servers. Input is sent with the functions below and the render data is in Window.RenderData after each
+// one. Window.Open returns after the first frame
+func Init() *grim.Adapter {
+	a := &grim.Adapter{}
+	a.Init = func(width, height int) {}
+	// Window.Open calls Render until the window is closed, there is no window to close here so the first frame
+	// closes it. The input functions render on their own, Open isn't needed to drive them
+	a.Render = func(state []grim.State) {
+		a.DispatchEvent(grim.Event{Name: "close"})
+	}
+	a.Load = func(key string, texture image.Image) {}
+	a.Unload = func(key string) {}
+	a.FileSystem = grim.FileSystem{
+		ReadFile: os.ReadFile,
+		WriteFile: func(path string, data []byte) {
+			os.WriteFile(path, data, 0644)
+		},
+	}
+	return a
+}
+
+// Touch sends a touch, phase is "down", "move", "up" or "cancel". The ids of touches start after grim.MousePointer
+func Touch(a *grim.Adapter, phase string, id, x, y int) {
+	p := grim.Pointer{Id: id, Type: "touch", X: x, Y: y}
+	if phase == "down" || phase == "move" {
+		p.Pressure = 1
+	}
+	a.DispatchEvent(grim.Event{Name: "pointer" + phase, Data: p})
+}
+
+func MouseMove(a *grim.Adapter, x, y int) {
+	a.DispatchEvent(grim.Event{Name: "mousemove", Data: []int{x, y}})
+}
+
+func MouseDown(a *grim.Adapter) {
+	a.DispatchEvent(grim.Event{Name: "mousedown"})
+}
+
+func MouseUp(a *grim.Adapter) {
+	a.DispatchEvent(grim.Event{Name: "mouseup"})
+}
+
+// Scroll turns the wheel, positive y is up
+func Scroll(a *grim.Adapter, x, y int) {
+	a.DispatchEvent(grim.Event{Name: "scroll", Data: []int{x, y}})
+}
+
+// Key presses or lets go of the key, the codes are the same as raylib's
+func Key(a *grim.Adapter, code int, down bool) {
+	name := "keyup"
+	if down {
+		name = "keydown"
+	}
+	a.DispatchEvent(grim.Event{Name: name, Data: code})
 }
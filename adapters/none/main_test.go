@@ -0,0 +1,168 @@
+package none
+
+import (
+	"grim"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const page = `<html><body style="margin:0">
+<div id="a" style="width:100px;height:100px"></div>
+<div id="b" style="width:100px;height:100px"></div>
+</body></html>`
+
+// open renders page in a headless window, a is at 0,0 and b is under it at 0,100
+func open(t *testing.T) (*grim.Adapter, *grim.Window) {
+	path := filepath.Join(t.TempDir(), "index.html")
+	if err := os.WriteFile(path, []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := Init()
+	w := grim.New(a, 400, 300)
+	w.Path(path)
+	return a, &w
+}
+
+// record adds a listener for each of the events to the element with the id and returns the events it got
+func record(w *grim.Window, id string, names ...string) *[]grim.Event {
+	got := &[]grim.Event{}
+	n := w.Document().GetElementById(id)
+	for _, name := range names {
+		n.AddEventListener(name, func(e grim.Event) {
+			*got = append(*got, e)
+		})
+	}
+	return got
+}
+
+func names(events []grim.Event) []string {
+	list := []string{}
+	for _, e := range events {
+		list = append(list, e.Name)
+	}
+	return list
+}
+
+func TestTouchPointerEvents(t *testing.T) {
+	a, w := open(t)
+	gotA := record(w, "a", "pointerdown", "pointermove", "pointerup")
+	gotB := record(w, "b", "pointerdown", "pointermove", "pointerup")
+
+	Touch(a, "down", 2, 50, 50)
+	Touch(a, "move", 2, 50, 150)
+	Touch(a, "up", 2, 50, 150)
+
+	if n := names(*gotA); !slices.Equal(n, []string{"pointerdown"}) {
+		t.Fatalf("a got %v, want [pointerdown]", n)
+	}
+	// Without a capture the events go to the element under the touch
+	if n := names(*gotB); !slices.Equal(n, []string{"pointermove", "pointerup"}) {
+		t.Fatalf("b got %v, want [pointermove pointerup]", n)
+	}
+	down := (*gotA)[0]
+	if down.PointerId != 2 || down.PointerType != "touch" || !down.IsPrimary || down.Pressure != 1 {
+		t.Errorf("pointerdown = id %d type %q primary %v pressure %v", down.PointerId, down.PointerType, down.IsPrimary, down.Pressure)
+	}
+	if up := (*gotB)[1]; up.Pressure != 0 {
+		t.Errorf("pointerup pressure = %v, want 0", up.Pressure)
+	}
+}
+
+func TestTouchPointerCapture(t *testing.T) {
+	a, w := open(t)
+	n := w.Document().GetElementById("a")
+	n.AddEventListener("pointerdown", func(e grim.Event) {
+		n.SetPointerCapture(e.PointerId)
+	})
+	gotA := record(w, "a", "pointermove", "pointerup", "gotpointercapture", "lostpointercapture")
+	gotB := record(w, "b", "pointermove", "pointerup")
+
+	Touch(a, "down", 2, 50, 50)
+	Touch(a, "move", 2, 50, 150)
+	Touch(a, "up", 2, 50, 150)
+
+	want := []string{"gotpointercapture", "pointermove", "pointerup", "lostpointercapture"}
+	if got := names(*gotA); !slices.Equal(got, want) {
+		t.Fatalf("a got %v, want %v", got, want)
+	}
+	if len(*gotB) != 0 {
+		t.Errorf("b got %v while a had the capture", names(*gotB))
+	}
+	if n.HasPointerCapture(2) {
+		t.Error("the capture wasn't released when the touch went up")
+	}
+}
+
+func TestTouchPinch(t *testing.T) {
+	a, w := open(t)
+	got := record(w, "a", "pinchstart", "pinch", "pinchend", "swipe")
+
+	Touch(a, "down", 2, 40, 50)
+	Touch(a, "down", 3, 60, 50)
+	Touch(a, "move", 3, 80, 50)
+	Touch(a, "up", 3, 80, 50)
+	Touch(a, "up", 2, 40, 50)
+
+	// The pinched touches don't swipe
+	want := []string{"pinchstart", "pinch", "pinchend"}
+	if n := names(*got); !slices.Equal(n, want) {
+		t.Fatalf("got %v, want %v", n, want)
+	}
+	p := (*got)[1].Data.(grim.Pinch)
+	if p.Scale != 2 || p.X != 60 || p.Y != 50 {
+		t.Errorf("pinch = %+v, want scale 2 at 60,50", p)
+	}
+}
+
+func TestTouchSwipe(t *testing.T) {
+	a, w := open(t)
+	got := record(w, "a", "swipe")
+
+	Touch(a, "down", 2, 10, 50)
+	Touch(a, "move", 2, 90, 55)
+	Touch(a, "up", 2, 90, 55)
+	// Too short to be a swipe
+	Touch(a, "down", 2, 10, 50)
+	Touch(a, "up", 2, 30, 50)
+
+	if len(*got) != 1 {
+		t.Fatalf("got %d swipes, want 1", len(*got))
+	}
+	s := (*got)[0].Data.(grim.Swipe)
+	if s.Direction != "right" || s.Distance < 80 {
+		t.Errorf("swipe = %+v, want right and at least 80px", s)
+	}
+}
+
+func TestActiveAncestors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.html")
+	html := `<html><head><style>li:active { color: red }</style></head><body style="margin:0">
+<ul style="margin:0;padding:0"><li id="li" style="display:block;width:200px;height:100px">
+<a style="display:block;position:absolute;left:300px;top:0;width:50px;height:50px"></a>
+</li></ul></body></html>`
+	if err := os.WriteFile(path, []byte(html), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := Init()
+	w := grim.New(a, 400, 300)
+	w.Path(path)
+	li := w.Document().GetElementById("li")
+
+	// The link is outside of the box of the li but pressing it makes the li :active
+	MouseMove(a, 310, 10)
+	MouseDown(a)
+	if c := li.GetComputedStyles()["color"]; c != "red" {
+		t.Fatalf("li color while its child is pressed = %q, want red", c)
+	}
+	// It stays on until the button goes up even if the mouse leaves
+	MouseMove(a, 390, 290)
+	if c := li.GetComputedStyles()["color"]; c != "red" {
+		t.Fatalf("li color after the mouse left = %q, want red", c)
+	}
+	MouseUp(a)
+	if c := li.GetComputedStyles()["color"]; c == "red" {
+		t.Fatal("li is still :active after the button went up")
+	}
+}
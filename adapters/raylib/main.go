@@ -3,7 +3,9 @@ package raylib
 import (
 	rl "github.com/gen2brain/raylib-go/raylib"
 	"grim"
+	"grim/gg"
 	"image"
+	"image/color"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -84,7 +86,16 @@ type WindowManager struct {
 	MousePosition []int
 	MouseState    bool
 	ContextState  bool
-	Adapter       *grim.Adapter
+	// Touches are the touches that are down by their raylib id
+	Touches map[int32]rl.Vector2
+	Adapter *grim.Adapter
+	Frame   rl.RenderTexture2D
+	// targets and textures are kept by their size so the layers reuse them between frames, used is how
+	// many of each size the current frame took
+	targets      map[[2]int32][]rl.RenderTexture2D
+	textures     map[[2]int32][]rl.Texture2D
+	usedTargets  map[[2]int32]int
+	usedTextures map[[2]int32]int
 }
 
 // NewWindowManager creates a new WindowManager instance
@@ -94,6 +105,7 @@ func NewWindowManager(a *grim.Adapter) *WindowManager {
 	return &WindowManager{
 		CurrentEvents: make(map[int]bool, 256),
 		MousePosition: []int{int(mp.X), int(mp.Y)},
+		Touches:       map[int32]rl.Vector2{},
 		Adapter:       a,
 		Textures:      map[string]*rl.Texture2D{},
 	}
@@ -112,77 +124,412 @@ func (wm *WindowManager) OpenWindow(width, height int32) {
 
 // Draw draws all nodes on the window
 func (wm *WindowManager) Draw(nodes []grim.State) {
-	indexes := []float32{0}
 	rl.BeginDrawing()
 	wm.GetEvents()
-	for a := 0; a < len(indexes); a++ {
-		for _, node := range nodes {
-			if node.Hidden {
-				continue
-			}
-			if node.Z == indexes[a] {
 
-				// Draw the border based on the style for each side
+	// Everything is drawn into the frame so backdrop filters can read what is behind them
+	if wm.Frame.Texture.Width != wm.Width || wm.Frame.Texture.Height != wm.Height {
+		rl.UnloadRenderTexture(wm.Frame)
+		wm.Frame = rl.LoadRenderTexture(wm.Width, wm.Height)
+	}
+	frame := target{texture: wm.Frame}
+	rl.BeginTextureMode(frame.texture)
+	rl.ClearBackground(rl.White)
+	if len(nodes) > 0 {
+		wm.drawRange(nodes, 0, len(nodes)-1, -1, frame)
+	}
+	rl.EndTextureMode()
 
-				if node.Textures != nil {
-					textures := []string{}
+	// Render textures are upside down
+	rl.DrawTextureRec(frame.texture.Texture, rl.Rectangle{
+		Width:  float32(wm.Width),
+		Height: -float32(wm.Height),
+	}, rl.Vector2{}, rl.White)
+	rl.EndDrawing()
 
-					t := node.Textures["background"]
-					if t != "" {
-						textures = append(textures, t)
-					}
+	wm.releaseFrame()
+}
 
-					t = node.Textures["border"]
-					if t != "" {
-						textures = append(textures, t)
-					}
+// renderTarget returns a render texture of the size that isn't used yet in this frame
+func (wm *WindowManager) renderTarget(width, height int32) rl.RenderTexture2D {
+	if wm.targets == nil {
+		wm.targets = map[[2]int32][]rl.RenderTexture2D{}
+		wm.usedTargets = map[[2]int32]int{}
+	}
+	size := [2]int32{width, height}
+	i := wm.usedTargets[size]
+	wm.usedTargets[size] = i + 1
+	if i < len(wm.targets[size]) {
+		return wm.targets[size][i]
+	}
+	rt := rl.LoadRenderTexture(width, height)
+	wm.targets[size] = append(wm.targets[size], rt)
+	return rt
+}
 
-					t = node.Textures["canvas"]
-					if t != "" {
-						textures = append(textures, t)
-					}
+// frameTexture returns a texture the size of img that isn't used yet in this frame, a new texture is
+// loaded from img when there isn't one
+func (wm *WindowManager) frameTexture(img *rl.Image) rl.Texture2D {
+	if wm.textures == nil {
+		wm.textures = map[[2]int32][]rl.Texture2D{}
+		wm.usedTextures = map[[2]int32]int{}
+	}
+	size := [2]int32{img.Width, img.Height}
+	i := wm.usedTextures[size]
+	wm.usedTextures[size] = i + 1
+	if i < len(wm.textures[size]) {
+		return wm.textures[size][i]
+	}
+	texture := rl.LoadTextureFromImage(img)
+	wm.textures[size] = append(wm.textures[size], texture)
+	return texture
+}
 
-					t = node.Textures["text"]
-					if t != "" {
-						textures = append(textures, t)
-					}
+// releaseFrame keeps as many textures of each size as the frame used for the next one and unloads the
+// rest, so the textures of layers that changed size or went away don't pile up
+func (wm *WindowManager) releaseFrame() {
+	for size, targets := range wm.targets {
+		used := wm.usedTargets[size]
+		for _, v := range targets[used:] {
+			rl.UnloadRenderTexture(v)
+		}
+		if used == 0 {
+			delete(wm.targets, size)
+		} else {
+			wm.targets[size] = targets[:used]
+		}
+	}
+	for size, textures := range wm.textures {
+		used := wm.usedTextures[size]
+		for _, v := range textures[used:] {
+			rl.UnloadTexture(v)
+		}
+		if used == 0 {
+			delete(wm.textures, size)
+		} else {
+			wm.textures[size] = textures[:used]
+		}
+	}
+	clear(wm.usedTargets)
+	clear(wm.usedTextures)
+}
 
-					for _, v := range textures {
-						texture, exists := wm.Textures[v]
-						if exists {
-							sourceRec := rl.Rectangle{
-								X:      0,
-								Y:      0,
-								Width:  float32(texture.Width),
-								Height: float32(texture.Height),
-							}
-
-							if node.Crop.X != 0 || node.Crop.Y != 0 || node.Crop.Width != 0 || node.Crop.Height != 0 {
-								sourceRec = rl.Rectangle{
-									X:      float32(node.Crop.X),
-									Y:      float32(node.Crop.Y),
-									Width:  float32(node.Crop.Width),
-									Height: float32(node.Crop.Height),
-								}
-							}
-
-							rl.DrawTextureRec(*texture, sourceRec, rl.Vector2{
-								X: node.X + float32(node.Crop.X),
-								Y: node.Y + float32(node.Crop.Y),
-							}, rl.White)
-						}
-					}
-				}
-			} else {
+// OpenGL blend factors and equations for rl.SetBlendFactorsSeparate
+const (
+	glZero     = 0
+	glOne      = 1
+	glSrcAlpha = 0x0302
+	glFuncAdd  = 0x8006
+)
+
+// target is the render texture being drawn into, X and Y is where it is on the window
+type target struct {
+	texture rl.RenderTexture2D
+	X       float32
+	Y       float32
+}
+
+// drawRange draws the nodes from start to end (the end included) in z order, layer is the index of
+// the layer being drawn (-1 for the frame), the layers inside of it are drawn as a single image
+func (wm *WindowManager) drawRange(nodes []grim.State, start, end, layer int, t target) {
+	indexes := []float32{0}
+	for a := 0; a < len(indexes); a++ {
+		for i := start; i <= end; i++ {
+			node := nodes[i]
+			if node.Z != indexes[a] {
 				if !slices.Contains(indexes, node.Z) {
 					indexes = append(indexes, node.Z)
 					slices.Sort(indexes)
 				}
+			} else if node.Layer != nil && i != layer {
+				wm.drawLayer(nodes, i, t)
+			} else if !node.Hidden {
+				wm.drawNode(node, t)
+			}
+			// A layer is its own stacking context, the z-index of what is inside doesn't matter out here
+			if node.Layer != nil && i != layer {
+				i = node.Layer.End
 			}
 		}
 	}
+}
 
-	rl.EndDrawing()
+func (wm *WindowManager) drawNode(node grim.State, t target) {
+	if node.Textures != nil {
+		textures := []string{}
+
+		v := node.Textures["background"]
+		if v != "" {
+			textures = append(textures, v)
+		}
+
+		v = node.Textures["border"]
+		if v != "" {
+			textures = append(textures, v)
+		}
+
+		v = node.Textures["canvas"]
+		if v != "" {
+			textures = append(textures, v)
+		}
+
+		for _, v := range textures {
+			texture, exists := wm.Textures[v]
+			if exists {
+				x, y := node.X, node.Y
+				// border-image-outset draws the border outside of the border box
+				if v == node.Textures["border"] {
+					x -= node.Border.Image.Outset.Left
+					y -= node.Border.Image.Outset.Top
+				}
+				sourceRec := rl.Rectangle{
+					X:      0,
+					Y:      0,
+					Width:  float32(texture.Width),
+					Height: float32(texture.Height),
+				}
+
+				if node.Crop.X != 0 || node.Crop.Y != 0 || node.Crop.Width != 0 || node.Crop.Height != 0 {
+					sourceRec = rl.Rectangle{
+						X:      float32(node.Crop.X),
+						Y:      float32(node.Crop.Y),
+						Width:  float32(node.Crop.Width),
+						Height: float32(node.Crop.Height),
+					}
+				}
+
+				drawTexture(*texture, sourceRec, x+float32(node.Crop.X), y+float32(node.Crop.Y), node.Transform, t)
+			}
+		}
+	}
+
+	// Text is drawn a line at a time, the crop of the node is in node space so it
+	// gets moved into the space of each fragment
+	cropped := node.Crop.X != 0 || node.Crop.Y != 0 || node.Crop.Width != 0 || node.Crop.Height != 0
+	for _, f := range node.Fragments {
+		texture, exists := wm.Textures[f.Texture]
+		if !exists {
+			continue
+		}
+		x1, y1 := f.X, f.Y
+		x2, y2 := f.X+float32(texture.Width), f.Y+float32(texture.Height)
+		if cropped {
+			x1 = max(x1, float32(node.Crop.X))
+			y1 = max(y1, float32(node.Crop.Y))
+			x2 = min(x2, float32(node.Crop.X+node.Crop.Width))
+			y2 = min(y2, float32(node.Crop.Y+node.Crop.Height))
+		}
+		if x2 <= x1 || y2 <= y1 {
+			continue
+		}
+		drawTexture(*texture, rl.Rectangle{
+			X:      x1 - f.X,
+			Y:      y1 - f.Y,
+			Width:  x2 - x1,
+			Height: y2 - y1,
+		}, node.X+x1, node.Y+y1, node.Transform, t)
+	}
+
+	// Outlines go on top of the element and outside of its border box
+	if texture, exists := wm.Textures[node.Textures["outline"]]; exists && node.Textures["outline"] != "" {
+		d := node.Outline.Width + node.Outline.Offset
+		drawTexture(*texture, rl.Rectangle{
+			Width:  float32(texture.Width),
+			Height: float32(texture.Height),
+		}, node.X-d, node.Y-d, node.Transform, t)
+	}
+}
+
+// drawTexture draws the source of the texture at x, y in window coordinates, when m isn't nil
+// the corners of the quad are moved by the transform of the node
+func drawTexture(texture rl.Texture2D, source rl.Rectangle, x, y float32, m *gg.Matrix, t target) {
+	if m == nil {
+		rl.DrawTextureRec(texture, source, rl.Vector2{X: x - t.X, Y: y - t.Y}, rl.White)
+		return
+	}
+
+	corners := [4][2]float32{{x, y}, {x, y + source.Height}, {x + source.Width, y + source.Height}, {x + source.Width, y}}
+	for i, c := range corners {
+		tx, ty := m.TransformPoint(float64(c[0]), float64(c[1]))
+		corners[i] = [2]float32{float32(tx) - t.X, float32(ty) - t.Y}
+	}
+	u1, v1 := source.X/float32(texture.Width), source.Y/float32(texture.Height)
+	u2, v2 := (source.X+source.Width)/float32(texture.Width), (source.Y+source.Height)/float32(texture.Height)
+	uvs := [4][2]float32{{u1, v1}, {u1, v2}, {u2, v2}, {u2, v1}}
+
+	rl.SetTexture(texture.ID)
+	rl.Begin(rl.Quads)
+	rl.Color4ub(255, 255, 255, 255)
+	rl.Normal3f(0, 0, 1)
+	for i := range corners {
+		rl.TexCoord2f(uvs[i][0], uvs[i][1])
+		rl.Vertex2f(corners[i][0], corners[i][1])
+	}
+	rl.End()
+	rl.SetTexture(0)
+}
+
+// drawLayer draws the subtree of nodes[i] into its own render texture, filters it and draws
+// it into t with the opacity of the layer. It is called while drawing into t
+func (wm *WindowManager) drawLayer(nodes []grim.State, i int, t target) {
+	node := nodes[i]
+	l := node.Layer
+	if l.Opacity == 0 || l.Width <= 0 || l.Height <= 0 {
+		return
+	}
+	rl.EndTextureMode()
+
+	// The backdrop is what was drawn under the border box of the element
+	var backdrop *rl.Texture2D
+	bx, by := node.X-t.X, node.Y-t.Y
+	if len(l.BackdropFilter) > 0 {
+		img := rl.LoadImageFromTexture(t.texture.Texture)
+		rl.ImageFlipVertical(img)
+		rl.ImageCrop(img, rl.Rectangle{
+			X:      bx,
+			Y:      by,
+			Width:  node.Width + node.Border.Left.Width + node.Border.Right.Width,
+			Height: node.Height + node.Border.Top.Width + node.Border.Bottom.Width,
+		})
+		pixels := grim.ApplyFilters(imagePixels(img), l.BackdropFilter)
+		// The backdrop is only shown inside of the rounded corners of the border box
+		grim.ClipToRadius(pixels, node.Border.Radius)
+		backdrop = wm.pixelsTexture(img, pixels)
+	}
+
+	layer := target{texture: wm.renderTarget(int32(l.Width), int32(l.Height)), X: l.X, Y: l.Y}
+	mask, masked := wm.Textures[l.Mask]
+	masked = masked && l.Mask != ""
+	rl.BeginTextureMode(layer.texture)
+	rl.ClearBackground(rl.Blank)
+	if l.ClipsDescendants() {
+		// overflow clips what is inside of the element, its own background, border and outline are drawn as is
+		if !node.Hidden {
+			wm.drawNode(node, layer)
+		}
+		if masked && l.End > i {
+			rl.EndTextureMode()
+			inner := target{texture: wm.renderTarget(int32(l.Width), int32(l.Height)), X: l.X, Y: l.Y}
+			rl.BeginTextureMode(inner.texture)
+			rl.ClearBackground(rl.Blank)
+			wm.drawRange(nodes, i+1, l.End, i, inner)
+			rl.EndTextureMode()
+			texture, source := wm.maskTexture(inner.texture.Texture, rl.Rectangle{Width: l.Width, Height: -l.Height}, *mask, l)
+			rl.BeginTextureMode(layer.texture)
+			rl.DrawTextureRec(texture, source, rl.Vector2{}, rl.White)
+		} else {
+			wm.drawRange(nodes, i+1, l.End, i, layer)
+		}
+		masked = false
+	} else {
+		wm.drawRange(nodes, i, l.End, i, layer)
+	}
+	rl.EndTextureMode()
+
+	texture := layer.texture.Texture
+	source := rl.Rectangle{Width: l.Width, Height: -l.Height}
+	if len(l.Filter) > 0 {
+		img := rl.LoadImageFromTexture(layer.texture.Texture)
+		rl.ImageFlipVertical(img)
+		texture = *wm.filterImage(img, l.Filter)
+		source.Height = l.Height
+	}
+
+	if masked {
+		texture, source = wm.maskTexture(texture, source, *mask, l)
+	}
+
+	rl.BeginTextureMode(t.texture)
+	if backdrop != nil {
+		rl.DrawTextureRec(*backdrop, rl.Rectangle{
+			Width:  float32(backdrop.Width),
+			Height: float32(backdrop.Height),
+		}, rl.Vector2{X: bx, Y: by}, rl.Fade(rl.White, l.Opacity))
+	}
+
+	if l.BlendMode == "" || l.BlendMode == "normal" {
+		rl.DrawTextureRec(texture, source, rl.Vector2{X: l.X - t.X, Y: l.Y - t.Y}, rl.Fade(rl.White, l.Opacity))
+		return
+	}
+
+	// mix-blend-mode needs what is under the layer, blending is done on the CPU and the result
+	// replaces the area under the layer
+	rl.EndTextureMode()
+	img := rl.LoadImageFromTexture(texture)
+	if source.Height < 0 {
+		rl.ImageFlipVertical(img)
+	}
+	src := imagePixels(img)
+	rl.UnloadImage(img)
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = uint8(float32(src.Pix[i]) * l.Opacity)
+	}
+
+	under := rl.LoadImageFromTexture(t.texture.Texture)
+	rl.ImageFlipVertical(under)
+	// !ISSUE: layers that go past the edge of the layer they are in are cropped and blend misaligned
+	rl.ImageCrop(under, rl.Rectangle{X: l.X - t.X, Y: l.Y - t.Y, Width: l.Width, Height: l.Height})
+	dst := imagePixels(under)
+	grim.BlendImages(dst, src, l.BlendMode)
+	blended := wm.pixelsTexture(under, dst)
+
+	rl.BeginTextureMode(t.texture)
+	rl.DrawTextureRec(*blended, rl.Rectangle{
+		Width:  float32(blended.Width),
+		Height: float32(blended.Height),
+	}, rl.Vector2{X: l.X - t.X, Y: l.Y - t.Y}, rl.White)
+}
+
+// maskTexture draws the part of source of texture into a new render texture the size of the layer and
+// multiplies its alpha by the mask, it keeps its color where the mask is. Nested clips are already inside
+// of the texture so they intersect with this one. It is called outside of texture mode
+func (wm *WindowManager) maskTexture(texture rl.Texture2D, source rl.Rectangle, mask rl.Texture2D, l *grim.Layer) (rl.Texture2D, rl.Rectangle) {
+	clipped := wm.renderTarget(int32(l.Width), int32(l.Height))
+	rl.BeginTextureMode(clipped)
+	rl.ClearBackground(rl.Blank)
+	// The factors are used when the blend mode is set, setting it again draws what is batched
+	rl.SetBlendFactorsSeparate(glOne, glZero, glOne, glZero, glFuncAdd, glFuncAdd)
+	rl.BeginBlendMode(rl.BlendCustomSeparate)
+	rl.DrawTextureRec(texture, source, rl.Vector2{}, rl.White)
+	rl.SetBlendFactorsSeparate(glZero, glOne, glZero, glSrcAlpha, glFuncAdd, glFuncAdd)
+	rl.BeginBlendMode(rl.BlendCustomSeparate)
+	rl.DrawTextureRec(mask, rl.Rectangle{
+		Width:  float32(mask.Width),
+		Height: float32(mask.Height),
+	}, rl.Vector2{}, rl.White)
+	rl.EndBlendMode()
+	rl.EndTextureMode()
+	return clipped.Texture, rl.Rectangle{Width: l.Width, Height: -l.Height}
+}
+
+// filterImage runs the filters over img and loads the result into a texture that is reused after
+// the frame, img is unloaded
+func (wm *WindowManager) filterImage(img *rl.Image, filters []grim.Filter) *rl.Texture2D {
+	return wm.pixelsTexture(img, grim.ApplyFilters(imagePixels(img), filters))
+}
+
+// imagePixels copies the pixels of a raylib image into a image.RGBA
+func imagePixels(img *rl.Image) *image.RGBA {
+	colors := rl.LoadImageColors(img)
+	pixels := image.NewRGBA(image.Rect(0, 0, int(img.Width), int(img.Height)))
+	for i, c := range colors {
+		pixels.Pix[i*4], pixels.Pix[i*4+1], pixels.Pix[i*4+2], pixels.Pix[i*4+3] = c.R, c.G, c.B, c.A
+	}
+	rl.UnloadImageColors(colors)
+	return pixels
+}
+
+// pixelsTexture loads pixels into a texture the size of img that is reused after the frame,
+// img is unloaded
+func (wm *WindowManager) pixelsTexture(img *rl.Image, pixels *image.RGBA) *rl.Texture2D {
+	texture := wm.frameTexture(img)
+	rl.UnloadImage(img)
+	out := make([]color.RGBA, len(pixels.Pix)/4)
+	for i := range out {
+		out[i] = color.RGBA{pixels.Pix[i*4], pixels.Pix[i*4+1], pixels.Pix[i*4+2], pixels.Pix[i*4+3]}
+	}
+	rl.UpdateTexture(texture, out)
+	return &texture
 }
 
 func (wm *WindowManager) GetEvents() {
@@ -255,6 +602,31 @@ func (wm *WindowManager) GetEvents() {
 			}
 		}
 	}
+	// Touches are sent as pointer events, raylib also moves the mouse with them so the mouse is skipped while touching
+	touching := len(wm.Touches) > 0
+	touches := map[int32]rl.Vector2{}
+	for i := int32(0); i < rl.GetTouchPointCount(); i++ {
+		touches[rl.GetTouchPointId(i)] = rl.GetTouchPosition(i)
+	}
+	for id, pos := range touches {
+		p := grim.Pointer{Id: int(id) + grim.MousePointer + 1, Type: "touch", X: int(pos.X), Y: int(pos.Y), Pressure: 1}
+		if old, ok := wm.Touches[id]; !ok {
+			wm.Adapter.DispatchEvent(grim.Event{Name: "pointerdown", Data: p})
+		} else if old != pos {
+			wm.Adapter.DispatchEvent(grim.Event{Name: "pointermove", Data: p})
+		}
+	}
+	for id, pos := range wm.Touches {
+		if _, ok := touches[id]; !ok {
+			p := grim.Pointer{Id: int(id) + grim.MousePointer + 1, Type: "touch", X: int(pos.X), Y: int(pos.Y)}
+			wm.Adapter.DispatchEvent(grim.Event{Name: "pointerup", Data: p})
+		}
+	}
+	wm.Touches = touches
+	if touching || len(touches) > 0 {
+		return
+	}
+
 	// mouse move, ctrl, shift etc
 
 	mp := rl.GetMousePosition()
@@ -296,12 +668,12 @@ func (wm *WindowManager) GetEvents() {
 		}
 	}
 
-	wd := rl.GetMouseWheelMove()
+	wd := rl.GetMouseWheelMoveV()
 
-	if wd != 0 {
+	if wd.X != 0 || wd.Y != 0 {
 		wm.Adapter.DispatchEvent(grim.Event{
 			Name: "scroll",
-			Data: int(wd * 6),
+			Data: []int{int(wd.X * 6), int(wd.Y * 6)},
 		})
 	}
 }
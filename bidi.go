@@ -0,0 +1,545 @@
+package grim
+
+import (
+	"golang.org/x/text/unicode/bidi"
+)
+
+// !DEVMAN: Lines are broken in logical order, then each line box is reordered into visual order with the
+// + Unicode Bidirectional Algorithm. The items of the line are joined into one string with the directional
+// + controls that unicode-bidi maps to (see https://www.w3.org/TR/css-writing-modes-3/#unicode-bidi),
+// + atomic items and the edges of inline boxes are U+FFFC so they act like neutral characters.
+// + Items are split where the level changes and the pieces are reversed with rule L2 of UAX #9
+
+const objectReplacement = '\uFFFC'
+
+// bidiControls returns the characters that open and close a inline box with the given unicode-bidi
+func bidiControls(direction, unicodeBidi string) (string, string) {
+	rtl := direction == "rtl"
+	pick := func(ltr, rtl2 string) string {
+		if rtl {
+			return rtl2
+		}
+		return ltr
+	}
+	switch unicodeBidi {
+	case "embed":
+		return pick("\u202A", "\u202B"), "\u202C"
+	case "isolate":
+		return pick("\u2066", "\u2067"), "\u2069"
+	case "bidi-override":
+		return pick("\u202D", "\u202E"), "\u202C"
+	case "isolate-override":
+		return pick("\u2066\u202D", "\u2067\u202E"), "\u202C\u2069"
+	case "plaintext":
+		return "\u2068", "\u2069"
+	}
+	return "", ""
+}
+
+// TextDirection returns the direction of the first strong character in the text, or "" if there isn't one
+func TextDirection(text string) string {
+	for _, r := range text {
+		p, _ := bidi.LookupRune(r)
+		switch p.Class() {
+		case bidi.L:
+			return "ltr"
+		case bidi.R, bidi.AL:
+			return "rtl"
+		}
+	}
+	return ""
+}
+
+// ContentDirection resolves dir="auto" from the first strong character in the text of the node and its children
+func ContentDirection(n *Node) string {
+	if d := firstStrong(n); d != "" {
+		return d
+	}
+	return "ltr"
+}
+
+func firstStrong(n *Node) string {
+	if d := TextDirection(n.innerText); d != "" {
+		return d
+	}
+	for _, v := range n.Children {
+		// Children with a dir of their own are skipped
+		if v.GetAttribute("dir") != "" || nonRenderTags[v.tagName] {
+			continue
+		}
+		if d := firstStrong(v); d != "" {
+			return d
+		}
+	}
+	return ""
+}
+
+func hasRightToLeft(text string) bool {
+	for _, r := range text {
+		p, _ := bidi.LookupRune(r)
+		if c := p.Class(); c == bidi.R || c == bidi.AL || c == bidi.AN {
+			return true
+		}
+	}
+	return false
+}
+
+type bidiPiece struct {
+	item  *inlineItem
+	runes []rune
+	level int
+}
+
+// !NOTE: bidi.Paragraph doesn't give the levels it resolved, only the direction of each run, so the
+// + embedding levels of the controls are worked out again with rules X1 to X8 of UAX #9. The direction
+// + of the character then picks the implicit level (I1 and I2) on top of its embedding level
+
+const maxBidiDepth = 125
+
+type bidiStatus struct {
+	level    int
+	override bidi.Class
+	isolate  bool
+}
+
+// resolveLevels returns the level of each character, rtl is the direction bidi.Paragraph resolved for it
+func resolveLevels(text []rune, base int, rtl []bool) []int {
+	explicit, override := explicitLevels(text, base)
+	levels := make([]int, len(text))
+	for i, r := range text {
+		e := explicit[i]
+		switch {
+		case e%2 == 1 && !rtl[i]:
+			levels[i] = e + 1
+		case e%2 == 0 && rtl[i]:
+			levels[i] = e + 1
+		case e%2 == 0 && !override[i] && isNumber(r) && !afterLeftToRight(text, explicit, i):
+			// Numbers that aren't turned into L by rule W7 are raised by two
+			levels[i] = e + 2
+		default:
+			levels[i] = e
+		}
+	}
+	return levels
+}
+
+// explicitLevels applies the embeddings, overrides and isolates of the text, the controls get the level
+// of the text around them
+func explicitLevels(text []rune, base int) ([]int, []bool) {
+	levels := make([]int, len(text))
+	override := make([]bool, len(text))
+	stack := []bidiStatus{{level: base, override: bidi.ON}}
+	overflowIsolates, overflowEmbeddings, validIsolates := 0, 0, 0
+
+	next := func(rtl bool) int {
+		l := stack[len(stack)-1].level + 1
+		if rtl == (l%2 == 0) {
+			l++
+		}
+		return l
+	}
+
+	for i, r := range text {
+		top := stack[len(stack)-1]
+		levels[i] = top.level
+		override[i] = top.override != bidi.ON
+		switch r {
+		case '\u202A', '\u202B', '\u202D', '\u202E':
+			l := next(r == '\u202B' || r == '\u202E')
+			if l <= maxBidiDepth && overflowIsolates == 0 && overflowEmbeddings == 0 {
+				status := bidiStatus{level: l, override: bidi.ON}
+				if r == '\u202D' {
+					status.override = bidi.L
+				} else if r == '\u202E' {
+					status.override = bidi.R
+				}
+				stack = append(stack, status)
+			} else if overflowIsolates == 0 {
+				overflowEmbeddings++
+			}
+		case '\u2066', '\u2067', '\u2068':
+			rtl := r == '\u2067'
+			if r == '\u2068' {
+				rtl = firstStrongRTL(text[i+1:])
+			}
+			l := next(rtl)
+			if l <= maxBidiDepth && overflowIsolates == 0 && overflowEmbeddings == 0 {
+				validIsolates++
+				stack = append(stack, bidiStatus{level: l, override: bidi.ON, isolate: true})
+			} else {
+				overflowIsolates++
+			}
+		case '\u2069':
+			if overflowIsolates > 0 {
+				overflowIsolates--
+			} else if validIsolates > 0 {
+				overflowEmbeddings = 0
+				for !stack[len(stack)-1].isolate {
+					stack = stack[:len(stack)-1]
+				}
+				stack = stack[:len(stack)-1]
+				validIsolates--
+			}
+			top = stack[len(stack)-1]
+			levels[i] = top.level
+			override[i] = top.override != bidi.ON
+		case '\u202C':
+			if overflowIsolates > 0 {
+				break
+			}
+			if overflowEmbeddings > 0 {
+				overflowEmbeddings--
+			} else if !top.isolate && len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return levels, override
+}
+
+// firstStrongRTL reports if the first strong character of the text is right to left, isolates are skipped
+// and the text ends at a unmatched PDI
+func firstStrongRTL(text []rune) bool {
+	depth := 0
+	for _, r := range text {
+		switch r {
+		case '\u2066', '\u2067', '\u2068':
+			depth++
+			continue
+		case '\u2069':
+			if depth == 0 {
+				return false
+			}
+			depth--
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		p, _ := bidi.LookupRune(r)
+		switch p.Class() {
+		case bidi.L:
+			return false
+		case bidi.R, bidi.AL:
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(r rune) bool {
+	p, _ := bidi.LookupRune(r)
+	return p.Class() == bidi.EN || p.Class() == bidi.AN
+}
+
+// afterLeftToRight reports if the strong character before the number at i is L, European numbers after
+// L are L (W7). Arabic numbers are never changed
+func afterLeftToRight(text []rune, levels []int, i int) bool {
+	if p, _ := bidi.LookupRune(text[i]); p.Class() == bidi.AN {
+		return false
+	}
+	for j := i - 1; j >= 0; j-- {
+		if levels[j] > levels[i] {
+			continue
+		}
+		if levels[j] < levels[i] {
+			break
+		}
+		p, _ := bidi.LookupRune(text[j])
+		switch p.Class() {
+		case bidi.L:
+			return true
+		case bidi.R, bidi.AL:
+			return false
+		}
+	}
+	// The start of the sequence is L at a even level
+	return true
+}
+
+// reorder puts the items of the line in visual order
+func (ic *inlineContext) reorder(line *lineBox) {
+	style := ic.root.ComputedStyle
+	base := 0
+	if ic.rtl {
+		base = 1
+	}
+
+	// Skip lines that are only left to right text
+	mixed := ic.rtl
+	for _, item := range line.items {
+		if !item.hidden && item.text != "" && hasRightToLeft(item.text) {
+			mixed = true
+			break
+		}
+	}
+	if !mixed && style["unicode-bidi"] != "bidi-override" && style["unicode-bidi"] != "isolate-override" {
+		return
+	}
+
+	text := []rune{}
+	owners := []int{}
+	add := func(s []rune, owner int) {
+		text = append(text, s...)
+		for range s {
+			owners = append(owners, owner)
+		}
+	}
+
+	// A left to right mark keeps the paragraph level at 0, right to left is set as the default direction
+	opts := []bidi.Option{}
+	if ic.rtl {
+		opts = append(opts, bidi.DefaultDirection(bidi.RightToLeft))
+	} else if style["unicode-bidi"] != "plaintext" {
+		add([]rune{'\u200E'}, -1)
+	}
+	if style["unicode-bidi"] == "bidi-override" || style["unicode-bidi"] == "isolate-override" {
+		open, _ := bidiControls(style["direction"], "bidi-override")
+		add([]rune(open), -1)
+	}
+
+	items := []*inlineItem{}
+	open := []*Node{}
+	for _, item := range line.items {
+		if item.hidden {
+			continue
+		}
+		// Close the boxes the item isn't in, then open the new ones
+		common := 0
+		for common < len(open) && common < len(item.boxes) && open[common] == item.boxes[common] {
+			common++
+		}
+		for i := len(open) - 1; i >= common; i-- {
+			_, end := bidiControls(open[i].ComputedStyle["direction"], open[i].ComputedStyle["unicode-bidi"])
+			add([]rune(end), -1)
+		}
+		open = open[:common]
+		for _, b := range item.boxes[common:] {
+			start, _ := bidiControls(b.ComputedStyle["direction"], b.ComputedStyle["unicode-bidi"])
+			add([]rune(start), -1)
+			open = append(open, b)
+		}
+
+		if item.text != "" {
+			add([]rune(item.text), len(items))
+		} else {
+			add([]rune{objectReplacement}, len(items))
+		}
+		items = append(items, item)
+	}
+
+	var p bidi.Paragraph
+	if _, err := p.SetString(string(text), opts...); err != nil {
+		return
+	}
+	o, err := p.Order()
+	if err != nil {
+		return
+	}
+	// The ordering only keeps the direction of the runs, the levels are resolved again from it
+	rtl := make([]bool, len(text))
+	for i := 0; i < o.NumRuns(); i++ {
+		r := o.Run(i)
+		start, end := r.Pos()
+		for k := start; k <= end && k < len(rtl); k++ {
+			rtl[k] = r.Direction() == bidi.RightToLeft
+		}
+	}
+	if style["unicode-bidi"] == "plaintext" && !ic.rtl && firstStrongRTL(text) {
+		base = 1
+	}
+	levels := resolveLevels(text, base, rtl)
+
+	// Split the items where the level changes
+	pieces := []*bidiPiece{}
+	for i, owner := range owners {
+		if owner < 0 {
+			continue
+		}
+		if l := len(pieces); l > 0 && pieces[l-1].item == items[owner] && pieces[l-1].level == levels[i] {
+			pieces[l-1].runes = append(pieces[l-1].runes, text[i])
+			continue
+		}
+		pieces = append(pieces, &bidiPiece{item: items[owner], runes: []rune{text[i]}, level: levels[i]})
+	}
+
+	// L2: From the highest level to the lowest odd level, reverse any sequence at that level or higher
+	maxLevel, lowestOdd := 0, base|1
+	for _, v := range pieces {
+		maxLevel = max(maxLevel, v.level)
+	}
+	for level := maxLevel; level >= lowestOdd; level-- {
+		for i := 0; i < len(pieces); i++ {
+			if pieces[i].level < level {
+				continue
+			}
+			j := i
+			for j < len(pieces) && pieces[j].level >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				pieces[a], pieces[b] = pieces[b], pieces[a]
+			}
+			i = j
+		}
+	}
+
+	visual := []*inlineItem{}
+	for _, v := range pieces {
+		item := v.item
+		if item.text != "" {
+			split := *item
+			split.text = string(v.runes)
+			if v.level%2 == 1 {
+				split.text = bidi.ReverseString(split.text)
+			}
+			if split.text != item.text {
+				split.width = float32(MeasureText(item.meta, split.text))
+				split.trailing = 0
+			}
+			item = &split
+		}
+		visual = append(visual, item)
+	}
+	for _, item := range line.items {
+		if item.hidden {
+			visual = append(visual, item)
+		}
+	}
+	line.items = visual
+}
+
+type arabicForms struct {
+	isolated, final, initial, medial rune
+}
+
+// Presentation forms of the Arabic letters, letters without a initial form only join to the letter before them
+var arabicLetters = map[rune]arabicForms{
+	0x0621: {0xFE80, 0, 0, 0},
+	0x0622: {0xFE81, 0xFE82, 0, 0},
+	0x0623: {0xFE83, 0xFE84, 0, 0},
+	0x0624: {0xFE85, 0xFE86, 0, 0},
+	0x0625: {0xFE87, 0xFE88, 0, 0},
+	0x0626: {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	0x0627: {0xFE8D, 0xFE8E, 0, 0},
+	0x0628: {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	0x0629: {0xFE93, 0xFE94, 0, 0},
+	0x062A: {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	0x062B: {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	0x062C: {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	0x062D: {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	0x062E: {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	0x062F: {0xFEA9, 0xFEAA, 0, 0},
+	0x0630: {0xFEAB, 0xFEAC, 0, 0},
+	0x0631: {0xFEAD, 0xFEAE, 0, 0},
+	0x0632: {0xFEAF, 0xFEB0, 0, 0},
+	0x0633: {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	0x0634: {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	0x0635: {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	0x0636: {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	0x0637: {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	0x0638: {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	0x0639: {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	0x063A: {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	0x0640: {0x0640, 0x0640, 0x0640, 0x0640},
+	0x0641: {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	0x0642: {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	0x0643: {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	0x0644: {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	0x0645: {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	0x0646: {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	0x0647: {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	0x0648: {0xFEED, 0xFEEE, 0, 0},
+	0x0649: {0xFEEF, 0xFEF0, 0, 0},
+	0x064A: {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+	0x067E: {0xFB56, 0xFB57, 0xFB58, 0xFB59},
+	0x0686: {0xFB7A, 0xFB7B, 0xFB7C, 0xFB7D},
+	0x0698: {0xFB8A, 0xFB8B, 0, 0},
+	0x06A9: {0xFB8E, 0xFB8F, 0xFB90, 0xFB91},
+	0x06AF: {0xFB92, 0xFB93, 0xFB94, 0xFB95},
+	0x06CC: {0xFBFC, 0xFBFD, 0xFBFE, 0xFBFF},
+}
+
+// Lam followed by a alef is drawn as a single ligature, isolated and final forms
+var lamAlef = map[rune][2]rune{
+	0x0622: {0xFEF5, 0xFEF6},
+	0x0623: {0xFEF7, 0xFEF8},
+	0x0625: {0xFEF9, 0xFEFA},
+	0x0627: {0xFEFB, 0xFEFC},
+}
+
+// Harakat don't break the joining of the letters around them
+func isTransparent(r rune) bool {
+	return (r >= 0x064B && r <= 0x065F) || r == 0x0670
+}
+
+// ShapeArabic replaces Arabic letters with their contextual presentation forms, the text is in logical order
+func ShapeArabic(text string) string {
+	runes := []rune(text)
+	hasArabic := false
+	for _, r := range runes {
+		if _, ok := arabicLetters[r]; ok {
+			hasArabic = true
+			break
+		}
+	}
+	if !hasArabic {
+		return text
+	}
+
+	neighbor := func(i, step int) (arabicForms, bool) {
+		for j := i + step; j >= 0 && j < len(runes); j += step {
+			if isTransparent(runes[j]) {
+				continue
+			}
+			f, ok := arabicLetters[runes[j]]
+			return f, ok
+		}
+		return arabicForms{}, false
+	}
+
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		forms, ok := arabicLetters[runes[i]]
+		if !ok {
+			out = append(out, runes[i])
+			continue
+		}
+		prev, hasPrev := neighbor(i, -1)
+		next, hasNext := neighbor(i, 1)
+		// The letter before has to join forward (have a initial form) and this one has to join backwards
+		joinsPrev := hasPrev && prev.initial != 0 && forms.final != 0
+		joinsNext := hasNext && forms.initial != 0 && next.final != 0
+
+		if runes[i] == 0x0644 && hasNext {
+			j := i + 1
+			for j < len(runes) && isTransparent(runes[j]) {
+				j++
+			}
+			if j < len(runes) {
+				if lig, ok := lamAlef[runes[j]]; ok {
+					if joinsPrev {
+						out = append(out, lig[1])
+					} else {
+						out = append(out, lig[0])
+					}
+					out = append(out, runes[i+1:j]...)
+					i = j
+					continue
+				}
+			}
+		}
+
+		switch {
+		case joinsPrev && joinsNext:
+			out = append(out, forms.medial)
+		case joinsPrev:
+			out = append(out, forms.final)
+		case joinsNext:
+			out = append(out, forms.initial)
+		default:
+			out = append(out, forms.isolated)
+		}
+	}
+	return string(out)
+}
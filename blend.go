@@ -0,0 +1,246 @@
+package grim
+
+import (
+	"grim/canvas"
+	"image"
+	"math"
+)
+
+// !DEVMAN: The blend modes are the ones from the Compositing and Blending spec. B(cb, cs) mixes the color
+// + of the backdrop (cb) and the source (cs), the result is then composited source-over where both
+// + are opaque: cs' = (1 - ab) * cs + ab * B(cb, cs). background-blend-mode uses it in generateBackground,
+// + mix-blend-mode makes the element a Layer and adapters blend it with BlendImages
+
+var separableBlends = map[string]func(cb, cs float64) float64{
+	"normal": func(cb, cs float64) float64 {
+		return cs
+	},
+	"multiply": func(cb, cs float64) float64 {
+		return cb * cs
+	},
+	"screen": func(cb, cs float64) float64 {
+		return cb + cs - cb*cs
+	},
+	"overlay": func(cb, cs float64) float64 {
+		return hardLight(cs, cb)
+	},
+	"darken":  math.Min,
+	"lighten": math.Max,
+	"color-dodge": func(cb, cs float64) float64 {
+		if cb == 0 {
+			return 0
+		}
+		if cs >= 1 {
+			return 1
+		}
+		return math.Min(1, cb/(1-cs))
+	},
+	"color-burn": func(cb, cs float64) float64 {
+		if cb >= 1 {
+			return 1
+		}
+		if cs <= 0 {
+			return 0
+		}
+		return 1 - math.Min(1, (1-cb)/cs)
+	},
+	"hard-light": hardLight,
+	"soft-light": func(cb, cs float64) float64 {
+		if cs <= 0.5 {
+			return cb - (1-2*cs)*cb*(1-cb)
+		}
+		d := math.Sqrt(cb)
+		if cb <= 0.25 {
+			d = ((16*cb-12)*cb + 4) * cb
+		}
+		return cb + (2*cs-1)*(d-cb)
+	},
+	"difference": func(cb, cs float64) float64 {
+		return math.Abs(cb - cs)
+	},
+	"exclusion": func(cb, cs float64) float64 {
+		return cb + cs - 2*cb*cs
+	},
+}
+
+func hardLight(cb, cs float64) float64 {
+	if cs <= 0.5 {
+		return cb * 2 * cs
+	}
+	// screen(cb, 2cs - 1)
+	s := 2*cs - 1
+	return cb + s - cb*s
+}
+
+// The non-separable modes work on the hue, saturation and luminosity of the whole color
+func lum(c [3]float64) float64 {
+	return 0.3*c[0] + 0.59*c[1] + 0.11*c[2]
+}
+
+func clipColor(c [3]float64) [3]float64 {
+	l := lum(c)
+	n := math.Min(c[0], math.Min(c[1], c[2]))
+	x := math.Max(c[0], math.Max(c[1], c[2]))
+	for i := range c {
+		if n < 0 {
+			c[i] = l + (c[i]-l)*l/(l-n)
+		}
+		if x > 1 {
+			c[i] = l + (c[i]-l)*(1-l)/(x-l)
+		}
+	}
+	return c
+}
+
+func setLum(c [3]float64, l float64) [3]float64 {
+	d := l - lum(c)
+	return clipColor([3]float64{c[0] + d, c[1] + d, c[2] + d})
+}
+
+func sat(c [3]float64) float64 {
+	return math.Max(c[0], math.Max(c[1], c[2])) - math.Min(c[0], math.Min(c[1], c[2]))
+}
+
+func setSat(c [3]float64, s float64) [3]float64 {
+	max := math.Max(c[0], math.Max(c[1], c[2]))
+	min := math.Min(c[0], math.Min(c[1], c[2]))
+	var out [3]float64
+	if max > min {
+		for i := range c {
+			out[i] = (c[i] - min) * s / (max - min)
+		}
+	}
+	return out
+}
+
+// blendColor returns B(cb, cs) for the blend mode, unknown modes are normal
+func blendColor(mode string, cb, cs [3]float64) [3]float64 {
+	switch mode {
+	case "hue":
+		return setLum(setSat(cs, sat(cb)), lum(cb))
+	case "saturation":
+		return setLum(setSat(cb, sat(cs)), lum(cb))
+	case "color":
+		return setLum(cs, lum(cb))
+	case "luminosity":
+		return setLum(cb, lum(cs))
+	}
+	f, ok := separableBlends[mode]
+	if !ok {
+		return cs
+	}
+	return [3]float64{f(cb[0], cs[0]), f(cb[1], cs[1]), f(cb[2], cs[2])}
+}
+
+// blendOver composites src over dst in place with the blend mode, both images are the same size.
+// premultiplied tells if the pixels are premultiplied (images from canvas) or not (what adapters read back)
+func blendOver(dst, src *image.RGBA, mode string, premultiplied bool) {
+	for i := 0; i+3 < len(src.Pix) && i+3 < len(dst.Pix); i += 4 {
+		as := float64(src.Pix[i+3]) / 255
+		if as == 0 {
+			continue
+		}
+		ab := float64(dst.Pix[i+3]) / 255
+
+		var cs, cb [3]float64
+		for c := 0; c < 3; c++ {
+			cs[c] = float64(src.Pix[i+c]) / 255
+			cb[c] = float64(dst.Pix[i+c]) / 255
+			if premultiplied {
+				cs[c] /= as
+				if ab > 0 {
+					cb[c] /= ab
+				}
+			}
+		}
+
+		b := blendColor(mode, cb, cs)
+		ao := as + ab*(1-as)
+		for c := 0; c < 3; c++ {
+			mixed := (1-ab)*cs[c] + ab*b[c]
+			// Premultiplied result of source-over
+			co := mixed*as + cb[c]*ab*(1-as)
+			if !premultiplied {
+				co /= ao
+			}
+			dst.Pix[i+c] = uint8(math.Max(0, math.Min(255, co*255+0.5)))
+		}
+		dst.Pix[i+3] = uint8(ao*255 + 0.5)
+	}
+}
+
+// BlendImages draws src over dst with a mix-blend-mode, the pixels are not premultiplied
+func BlendImages(dst, src *image.RGBA, mode string) {
+	blendOver(dst, src, mode, false)
+}
+
+// clipBox returns the mask of a background-clip box (border-box, padding-box or content-box)
+// with the border radius of the element, the radius shrinks with the border and padding
+func clipBox(self State, box string, width, height int) *image.RGBA {
+	var top, right, bottom, left float32
+	if box == "padding-box" || box == "content-box" {
+		top, right, bottom, left = self.Border.Top.Width, self.Border.Right.Width, self.Border.Bottom.Width, self.Border.Left.Width
+	}
+	if box == "content-box" {
+		top += self.Padding.Top
+		right += self.Padding.Right
+		bottom += self.Padding.Bottom
+		left += self.Padding.Left
+	}
+	radius := func(r, a, b float32) float64 {
+		return float64(Max(0, r-Max(a, b)))
+	}
+
+	can := canvas.NewCanvas(width, height)
+	can.BeginPath()
+	can.SetFillStyle(255, 255, 255, 255)
+	can.RoundedRect(float64(left), float64(top), float64(width)-float64(left+right), float64(height)-float64(top+bottom), []float64{
+		radius(self.Border.Radius.TopLeft, top, left),
+		radius(self.Border.Radius.TopRight, top, right),
+		radius(self.Border.Radius.BottomRight, bottom, right),
+		radius(self.Border.Radius.BottomLeft, bottom, left),
+	})
+	can.Fill()
+	can.ClosePath()
+	return can.Context.Image().(*image.RGBA)
+}
+
+// textMask returns the mask of the text of the element and its descendants for background-clip: text
+func textMask(c CSS, text []Fragment, width, height int) *image.RGBA {
+	mask := image.NewRGBA(image.Rect(0, 0, width, height))
+	for _, f := range text {
+		img, ok := c.TextMasks[f.Texture]
+		if !ok {
+			continue
+		}
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			my := y - b.Min.Y + int(f.Y)
+			if my < 0 || my >= height {
+				continue
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				mx := x - b.Min.X + int(f.X)
+				if mx < 0 || mx >= width {
+					continue
+				}
+				_, _, _, a := img.At(x, y).RGBA()
+				i := mask.PixOffset(mx, my)
+				if v := uint8(a >> 8); v > mask.Pix[i+3] {
+					mask.Pix[i], mask.Pix[i+1], mask.Pix[i+2], mask.Pix[i+3] = v, v, v, v
+				}
+			}
+		}
+	}
+	return mask
+}
+
+// applyMask multiplies the premultiplied img by the alpha of mask
+func applyMask(img, mask *image.RGBA) {
+	for i := 0; i+3 < len(img.Pix) && i+3 < len(mask.Pix); i += 4 {
+		a := uint32(mask.Pix[i+3])
+		for c := 0; c < 4; c++ {
+			img.Pix[i+c] = uint8(uint32(img.Pix[i+c]) * a / 255)
+		}
+	}
+}
@@ -9,16 +9,20 @@ import (
 	"strings"
 )
 
-func parseBorder(cssProperties map[string]string, self, parent State) (Border, error) {
+func parseBorder(units unitContext, cssProperties map[string]string, current string, self, parent State) (Border, error) {
 	// Define default values
 	defaultWidth := "0px"
 	defaultStyle := "solid"
-	defaultColor := "#000000"
+	// Borders are currentcolor unless they have a color
+	if current == "" {
+		current = initialColor
+	}
+	defaultColor := current
 	defaultRadius := "0px"
 
 	// Helper function to parse border component
 	parseBorderComponent := func(value string) (width, style, color string) {
-		components := strings.Fields(value)
+		components := Token('(', ')', ' ', value)
 		width, style, color = defaultWidth, defaultStyle, defaultColor
 		widthSuffixes := []string{"px", "em", "pt", "pc", "%", "vw", "vh", "cm", "in"}
 
@@ -45,7 +49,7 @@ func parseBorder(cssProperties map[string]string, self, parent State) (Border, e
 		if value == "" {
 			value = defaultRadius
 		}
-		return ConvertToPixels(value, self.EM, parent.Width)
+		return units.px(value, self.EM, parent.Width)
 	}
 
 	// Parse individual border sides
@@ -99,16 +103,16 @@ func parseBorder(cssProperties map[string]string, self, parent State) (Border, e
 	}
 
 	// Convert to pixels
-	topWidthPx := ConvertToPixels(topWidth, self.EM, parent.Width)
-	rightWidthPx := ConvertToPixels(rightWidth, self.EM, parent.Width)
-	bottomWidthPx := ConvertToPixels(bottomWidth, self.EM, parent.Width)
-	leftWidthPx := ConvertToPixels(leftWidth, self.EM, parent.Width)
+	topWidthPx := units.px(topWidth, self.EM, parent.Width)
+	rightWidthPx := units.px(rightWidth, self.EM, parent.Width)
+	bottomWidthPx := units.px(bottomWidth, self.EM, parent.Width)
+	leftWidthPx := units.px(leftWidth, self.EM, parent.Width)
 
 	// Parse colors
-	topParsedColor, _ := color.Color(topColor)
-	rightParsedColor, _ := color.Color(rightColor)
-	bottomParsedColor, _ := color.Color(bottomColor)
-	leftParsedColor, _ := color.Color(leftColor)
+	topParsedColor, _ := color.Color(color.ResolveCurrentColor(topColor, current))
+	rightParsedColor, _ := color.Color(color.ResolveCurrentColor(rightColor, current))
+	bottomParsedColor, _ := color.Color(color.ResolveCurrentColor(bottomColor, current))
+	leftParsedColor, _ := color.Color(color.ResolveCurrentColor(leftColor, current))
 
 	width := self.Width + self.Border.Left.Width + self.Border.Right.Width
 	height := self.Height + self.Border.Top.Width + self.Border.Bottom.Width
@@ -132,7 +136,7 @@ func parseBorder(cssProperties map[string]string, self, parent State) (Border, e
 		}
 	}
 
-	return Border{
+	border := Border{
 		Top: BorderSide{
 			Width: topWidthPx,
 			Style: topStyle,
@@ -159,20 +163,24 @@ func parseBorder(cssProperties map[string]string, self, parent State) (Border, e
 			BottomLeft:  bottomLeftRadius,
 			BottomRight: bottomRightRadius,
 		},
-	}, nil
+	}
+	border.Image = parseBorderImage(units, cssProperties, current, border, self.EM)
+	return border, nil
 }
 
-func drawBorder(self *State, a *Adapter, id string) {
+func drawBorder(self *State, c *CSS, id string) {
+	a := c.Adapter
 	// lastChange := time.Now()
 	if self.Border.Top.Width > 0 ||
 		self.Border.Right.Width > 0 ||
 		self.Border.Bottom.Width > 0 ||
-		self.Border.Left.Width > 0 {
+		self.Border.Left.Width > 0 ||
+		self.Border.Image.Source != "" {
 
 		// Format: widthheightborderdatatopleftbottomright
 		// borderdata: widthstylecolorradius
 		// 50020020solid#fff520solid#fff520solid#fff520solid#fff520solid#fff
-		key := strconv.Itoa(int(self.Width)) + strconv.Itoa(int(self.Height)) + (strconv.Itoa(int(self.Border.Top.Width)) + self.Border.Top.Style + RGBAtoString(self.Border.Top.Color) + strconv.Itoa(int(self.Border.Radius.TopLeft))) + (strconv.Itoa(int(self.Border.Left.Width)) + self.Border.Left.Style + RGBAtoString(self.Border.Left.Color) + strconv.Itoa(int(self.Border.Radius.BottomLeft))) + (strconv.Itoa(int(self.Border.Bottom.Width)) + self.Border.Bottom.Style + RGBAtoString(self.Border.Bottom.Color) + strconv.Itoa(int(self.Border.Radius.BottomRight))) + (strconv.Itoa(int(self.Border.Right.Width)) + self.Border.Right.Style + RGBAtoString(self.Border.Right.Color) + strconv.Itoa(int(self.Border.Radius.TopRight)))
+		key := strconv.Itoa(int(self.Width)) + strconv.Itoa(int(self.Height)) + (strconv.Itoa(int(self.Border.Top.Width)) + self.Border.Top.Style + RGBAtoString(self.Border.Top.Color) + strconv.Itoa(int(self.Border.Radius.TopLeft))) + (strconv.Itoa(int(self.Border.Left.Width)) + self.Border.Left.Style + RGBAtoString(self.Border.Left.Color) + strconv.Itoa(int(self.Border.Radius.BottomLeft))) + (strconv.Itoa(int(self.Border.Bottom.Width)) + self.Border.Bottom.Style + RGBAtoString(self.Border.Bottom.Color) + strconv.Itoa(int(self.Border.Radius.BottomRight))) + (strconv.Itoa(int(self.Border.Right.Width)) + self.Border.Right.Style + RGBAtoString(self.Border.Right.Color) + strconv.Itoa(int(self.Border.Radius.TopRight))) + borderImageKey(self.Border.Image)
 
 		m, exists := a.Textures[id]["border"]
 
@@ -180,6 +188,16 @@ func drawBorder(self *State, a *Adapter, id string) {
 			if exists {
 				a.UnloadTexture(id, "border")
 			}
+			if self.Border.Image.Source != "" {
+				if img := drawBorderImage(c, self); img != nil {
+					a.LoadTexture(id, "border", key, img)
+					self.Textures["border"] = key
+					return
+				}
+				// The border styles are used when the image can't be loaded
+				// !ISSUE: the outset comes back the next time the border is parsed while the texture is cached
+				self.Border.Image.Outset = BorderImageOutset{}
+			}
 			w := int(self.X + self.Width + self.Border.Left.Width + self.Border.Right.Width)
 			h := int(self.Y + self.Height + self.Border.Top.Width + self.Border.Bottom.Width)
 
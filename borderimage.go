@@ -0,0 +1,328 @@
+package grim
+
+import (
+	"grim/canvas"
+	"grim/color"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// !DEVMAN: border-image replaces the border styles with a image cut into 9 pieces by border-image-slice.
+// + The corners are scaled into the corners of the border image area, the edges are stretched, repeated,
+// + rounded or spaced along the sides and the middle is only drawn with the fill keyword. The area is the
+// + border box grown by border-image-outset, the adapter draws the border texture at the outset
+
+type BorderImage struct {
+	Source string
+	Slice  string
+	Width  string
+	Repeat string
+	// Outset is resolved to pixels because the adapter needs it to place the texture
+	Outset BorderImageOutset
+}
+
+type BorderImageOutset struct {
+	Top    float32
+	Right  float32
+	Bottom float32
+	Left   float32
+}
+
+var borderImageRepeats = map[string]bool{
+	"stretch": true,
+	"repeat":  true,
+	"round":   true,
+	"space":   true,
+}
+
+// parseBorderImage parses border-image and its longhands, the longhands win over the shorthand
+func parseBorderImage(units unitContext, style map[string]string, current string, border Border, em float32) BorderImage {
+	bi := BorderImage{
+		Slice:  "100%",
+		Width:  "1",
+		Repeat: "stretch",
+	}
+	outset := "0"
+
+	if shorthand := strings.TrimSpace(style["border-image"]); shorthand != "" && shorthand != "none" {
+		// source slice / width / outset repeat, the source, fill and repeat can be anywhere
+		slice, repeat := []string{}, []string{}
+		for i, segment := range Token('(', ')', '/', shorthand) {
+			rest := []string{}
+			for _, v := range Token('(', ')', ' ', segment) {
+				switch {
+				case strings.HasPrefix(v, "url(") || isGradient(v):
+					bi.Source = v
+				case borderImageRepeats[v]:
+					repeat = append(repeat, v)
+				case v == "fill":
+					slice = append(slice, v)
+				default:
+					rest = append(rest, v)
+				}
+			}
+			if len(rest) == 0 {
+				continue
+			}
+			switch i {
+			case 0:
+				slice = append(slice, rest...)
+			case 1:
+				bi.Width = strings.Join(rest, " ")
+			case 2:
+				outset = strings.Join(rest, " ")
+			}
+		}
+		if len(slice) > 0 {
+			bi.Slice = strings.Join(slice, " ")
+		}
+		if len(repeat) > 0 {
+			bi.Repeat = strings.Join(repeat, " ")
+		}
+	}
+
+	if v := strings.TrimSpace(style["border-image-source"]); v != "" {
+		bi.Source = v
+	}
+	if bi.Source == "none" {
+		bi.Source = ""
+	}
+	// Gradients can have currentcolor stops
+	bi.Source = color.ResolveCurrentColor(bi.Source, current)
+	if v := strings.TrimSpace(style["border-image-slice"]); v != "" {
+		bi.Slice = v
+	}
+	if v := strings.TrimSpace(style["border-image-width"]); v != "" {
+		bi.Width = v
+	}
+	if v := strings.TrimSpace(style["border-image-outset"]); v != "" {
+		outset = v
+	}
+	if v := strings.TrimSpace(style["border-image-repeat"]); v != "" {
+		bi.Repeat = v
+	}
+
+	// Numbers are multiples of the border width
+	o := boxSides(outset)
+	widths := [4]float32{border.Top.Width, border.Right.Width, border.Bottom.Width, border.Left.Width}
+	var px [4]float32
+	for i, v := range o {
+		if n, err := strconv.ParseFloat(v, 32); err == nil {
+			px[i] = float32(n) * widths[i]
+		} else {
+			px[i] = units.px(v, em, 0)
+		}
+	}
+	bi.Outset = BorderImageOutset{Top: px[0], Right: px[1], Bottom: px[2], Left: px[3]}
+	return bi
+}
+
+// boxSides expands a 1 to 4 value list to top, right, bottom and left like margin and padding
+func boxSides(value string) [4]string {
+	p := strings.Fields(value)
+	switch len(p) {
+	case 0:
+		return [4]string{"0", "0", "0", "0"}
+	case 1:
+		return [4]string{p[0], p[0], p[0], p[0]}
+	case 2:
+		return [4]string{p[0], p[1], p[0], p[1]}
+	case 3:
+		return [4]string{p[0], p[1], p[2], p[1]}
+	}
+	return [4]string{p[0], p[1], p[2], p[3]}
+}
+
+func borderImageKey(bi BorderImage) string {
+	if bi.Source == "" {
+		return ""
+	}
+	return bi.Source + bi.Slice + bi.Width + bi.Repeat + strconv.Itoa(int(bi.Outset.Top)) + strconv.Itoa(int(bi.Outset.Right)) + strconv.Itoa(int(bi.Outset.Bottom)) + strconv.Itoa(int(bi.Outset.Left))
+}
+
+// drawBorderImage renders the border image of the element, it returns nil if the source can't be loaded
+func drawBorderImage(c *CSS, self *State) image.Image {
+	bi := self.Border.Image
+	width := self.Width + self.Border.Left.Width + self.Border.Right.Width + bi.Outset.Left + bi.Outset.Right
+	height := self.Height + self.Border.Top.Width + self.Border.Bottom.Width + bi.Outset.Top + bi.Outset.Bottom
+	if width < 1 || height < 1 {
+		return nil
+	}
+
+	var src image.Image
+	if strings.HasPrefix(bi.Source, "url(") {
+		src = loadBackgroundImage(*c, bi.Source)
+	} else if g, ok := parseGradient(c.unitsOf(nil), bi.Source, float64(width), float64(height), self.EM); ok {
+		src = g.render(int(width), int(height))
+	}
+	if src == nil {
+		return nil
+	}
+	b := src.Bounds()
+	iw, ih := float32(b.Dx()), float32(b.Dy())
+
+	// Slices are in image pixels or percentages of the image
+	fill := false
+	sliceValues := []string{}
+	for _, v := range strings.Fields(bi.Slice) {
+		if v == "fill" {
+			fill = true
+		} else {
+			sliceValues = append(sliceValues, v)
+		}
+	}
+	var slice [4]float32
+	for i, v := range boxSides(strings.Join(sliceValues, " ")) {
+		size := ih
+		if i%2 == 1 {
+			size = iw
+		}
+		if strings.HasSuffix(v, "%") {
+			slice[i] = Min(size, parseAmount(v, 0)*size)
+		} else {
+			n, _ := strconv.ParseFloat(v, 32)
+			slice[i] = Min(size, float32(n))
+		}
+	}
+
+	// Widths are lengths, percentages of the area, multiples of the border width or the size of the slice
+	borders := [4]float32{self.Border.Top.Width, self.Border.Right.Width, self.Border.Bottom.Width, self.Border.Left.Width}
+	var widths [4]float32
+	for i, v := range boxSides(bi.Width) {
+		size := height
+		if i%2 == 1 {
+			size = width
+		}
+		if v == "auto" {
+			widths[i] = slice[i]
+		} else if n, err := strconv.ParseFloat(v, 32); err == nil {
+			widths[i] = float32(n) * borders[i]
+		} else {
+			widths[i] = c.ConvertToPixels(v, self.EM, size)
+		}
+	}
+	// Opposite widths that don't fit are all scaled down by the same amount
+	f := float32(1)
+	if s := widths[0] + widths[2]; s > height {
+		f = Min(f, height/s)
+	}
+	if s := widths[1] + widths[3]; s > width {
+		f = Min(f, width/s)
+	}
+	for i := range widths {
+		widths[i] *= f
+	}
+
+	repeat := strings.Fields(bi.Repeat)
+	if len(repeat) == 0 {
+		repeat = []string{"stretch"}
+	}
+	if len(repeat) == 1 {
+		repeat = append(repeat, repeat[0])
+	}
+
+	ctx := canvas.NewCanvas(int(math.Ceil(float64(width))), int(math.Ceil(float64(height))))
+	dst := ctx.Context.Image().(*image.RGBA)
+
+	top, right, bottom, left := slice[0], slice[1], slice[2], slice[3]
+	wt, wr, wb, wl := widths[0], widths[1], widths[2], widths[3]
+	// Source and destination columns and rows of the nine pieces
+	sx := [4]float32{0, left, iw - right, iw}
+	sy := [4]float32{0, top, ih - bottom, ih}
+	dx := [4]float32{0, wl, width - wr, width}
+	dy := [4]float32{0, wt, height - wb, height}
+
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			if row == 1 && col == 1 && !fill {
+				continue
+			}
+			from := image.Rect(b.Min.X+int(sx[col]), b.Min.Y+int(sy[row]), b.Min.X+int(sx[col+1]), b.Min.Y+int(sy[row+1]))
+			to := [4]float32{dx[col], dy[row], dx[col+1], dy[row+1]}
+			if from.Empty() || to[2] <= to[0] || to[3] <= to[1] {
+				continue
+			}
+
+			// The size of one tile, corners are always stretched. Edges keep the ratio of the slice
+			// scaled to the width of the border, the middle is scaled like the top and left edges
+			tw, th := to[2]-to[0], to[3]-to[1]
+			modeX, modeY := "stretch", "stretch"
+			if col == 1 {
+				modeX = repeat[0]
+				scale := float32(1)
+				if row == 1 {
+					if top > 0 {
+						scale = wt / top
+					}
+				} else {
+					scale = th / float32(from.Dy())
+				}
+				tw = float32(from.Dx()) * scale
+			}
+			if row == 1 {
+				modeY = repeat[1]
+				scale := float32(1)
+				if col == 1 {
+					if left > 0 {
+						scale = wl / left
+					}
+				} else {
+					scale = tw / float32(from.Dx())
+				}
+				th = float32(from.Dy()) * scale
+			}
+
+			xs, tw := borderImageTiles(modeX, to[0], to[2], tw)
+			ys, th := borderImageTiles(modeY, to[1], to[3], th)
+			area := dst.SubImage(image.Rect(int(to[0]), int(to[1]), int(math.Ceil(float64(to[2]))), int(math.Ceil(float64(to[3]))))).(*image.RGBA)
+			for _, y := range ys {
+				for _, x := range xs {
+					r := image.Rect(int(math.Round(float64(x))), int(math.Round(float64(y))), int(math.Round(float64(x+tw))), int(math.Round(float64(y+th))))
+					draw.ApproxBiLinear.Scale(area, r, src, from, draw.Over, nil)
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// borderImageTiles returns where the tiles of a piece go between start and end and the size of the tiles
+func borderImageTiles(mode string, start, end, size float32) ([]float32, float32) {
+	length := end - start
+	if size <= 0 || mode == "stretch" {
+		return []float32{start}, length
+	}
+	switch mode {
+	case "round":
+		n := Max(1, float32(math.Round(float64(length/size))))
+		size = length / n
+		positions := []float32{}
+		for i := float32(0); i < n; i++ {
+			positions = append(positions, start+i*size)
+		}
+		return positions, size
+	case "space":
+		n := float32(math.Floor(float64(length / size)))
+		if n < 1 {
+			return nil, size
+		}
+		gap := (length - n*size) / (n + 1)
+		positions := []float32{}
+		for i := float32(0); i < n; i++ {
+			positions = append(positions, start+gap+i*(size+gap))
+		}
+		return positions, size
+	}
+	// repeat centers a tile in the middle and repeats it out to the ends
+	first := start + length/2 - size/2
+	first -= float32(math.Ceil(float64((first-start)/size))) * size
+	positions := []float32{}
+	for p := first; p < end; p += size {
+		positions = append(positions, p)
+	}
+	return positions, size
+}
@@ -0,0 +1,625 @@
+package grim
+
+import (
+	"grim/gg"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// !DEVMAN: Clipping is done with layers (see effects.go). A element with a clip-path, or a overflow
+// + container with rounded corners, gets a Layer with the shape it is clipped to. setLayers turns the
+// + shape into polygons in window coordinates once the layout is done and getRenderData loads a mask
+// + texture for it, the adapter multiplies the alpha of the layer by the mask. Clips inside of clips
+// + are layers inside of layers so they intersect without doing anything else. A overflow clip is the
+// + padding box and only applies to the descendants (Layer.ClipsDescendants), the element itself keeps its
+// + border, box-shadow and outline
+
+type Clip struct {
+	// Paths are closed polygons in window coordinates, curves are flattened
+	Paths   [][]Point
+	EvenOdd bool
+}
+
+type Point struct {
+	X float32
+	Y float32
+}
+
+// clipShape returns the clip of a layer for the final position of the element, nil if the clip-path isn't valid
+func clipShape(units unitContext, self State, l *Layer) *Clip {
+	var clip *Clip
+	if l.ClipPath != "" {
+		clip = parseClipPath(units, l.ClipPath, self)
+	} else if l.ClipOverflow {
+		// Overflow clips to the padding box, the inner radius of a corner is the radius less the borders
+		b, r := self.Border, self.Border.Radius
+		inner := func(radius, w1, w2 float32) float32 {
+			return Max(0, radius-Max(w1, w2))
+		}
+		clip = &Clip{Paths: [][]Point{roundedRect(b.Left.Width, b.Top.Width, self.Width, self.Height, [4]float32{
+			inner(r.TopLeft, b.Top.Width, b.Left.Width),
+			inner(r.TopRight, b.Top.Width, b.Right.Width),
+			inner(r.BottomRight, b.Bottom.Width, b.Right.Width),
+			inner(r.BottomLeft, b.Bottom.Width, b.Left.Width),
+		})}}
+	}
+	if clip == nil {
+		return nil
+	}
+
+	// The shape is relative to the border box, move it to the window and through the transform
+	for _, path := range clip.Paths {
+		for i, p := range path {
+			x, y := p.X+self.X, p.Y+self.Y
+			if self.Transform != nil {
+				tx, ty := self.Transform.TransformPoint(float64(x), float64(y))
+				x, y = float32(tx), float32(ty)
+			}
+			path[i] = Point{x, y}
+		}
+	}
+	return clip
+}
+
+// bounds returns the box around all of the paths
+func (c *Clip) bounds() (x1, y1, x2, y2 float32) {
+	x1, y1 = float32(math.Inf(1)), float32(math.Inf(1))
+	x2, y2 = float32(math.Inf(-1)), float32(math.Inf(-1))
+	for _, path := range c.Paths {
+		for _, p := range path {
+			x1, y1 = Min(x1, p.X), Min(y1, p.Y)
+			x2, y2 = Max(x2, p.X), Max(y2, p.Y)
+		}
+	}
+	return x1, y1, x2, y2
+}
+
+// key identifies the mask of the clip in a layer at x, y by the size of the layer and the shape relative to
+// it, so the mask isn't drawn again when the layer only moves (scrolling)
+func (c *Clip) key(x, y, width, height float32) string {
+	var b strings.Builder
+	b.WriteString("clip")
+	for _, v := range []float32{width, height} {
+		b.WriteString(strconv.Itoa(int(v)) + ",")
+	}
+	if c.EvenOdd {
+		b.WriteString("e")
+	}
+	for _, path := range c.Paths {
+		b.WriteString(";")
+		for _, p := range path {
+			b.WriteString(strconv.FormatFloat(float64(p.X-x), 'f', 1, 32) + " " + strconv.FormatFloat(float64(p.Y-y), 'f', 1, 32) + ",")
+		}
+	}
+	return b.String()
+}
+
+// loadClipMask loads the mask texture of the layer of the element, or unloads it when the element isn't clipped
+func loadClipMask(a *Adapter, id string, self State) {
+	l := self.Layer
+	if l == nil || l.Clip == nil || l.Width < 1 || l.Height < 1 {
+		if _, exists := a.Textures[id]["clip"]; exists {
+			a.UnloadTexture(id, "clip")
+		}
+		if l != nil {
+			l.Mask = ""
+		}
+		return
+	}
+
+	key := l.Clip.key(l.X, l.Y, l.Width, l.Height)
+	l.Mask = key
+	if a.Textures[id]["clip"] == key {
+		return
+	}
+	if _, exists := a.Textures[id]["clip"]; exists {
+		a.UnloadTexture(id, "clip")
+	}
+
+	dc := gg.NewContext(int(l.Width), int(l.Height))
+	dc.SetRGBA255(255, 255, 255, 255)
+	if l.Clip.EvenOdd {
+		dc.SetFillRule(gg.FillRuleEvenOdd)
+	}
+	for _, path := range l.Clip.Paths {
+		for i, p := range path {
+			if i == 0 {
+				dc.MoveTo(float64(p.X-l.X), float64(p.Y-l.Y))
+			} else {
+				dc.LineTo(float64(p.X-l.X), float64(p.Y-l.Y))
+			}
+		}
+		dc.ClosePath()
+	}
+	dc.Fill()
+	a.LoadTexture(id, "clip", key, dc.Image())
+}
+
+// parseClipPath parses a basic shape with a optional reference box, the paths are relative to the border box
+func parseClipPath(units unitContext, value string, self State) *Clip {
+	var shape string
+	box := "border-box"
+	for _, v := range Token('(', ')', ' ', value) {
+		if strings.HasSuffix(v, ")") {
+			shape = v
+		} else {
+			box = v
+		}
+	}
+
+	// The reference box relative to the border box
+	var bx, by, bw, bh float32
+	bw = self.Width + self.Border.Left.Width + self.Border.Right.Width
+	bh = self.Height + self.Border.Top.Width + self.Border.Bottom.Width
+	switch box {
+	case "margin-box":
+		bx, by = -self.Margin.Left, -self.Margin.Top
+		bw += self.Margin.Left + self.Margin.Right
+		bh += self.Margin.Top + self.Margin.Bottom
+	case "padding-box", "content-box":
+		bx, by, bw, bh = originBox(self, box)
+	case "fill-box":
+		// Without SVG layout fill-box is the content box
+		bx, by, bw, bh = originBox(self, "content-box")
+	}
+
+	// Without a shape the reference box is the clip
+	if shape == "" {
+		return &Clip{Paths: [][]Point{{{bx, by}, {bx + bw, by}, {bx + bw, by + bh}, {bx, by + bh}}}}
+	}
+
+	open := strings.IndexByte(shape, '(')
+	name := strings.ToLower(shape[:open])
+	args := strings.TrimSpace(shape[open+1 : len(shape)-1])
+
+	var clip *Clip
+	switch name {
+	case "inset":
+		clip = insetShape(units, args, bw, bh, self.EM)
+	case "circle", "ellipse":
+		clip = ellipseShape(units, name, args, bw, bh, self.EM)
+	case "polygon":
+		clip = polygonShape(units, args, bw, bh, self.EM)
+	case "path":
+		clip = pathShape(args)
+	}
+	if clip == nil {
+		return nil
+	}
+	for _, path := range clip.Paths {
+		for i := range path {
+			path[i].X += bx
+			path[i].Y += by
+		}
+	}
+	return clip
+}
+
+// insetShape parses inset(top right bottom left round radius)
+func insetShape(units unitContext, args string, width, height, em float32) *Clip {
+	offsets, radii := args, ""
+	if i := strings.Index(args, "round"); i >= 0 {
+		offsets, radii = args[:i], args[i+len("round"):]
+	}
+	o := boxSides(offsets)
+	top := units.px(o[0], em, height)
+	right := units.px(o[1], em, width)
+	bottom := units.px(o[2], em, height)
+	left := units.px(o[3], em, width)
+
+	w, h := Max(0, width-left-right), Max(0, height-top-bottom)
+	// Radii go top left, top right, bottom right, bottom left like border-radius
+	var r [4]float32
+	if radii = strings.TrimSpace(radii); radii != "" {
+		rs := boxSides(radii)
+		for i, v := range rs {
+			r[i] = units.px(v, em, w)
+		}
+	}
+	return &Clip{Paths: [][]Point{roundedRect(left, top, w, h, r)}}
+}
+
+// ellipseShape parses circle(radius at position) and ellipse(rx ry at position)
+func ellipseShape(units unitContext, name, args string, width, height, em float32) *Clip {
+	parts := Token('(', ')', ' ', args)
+	sizes := []string{}
+	cx, cy := float64(width)/2, float64(height)/2
+	for i, v := range parts {
+		if v == "at" {
+			cx, cy = gradientPosition(units, parts[i+1:], float64(width), float64(height), em)
+			break
+		}
+		sizes = append(sizes, v)
+	}
+
+	// A radius on its own axis, percentages of a circle are relative to the diagonal
+	radius := func(v string, distances [2]float64, reference float32) float64 {
+		switch v {
+		case "", "closest-side":
+			return math.Min(distances[0], distances[1])
+		case "farthest-side":
+			return math.Max(distances[0], distances[1])
+		}
+		return float64(units.px(v, em, reference))
+	}
+	dx := [2]float64{cx, math.Abs(float64(width) - cx)}
+	dy := [2]float64{cy, math.Abs(float64(height) - cy)}
+
+	var rx, ry float64
+	if name == "circle" {
+		size := ""
+		if len(sizes) > 0 {
+			size = sizes[0]
+		}
+		diagonal := float32(math.Sqrt(float64(width*width+height*height)) / math.Sqrt2)
+		rx = radius(size, [2]float64{math.Min(dx[0], dx[1]), math.Min(dy[0], dy[1])}, diagonal)
+		if size == "farthest-side" {
+			rx = math.Max(math.Max(dx[0], dx[1]), math.Max(dy[0], dy[1]))
+		}
+		ry = rx
+	} else {
+		sx, sy := "", ""
+		if len(sizes) > 0 {
+			sx = sizes[0]
+		}
+		if len(sizes) > 1 {
+			sy = sizes[1]
+		}
+		rx = radius(sx, dx, width)
+		ry = radius(sy, dy, height)
+	}
+	return &Clip{Paths: [][]Point{ellipsePoints(cx, cy, rx, ry, 0, 2*math.Pi)}}
+}
+
+// polygonShape parses polygon(fill-rule, x y, x y, ...)
+func polygonShape(units unitContext, args string, width, height, em float32) *Clip {
+	clip := &Clip{}
+	path := []Point{}
+	for i, v := range Token('(', ')', ',', args) {
+		if i == 0 && (v == "evenodd" || v == "nonzero") {
+			clip.EvenOdd = v == "evenodd"
+			continue
+		}
+		xy := Token('(', ')', ' ', v)
+		if len(xy) != 2 {
+			return nil
+		}
+		path = append(path, Point{units.px(xy[0], em, width), units.px(xy[1], em, height)})
+	}
+	if len(path) < 3 {
+		return nil
+	}
+	clip.Paths = [][]Point{path}
+	return clip
+}
+
+// pathShape parses path(fill-rule, "svg path data")
+func pathShape(args string) *Clip {
+	clip := &Clip{}
+	// The path data has commas in it, only a fill rule before the string is split off
+	if rule, data, found := strings.Cut(args, ","); found && !strings.ContainsAny(rule, "\"'") {
+		clip.EvenOdd = strings.TrimSpace(rule) == "evenodd"
+		args = data
+	}
+	data := strings.Trim(strings.TrimSpace(args), "\"'")
+	clip.Paths = parseSVGPath(data)
+	if len(clip.Paths) == 0 {
+		return nil
+	}
+	return clip
+}
+
+// roundedRect returns the outline of a rectangle, r is top left, top right, bottom right and bottom left
+func roundedRect(x, y, width, height float32, r [4]float32) []Point {
+	// Radii that don't fit are scaled down together like border-radius
+	f := float32(1)
+	if s := r[0] + r[1]; s > width {
+		f = Min(f, width/s)
+	}
+	if s := r[3] + r[2]; s > width {
+		f = Min(f, width/s)
+	}
+	if s := r[0] + r[3]; s > height {
+		f = Min(f, height/s)
+	}
+	if s := r[1] + r[2]; s > height {
+		f = Min(f, height/s)
+	}
+	for i := range r {
+		r[i] *= f
+	}
+
+	corners := []struct {
+		cx, cy, r, start float32
+	}{
+		{x + r[0], y + r[0], r[0], math.Pi},
+		{x + width - r[1], y + r[1], r[1], 3 * math.Pi / 2},
+		{x + width - r[2], y + height - r[2], r[2], 0},
+		{x + r[3], y + height - r[3], r[3], math.Pi / 2},
+	}
+	points := []Point{}
+	for _, c := range corners {
+		if c.r <= 0 {
+			points = append(points, Point{c.cx, c.cy})
+			continue
+		}
+		points = append(points, ellipsePoints(float64(c.cx), float64(c.cy), float64(c.r), float64(c.r), float64(c.start), float64(c.start)+math.Pi/2)...)
+	}
+	return points
+}
+
+// ellipsePoints flattens a arc of a ellipse from angle a0 to a1 (clockwise on screen)
+func ellipsePoints(cx, cy, rx, ry, a0, a1 float64) []Point {
+	segments := int(math.Max(4, math.Ceil(math.Abs(a1-a0)*math.Max(rx, ry)/4)))
+	points := make([]Point, 0, segments+1)
+	for i := 0; i <= segments; i++ {
+		a := a0 + (a1-a0)*float64(i)/float64(segments)
+		points = append(points, Point{float32(cx + rx*math.Cos(a)), float32(cy + ry*math.Sin(a))})
+	}
+	return points
+}
+
+// parseSVGPath flattens SVG path data into polygons, each subpath is one polygon
+func parseSVGPath(d string) [][]Point {
+	tokens := svgPathTokens(d)
+	paths := [][]Point{}
+	path := []Point{}
+	var x, y, startX, startY float64
+	// The last control point for the smooth curves
+	var cx, cy float64
+	var last byte
+
+	closePath := func() {
+		if len(path) > 2 {
+			paths = append(paths, path)
+		}
+		path = []Point{}
+	}
+	add := func(px, py float64) {
+		path = append(path, Point{float32(px), float32(py)})
+	}
+	cubic := func(x1, y1, x2, y2, ex, ey float64) {
+		for i := 1; i <= 16; i++ {
+			t := float64(i) / 16
+			mt := 1 - t
+			add(mt*mt*mt*x+3*mt*mt*t*x1+3*mt*t*t*x2+t*t*t*ex, mt*mt*mt*y+3*mt*mt*t*y1+3*mt*t*t*y2+t*t*t*ey)
+		}
+		cx, cy = x2, y2
+		x, y = ex, ey
+	}
+	quad := func(x1, y1, ex, ey float64) {
+		for i := 1; i <= 16; i++ {
+			t := float64(i) / 16
+			mt := 1 - t
+			add(mt*mt*x+2*mt*t*x1+t*t*ex, mt*mt*y+2*mt*t*y1+t*t*ey)
+		}
+		cx, cy = x1, y1
+		x, y = ex, ey
+	}
+
+	i := 0
+	var cmd byte
+	num := func() (float64, bool) {
+		if i >= len(tokens) {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(tokens[i], 64)
+		if err != nil {
+			return 0, false
+		}
+		i++
+		return v, true
+	}
+	nums := func(n int) ([]float64, bool) {
+		out := make([]float64, n)
+		for k := range out {
+			v, ok := num()
+			if !ok {
+				return nil, false
+			}
+			out[k] = v
+		}
+		return out, true
+	}
+
+	for i < len(tokens) {
+		t := tokens[i]
+		if len(t) == 1 && strings.Contains("MmLlHhVvCcSsQqTtAaZz", t) {
+			cmd = t[0]
+			i++
+		} else if cmd == 0 {
+			return nil
+		}
+		rel := cmd >= 'a'
+		ox, oy := 0.0, 0.0
+		if rel {
+			ox, oy = x, y
+		}
+
+		switch cmd | 0x20 {
+		case 'z':
+			x, y = startX, startY
+			closePath()
+			add(x, y)
+			last = 'z'
+			continue
+		case 'm':
+			v, ok := nums(2)
+			if !ok {
+				return paths
+			}
+			closePath()
+			x, y = v[0]+ox, v[1]+oy
+			startX, startY = x, y
+			add(x, y)
+			// More pairs after a move are lines
+			if rel {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'l':
+			v, ok := nums(2)
+			if !ok {
+				return paths
+			}
+			x, y = v[0]+ox, v[1]+oy
+			add(x, y)
+		case 'h':
+			v, ok := nums(1)
+			if !ok {
+				return paths
+			}
+			x = v[0] + ox
+			add(x, y)
+		case 'v':
+			v, ok := nums(1)
+			if !ok {
+				return paths
+			}
+			y = v[0] + oy
+			add(x, y)
+		case 'c':
+			v, ok := nums(6)
+			if !ok {
+				return paths
+			}
+			cubic(v[0]+ox, v[1]+oy, v[2]+ox, v[3]+oy, v[4]+ox, v[5]+oy)
+		case 's':
+			v, ok := nums(4)
+			if !ok {
+				return paths
+			}
+			// The first control point is the last one reflected
+			x1, y1 := x, y
+			if last == 'c' || last == 's' {
+				x1, y1 = 2*x-cx, 2*y-cy
+			}
+			cubic(x1, y1, v[0]+ox, v[1]+oy, v[2]+ox, v[3]+oy)
+		case 'q':
+			v, ok := nums(4)
+			if !ok {
+				return paths
+			}
+			quad(v[0]+ox, v[1]+oy, v[2]+ox, v[3]+oy)
+		case 't':
+			v, ok := nums(2)
+			if !ok {
+				return paths
+			}
+			x1, y1 := x, y
+			if last == 'q' || last == 't' {
+				x1, y1 = 2*x-cx, 2*y-cy
+			}
+			quad(x1, y1, v[0]+ox, v[1]+oy)
+		case 'a':
+			v, ok := nums(7)
+			if !ok {
+				return paths
+			}
+			for _, p := range arcPoints(x, y, v[0], v[1], v[2], v[3] != 0, v[4] != 0, v[5]+ox, v[6]+oy) {
+				add(float64(p.X), float64(p.Y))
+			}
+			x, y = v[5]+ox, v[6]+oy
+		default:
+			return paths
+		}
+		last = cmd | 0x20
+	}
+	closePath()
+	return paths
+}
+
+// svgPathTokens splits path data into commands and numbers, numbers can run together like "1-2" or "1.5.5"
+func svgPathTokens(d string) []string {
+	tokens := []string{}
+	for i := 0; i < len(d); {
+		c := d[i]
+		switch {
+		case c == ' ' || c == ',' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isLetter(c) && c != 'e' && c != 'E':
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			if d[j] == '-' || d[j] == '+' {
+				j++
+			}
+			dot := false
+			for j < len(d) && (isDigit(d[j]) || (d[j] == '.' && !dot)) {
+				if d[j] == '.' {
+					dot = true
+				}
+				j++
+			}
+			if j < len(d) && (d[j] == 'e' || d[j] == 'E') {
+				j++
+				if j < len(d) && (d[j] == '-' || d[j] == '+') {
+					j++
+				}
+				for j < len(d) && isDigit(d[j]) {
+					j++
+				}
+			}
+			if j == i {
+				j++
+			}
+			tokens = append(tokens, d[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// arcPoints flattens a SVG elliptical arc from x1, y1 to x2, y2 (the endpoint to center conversion of the SVG spec)
+func arcPoints(x1, y1, rx, ry, rotation float64, large, sweep bool, x2, y2 float64) []Point {
+	if rx == 0 || ry == 0 {
+		return []Point{{float32(x2), float32(y2)}}
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	phi := rotation * math.Pi / 180
+	sin, cos := math.Sin(phi), math.Cos(phi)
+
+	dx, dy := (x1-x2)/2, (y1-y2)/2
+	px := cos*dx + sin*dy
+	py := -sin*dx + cos*dy
+
+	// Radii that are too small are scaled up
+	if l := px*px/(rx*rx) + py*py/(ry*ry); l > 1 {
+		rx *= math.Sqrt(l)
+		ry *= math.Sqrt(l)
+	}
+
+	num := rx*rx*ry*ry - rx*rx*py*py - ry*ry*px*px
+	den := rx*rx*py*py + ry*ry*px*px
+	coef := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cxp := coef * rx * py / ry
+	cyp := -coef * ry * px / rx
+	cx := cos*cxp - sin*cyp + (x1+x2)/2
+	cy := sin*cxp + cos*cyp + (y1+y2)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	start := angle(1, 0, (px-cxp)/rx, (py-cyp)/ry)
+	delta := angle((px-cxp)/rx, (py-cyp)/ry, (-px-cxp)/rx, (-py-cyp)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	segments := int(math.Max(4, math.Ceil(math.Abs(delta)*math.Max(rx, ry)/4)))
+	points := make([]Point, 0, segments)
+	for i := 1; i <= segments; i++ {
+		a := start + delta*float64(i)/float64(segments)
+		ex, ey := rx*math.Cos(a), ry*math.Sin(a)
+		points = append(points, Point{float32(cos*ex - sin*ey + cx), float32(sin*ex + cos*ey + cy)})
+	}
+	return points
+}
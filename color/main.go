@@ -3,233 +3,354 @@ package color
 import (
 	"fmt"
 	ic "image/color"
+	"math"
 	"strconv"
 	"strings"
 )
 
+// rgba is a sRGB color with its channels between 0 and 1, colors in wider spaces (lab, oklch...)
+// can be out of that range until they are gamut mapped by toRGBA
+type rgba struct {
+	r, g, b, a float64
+}
+
 // ParseRGBA parses a CSS color string and returns an RGBA color
 func ParseRGBA(color string) (ic.RGBA, error) {
-	color = strings.TrimSpace(strings.ToLower(color))
+	c, err := parse(strings.TrimSpace(strings.ToLower(color)))
+	if err != nil {
+		return ic.RGBA{}, err
+	}
+	return toRGBA(c), nil
+}
 
+func parse(color string) (rgba, error) {
 	// Named color
 	if namedColor, ok := namedColors[color]; ok {
-		return namedColor, nil
+		return rgba{float64(namedColor.R) / 255, float64(namedColor.G) / 255, float64(namedColor.B) / 255, float64(namedColor.A) / 255}, nil
 	}
 
-	// Hex color format: #RRGGBB or #RRGGBBAA
+	// Hex color format: #RGB, #RGBA, #RRGGBB or #RRGGBBAA
 	if strings.HasPrefix(color, "#") {
-		hexValue := strings.TrimPrefix(color, "#")
-
-		// Check if it's a three-letter hex code and expand it to six-letter hex code
-		if len(hexValue) == 3 {
-			hexValue = fmt.Sprintf("%c%c%c%c%c%c",
-				hexValue[0], hexValue[0],
-				hexValue[1], hexValue[1],
-				hexValue[2], hexValue[2])
-		}
-
-		rgb, err := strconv.ParseUint(hexValue, 16, 32)
-		if err != nil {
-			return ic.RGBA{}, fmt.Errorf("Invalid hex code")
-		}
-
-		// Check if it's #RRGGBB or #RRGGBBAA
-		alpha := uint8(255)
-		if len(hexValue) == 8 {
-			alpha = uint8(rgb >> 24)
-		}
-
-		return ic.RGBA{uint8(rgb >> 16), uint8((rgb >> 8) & 0xFF), uint8(rgb & 0xFF), alpha}, nil
+		return parseHex(strings.TrimPrefix(color, "#"))
 	}
 
-	// RGB or RGBA color format: rgb(255, 0, 0) or rgba(255, 0, 0, 0.5)
-	if strings.HasPrefix(color, "rgb(") && strings.HasSuffix(color, ")") {
-		return parseRGB(color)
-	} else if strings.HasPrefix(color, "rgba(") && strings.HasSuffix(color, ")") {
-		return parseRGBA(color)
+	open := strings.IndexByte(color, '(')
+	if open < 0 || !strings.HasSuffix(color, ")") {
+		return rgba{}, fmt.Errorf("Unable to parse color")
 	}
-
-	// HSL or HSLA color format: hsl(0, 100%, 50%) or hsla(0, 100%, 50%, 0.5)
-	if strings.HasPrefix(color, "hsl(") && strings.HasSuffix(color, ")") {
-		return parseHSL(color)
-	} else if strings.HasPrefix(color, "hsla(") && strings.HasSuffix(color, ")") {
-		return parseHSLA(color)
+	name, args := strings.TrimSpace(color[:open]), color[open+1:len(color)-1]
+
+	switch name {
+	case "rgb", "rgba":
+		return parseRGB(args)
+	case "hsl", "hsla", "hwb":
+		return parseHSL(name, args)
+	case "lab", "lch", "oklab", "oklch":
+		return parseLab(name, args)
+	case "color-mix":
+		return parseColorMix(args)
 	}
-
-	return ic.RGBA{}, fmt.Errorf("Unable to parse color")
+	return rgba{}, fmt.Errorf("Unable to parse color")
 }
 
-func parseRGB(rgb string) (ic.RGBA, error) {
-	rgbValues := strings.TrimSuffix(strings.TrimPrefix(rgb, "rgb("), ")")
-	rgbParts := strings.Split(rgbValues, ",")
-
-	if len(rgbParts) != 3 {
-		return ic.RGBA{}, fmt.Errorf("Not enough values to parse RGB")
+func parseHex(hexValue string) (rgba, error) {
+	// Expand the short forms to the long ones
+	if len(hexValue) == 3 || len(hexValue) == 4 {
+		long := make([]byte, 0, len(hexValue)*2)
+		for i := 0; i < len(hexValue); i++ {
+			long = append(long, hexValue[i], hexValue[i])
+		}
+		hexValue = string(long)
 	}
-
-	r, err := strconv.Atoi(strings.TrimSpace(rgbParts[0]))
-	if err != nil {
-		return ic.RGBA{}, err
+	if len(hexValue) == 6 {
+		hexValue += "ff"
 	}
-
-	g, err := strconv.Atoi(strings.TrimSpace(rgbParts[1]))
-	if err != nil {
-		return ic.RGBA{}, err
+	if len(hexValue) != 8 {
+		return rgba{}, fmt.Errorf("Invalid hex code")
 	}
 
-	b, err := strconv.Atoi(strings.TrimSpace(rgbParts[2]))
+	v, err := strconv.ParseUint(hexValue, 16, 32)
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, fmt.Errorf("Invalid hex code")
 	}
-
-	return ic.RGBA{uint8(r), uint8(g), uint8(b), 255}, nil
+	return rgba{float64(v>>24) / 255, float64((v>>16)&0xFF) / 255, float64((v>>8)&0xFF) / 255, float64(v&0xFF) / 255}, nil
 }
 
-func parseRGBA(rgba string) (ic.RGBA, error) {
-	rgbaValues := strings.TrimSuffix(strings.TrimPrefix(rgba, "rgba("), ")")
-	rgbaParts := strings.Split(rgbaValues, ",")
-
-	if len(rgbaParts) != 4 {
-		return ic.RGBA{}, fmt.Errorf("Not enough values to parse RGBA")
+// channels splits the arguments of a color function into its three channels and the alpha,
+// both the legacy comma separated syntax and the space separated one with a slash before the alpha work
+func channels(args string) ([3]string, string, error) {
+	var parts []string
+	alpha := ""
+	if strings.Contains(args, ",") {
+		parts = strings.Split(args, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		if len(parts) == 4 {
+			alpha = parts[3]
+			parts = parts[:3]
+		}
+	} else {
+		fields := strings.Fields(strings.ReplaceAll(args, "/", " / "))
+		if len(fields) == 5 && fields[3] == "/" {
+			alpha = fields[4]
+			fields = fields[:3]
+		}
+		parts = fields
 	}
 
-	r, err := strconv.Atoi(strings.TrimSpace(rgbaParts[0]))
-	if err != nil {
-		return ic.RGBA{}, err
+	if len(parts) != 3 {
+		return [3]string{}, "", fmt.Errorf("Not enough values to parse color")
 	}
+	return [3]string{parts[0], parts[1], parts[2]}, alpha, nil
+}
 
-	g, err := strconv.Atoi(strings.TrimSpace(rgbaParts[1]))
-	if err != nil {
-		return ic.RGBA{}, err
+// number parses a channel, percentages are scaled so 100% is equal to full.
+// none is a missing channel and is treated as 0
+func number(value string, full float64) (float64, error) {
+	if value == "none" {
+		return 0, nil
 	}
-
-	b, err := strconv.Atoi(strings.TrimSpace(rgbaParts[2]))
-	if err != nil {
-		return ic.RGBA{}, err
+	if strings.HasSuffix(value, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		return v / 100 * full, err
 	}
+	return strconv.ParseFloat(value, 64)
+}
 
-	alpha, err := strconv.ParseFloat(strings.TrimSpace(rgbaParts[3]), 64)
-	if err != nil {
-		return ic.RGBA{}, err
+// hue parses an angle in degrees, a unitless hue is in degrees
+func hue(value string) (float64, error) {
+	units := []struct {
+		suffix string
+		factor float64
+	}{{"deg", 1}, {"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}}
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(value, u.suffix), 64)
+			return v * u.factor, err
+		}
 	}
-
-	return ic.RGBA{uint8(r), uint8(g), uint8(b), uint8(alpha * 255)}, nil
+	return number(value, 0)
 }
 
-func parseHSL(hsl string) (ic.RGBA, error) {
-	hslValues := strings.TrimSuffix(strings.TrimPrefix(hsl, "hsl("), ")")
-	hslParts := strings.Split(hslValues, ",")
-
-	if len(hslParts) != 3 {
-		return ic.RGBA{}, fmt.Errorf("Not enough values to parse HSL")
+func parseAlpha(value string) (float64, error) {
+	if value == "" {
+		return 1, nil
 	}
+	a, err := number(value, 1)
+	return math.Max(0, math.Min(1, a)), err
+}
 
-	h, err := strconv.Atoi(strings.TrimSpace(hslParts[0]))
+// RGB or RGBA color format: rgb(255, 0, 0), rgba(255, 0, 0, 0.5) or rgb(255 0 0 / 50%)
+func parseRGB(args string) (rgba, error) {
+	parts, alpha, err := channels(args)
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, err
 	}
 
-	s, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(hslParts[1], "%")))
-	if err != nil {
-		return ic.RGBA{}, err
+	var rgb [3]float64
+	for i, p := range parts {
+		v, err := number(p, 255)
+		if err != nil {
+			return rgba{}, err
+		}
+		rgb[i] = math.Max(0, math.Min(1, v/255))
 	}
 
-	l, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(hslParts[2], "%")))
+	a, err := parseAlpha(alpha)
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, err
 	}
-
-	return hslToRGB(uint16(h), float64(s)/100, float64(l)/100)
+	return rgba{rgb[0], rgb[1], rgb[2], a}, nil
 }
 
-func parseHSLA(hsla string) (ic.RGBA, error) {
-	hslaValues := strings.TrimSuffix(strings.TrimPrefix(hsla, "hsla("), ")")
-	hslaParts := strings.Split(hslaValues, ",")
-
-	if len(hslaParts) != 4 {
-		return ic.RGBA{}, fmt.Errorf("Not enough values to parse HSLA")
+// HSL, HSLA and HWB color formats: hsl(0, 100%, 50%), hsla(0 100% 50% / 0.5) or hwb(0 0% 0%)
+func parseHSL(name, args string) (rgba, error) {
+	parts, alpha, err := channels(args)
+	if err != nil {
+		return rgba{}, err
 	}
 
-	h, err := strconv.Atoi(strings.TrimSpace(hslaParts[0]))
+	h, err := hue(parts[0])
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, err
 	}
-
-	s, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(hslaParts[1], "%")))
+	// Percentages and plain numbers are the same thing here
+	x, err := number(strings.TrimSuffix(parts[1], "%"), 0)
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, err
 	}
-
-	l, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(hslaParts[2], "%")))
+	y, err := number(strings.TrimSuffix(parts[2], "%"), 0)
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, err
 	}
-
-	alpha, err := strconv.ParseFloat(strings.TrimSpace(hslaParts[3]), 64)
+	a, err := parseAlpha(alpha)
 	if err != nil {
-		return ic.RGBA{}, err
+		return rgba{}, err
 	}
 
-	return hslToRGB(uint16(h), float64(s)/100, float64(l)/100, alpha)
+	var c rgba
+	if name == "hwb" {
+		c = hwbToRGB(h, x/100, y/100)
+	} else {
+		c = hslToRGB(h, math.Max(0, math.Min(1, x/100)), math.Max(0, math.Min(1, y/100)))
+	}
+	c.a = a
+	return c, nil
 }
 
-func hslToRGB(hue uint16, saturation, lightness float64, alpha ...float64) (ic.RGBA, error) {
-	var r, g, b float64
+// Lab and OKLab color formats and their polar forms: lab(50% 40 59.5), lch(52.2% 72.2 50),
+// oklab(59% 0.1 0.1 / 0.5) and oklch(60% 0.15 50)
+func parseLab(name, args string) (rgba, error) {
+	parts, alpha, err := channels(args)
+	if err != nil {
+		return rgba{}, err
+	}
+
+	// What 100% is equal to for the lightness, the a/b and the chroma channels
+	lightness, ab, chroma := 100.0, 125.0, 150.0
+	if strings.HasPrefix(name, "ok") {
+		lightness, ab, chroma = 1, 0.4, 0.4
+	}
 
-	if saturation == 0 {
-		r = lightness
-		g = lightness
-		b = lightness
+	var v [3]float64
+	if v[0], err = number(parts[0], lightness); err != nil {
+		return rgba{}, err
+	}
+	if strings.HasSuffix(name, "lch") {
+		if v[1], err = number(parts[1], chroma); err != nil {
+			return rgba{}, err
+		}
+		if v[2], err = hue(parts[2]); err != nil {
+			return rgba{}, err
+		}
+		v[1] = math.Max(0, v[1])
 	} else {
-		var q float64
-		if lightness < 0.5 {
-			q = lightness * (1 + saturation)
-		} else {
-			q = lightness + saturation - (lightness * saturation)
+		if v[1], err = number(parts[1], ab); err != nil {
+			return rgba{}, err
 		}
+		if v[2], err = number(parts[2], ab); err != nil {
+			return rgba{}, err
+		}
+	}
+	v[0] = math.Max(0, math.Min(lightness, v[0]))
 
-		p := 2*lightness - q
-
-		h := float64(hue) / 360
+	a, err := parseAlpha(alpha)
+	if err != nil {
+		return rgba{}, err
+	}
+	c := fromSpace(name, v)
+	c.a = a
+	return c, nil
+}
 
-		// Convert hue to RGB
-		var tc [3]float64
-		tc[0] = h + 1/3
-		tc[1] = h
-		tc[2] = h - 1/3
+// color-mix(in oklch, red 40%, blue) mixes two colors in the given color space,
+// a hue interpolation method (shorter, longer, increasing, decreasing) can follow polar spaces
+func parseColorMix(args string) (rgba, error) {
+	parts := splitTopLevel(args, ',')
+	if len(parts) != 3 {
+		return rgba{}, fmt.Errorf("color-mix needs a color space and two colors")
+	}
 
-		for i := 0; i < 3; i++ {
-			if tc[i] < 0 {
-				tc[i] += 1
-			} else if tc[i] > 1 {
-				tc[i] -= 1
-			}
+	method := strings.Fields(parts[0])
+	if len(method) < 2 || method[0] != "in" {
+		return rgba{}, fmt.Errorf("color-mix needs a color space")
+	}
+	space := method[1]
+	if _, ok := hueIndex[space]; !ok {
+		return rgba{}, fmt.Errorf("Unknown color space %s", space)
+	}
+	interpolation := "shorter"
+	if len(method) == 4 && method[3] == "hue" {
+		interpolation = method[2]
+	}
 
-			if tc[i] < 1/6 {
-				tc[i] = p + (q-p)*6*tc[i]
-			} else if tc[i] < 1/2 {
-				tc[i] = q
-			} else if tc[i] < 2/3 {
-				tc[i] = p + (q-p)*6*(2/3-tc[i])
+	var colors [2]rgba
+	var percents [2]float64
+	var given [2]bool
+	for i, p := range parts[1:] {
+		color := []string{}
+		for _, t := range splitTopLevel(p, ' ') {
+			if strings.HasSuffix(t, "%") && !strings.Contains(t, "(") {
+				v, err := strconv.ParseFloat(strings.TrimSuffix(t, "%"), 64)
+				if err != nil || v < 0 || v > 100 {
+					return rgba{}, fmt.Errorf("Invalid color-mix percentage")
+				}
+				percents[i], given[i] = v/100, true
 			} else {
-				tc[i] = p
+				color = append(color, t)
 			}
 		}
-
-		r, g, b = tc[0], tc[1], tc[2]
+		c, err := parse(strings.Join(color, " "))
+		if err != nil {
+			return rgba{}, err
+		}
+		colors[i] = c
 	}
 
-	// Scale to 0-255
-	r *= 255
-	g *= 255
-	b *= 255
+	// Missing percentages are what is left of 100%, if they don't add up to 100% they're scaled to it
+	// and a sum under 100% makes the result transparent by that much
+	switch {
+	case !given[0] && !given[1]:
+		percents = [2]float64{0.5, 0.5}
+	case !given[0]:
+		percents[0] = 1 - percents[1]
+	case !given[1]:
+		percents[1] = 1 - percents[0]
+	}
+	sum := percents[0] + percents[1]
+	if sum == 0 {
+		return rgba{}, fmt.Errorf("color-mix percentages can't both be 0")
+	}
+	c := mix(colors[0], colors[1], percents[1]/sum, space, interpolation)
+	c.a *= math.Min(1, sum)
+	return c, nil
+}
 
-	var alphaValue uint8 = 255
-	if len(alpha) > 0 {
-		alphaValue = uint8(alpha[0] * 255)
+// splitTopLevel splits s on sep outside of parentheses
+func splitTopLevel(s string, sep byte) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				if p := strings.TrimSpace(s[start:i]); p != "" {
+					parts = append(parts, p)
+				}
+				start = i + 1
+			}
+		}
 	}
+	if p := strings.TrimSpace(s[start:]); p != "" {
+		parts = append(parts, p)
+	}
+	return parts
+}
 
-	return ic.RGBA{uint8(r), uint8(g), uint8(b), alphaValue}, nil
+// ResolveCurrentColor replaces the currentcolor keyword in a property value with current,
+// the value of the color property of the element
+func ResolveCurrentColor(value, current string) string {
+	const keyword = "currentcolor"
+	var b strings.Builder
+	last := 0
+	for i := 0; i+len(keyword) <= len(value); i++ {
+		if (value[i] == 'c' || value[i] == 'C') && strings.EqualFold(value[i:i+len(keyword)], keyword) {
+			b.WriteString(value[last:i])
+			b.WriteString(current)
+			i += len(keyword) - 1
+			last = i + 1
+		}
+	}
+	if last == 0 {
+		return value
+	}
+	b.WriteString(value[last:])
+	return b.String()
 }
 
 var namedColors = map[string]ic.RGBA{
@@ -0,0 +1,415 @@
+package color
+
+import (
+	ic "image/color"
+	"math"
+)
+
+// !DEVMAN: Colors are parsed into sRGB floats, the wide gamut formats (lab, lch, oklab, oklch) can
+// + describe colors sRGB can't show so they're only gamut mapped once the final color is made in toRGBA.
+// + The matrices and the gamut mapping are the ones from the CSS Color 4 spec
+
+// hueIndex is the channel holding the hue for each color space color-mix can interpolate in,
+// -1 for rectangular spaces
+var hueIndex = map[string]int{
+	"srgb":        -1,
+	"srgb-linear": -1,
+	"xyz":         -1,
+	"xyz-d65":     -1,
+	"xyz-d50":     -1,
+	"lab":         -1,
+	"oklab":       -1,
+	"hsl":         0,
+	"hwb":         0,
+	"lch":         2,
+	"oklch":       2,
+}
+
+// D50 white point of lab
+var d50 = [3]float64{0.3457 / 0.3585, 1, (1 - 0.3457 - 0.3585) / 0.3585}
+
+func multiply(m [3][3]float64, v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+var (
+	linearToXYZ = [3][3]float64{
+		{0.41239079926595934, 0.357584339383878, 0.1804807884018343},
+		{0.21263900587151027, 0.715168678767756, 0.07219231536073371},
+		{0.01933081871559182, 0.11919477979462598, 0.9505321522496607},
+	}
+	xyzToLinear = [3][3]float64{
+		{3.2409699419045226, -1.537383177570094, -0.4986107602930034},
+		{-0.9692436362808796, 1.8759675015077202, 0.04155505740717559},
+		{0.05563007969699366, -0.20397695888897652, 1.0569715142428786},
+	}
+	// Bradford chromatic adaptation between the D65 white of sRGB and the D50 white of lab
+	d65ToD50 = [3][3]float64{
+		{1.0479298208405488, 0.022946793341019088, -0.05019222954313557},
+		{0.029627815688159344, 0.990434484573249, -0.01707382502938514},
+		{-0.009243058152591178, 0.015055144896577895, 0.7518742899580008},
+	}
+	d50ToD65 = [3][3]float64{
+		{0.955473421488075, -0.02309845494876471, 0.06325924320057072},
+		{-0.0283697093338637, 1.0099953980813041, 0.021041441191917323},
+		{0.012314014864481998, -0.020507649298898964, 1.330365926242124},
+	}
+	linearToLMS = [3][3]float64{
+		{0.4122214708, 0.5363325363, 0.0514459929},
+		{0.2119034982, 0.6806995451, 0.1073969566},
+		{0.0883024619, 0.2817188376, 0.6299787005},
+	}
+	lmsToOklab = [3][3]float64{
+		{0.2104542553, 0.7936177850, -0.0040720468},
+		{1.9779984951, -2.4285922050, 0.4505937099},
+		{0.0259040371, 0.7827717662, -0.8086757660},
+	}
+	oklabToLMS = [3][3]float64{
+		{1, 0.3963377774, 0.2158037573},
+		{1, -0.1055613458, -0.0638541728},
+		{1, -0.0894841775, -1.2914855480},
+	}
+	lmsToLinear = [3][3]float64{
+		{4.0767416621, -3.3077115913, 0.2309699292},
+		{-1.2684380046, 2.6097574011, -0.3413193965},
+		{-0.0041960863, -0.7034186147, 1.7076147010},
+	}
+)
+
+// toLinear removes the sRGB transfer function, negative values are mirrored so out of gamut colors survive
+func toLinear(v float64) float64 {
+	a := math.Abs(v)
+	if a <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Copysign(math.Pow((a+0.055)/1.055, 2.4), v)
+}
+
+func fromLinear(v float64) float64 {
+	a := math.Abs(v)
+	if a <= 0.0031308 {
+		return v * 12.92
+	}
+	return math.Copysign(1.055*math.Pow(a, 1/2.4)-0.055, v)
+}
+
+const (
+	labKappa   = 24389.0 / 27
+	labEpsilon = 216.0 / 24389
+)
+
+func xyzToLab(xyz [3]float64) [3]float64 {
+	var f [3]float64
+	for i := range xyz {
+		v := xyz[i] / d50[i]
+		if v > labEpsilon {
+			f[i] = math.Cbrt(v)
+		} else {
+			f[i] = (labKappa*v + 16) / 116
+		}
+	}
+	return [3]float64{116*f[1] - 16, 500 * (f[0] - f[1]), 200 * (f[1] - f[2])}
+}
+
+func labToXYZ(lab [3]float64) [3]float64 {
+	f1 := (lab[0] + 16) / 116
+	f0 := lab[1]/500 + f1
+	f2 := f1 - lab[2]/200
+
+	xyz := [3]float64{(116*f0 - 16) / labKappa, lab[0] / labKappa, (116*f2 - 16) / labKappa}
+	if math.Pow(f0, 3) > labEpsilon {
+		xyz[0] = math.Pow(f0, 3)
+	}
+	if lab[0] > labKappa*labEpsilon {
+		xyz[1] = math.Pow(f1, 3)
+	}
+	if math.Pow(f2, 3) > labEpsilon {
+		xyz[2] = math.Pow(f2, 3)
+	}
+	for i := range xyz {
+		xyz[i] *= d50[i]
+	}
+	return xyz
+}
+
+func linearToOklab(rgb [3]float64) [3]float64 {
+	lms := multiply(linearToLMS, rgb)
+	for i := range lms {
+		lms[i] = math.Cbrt(lms[i])
+	}
+	return multiply(lmsToOklab, lms)
+}
+
+func oklabToLinear(lab [3]float64) [3]float64 {
+	lms := multiply(oklabToLMS, lab)
+	for i := range lms {
+		lms[i] = lms[i] * lms[i] * lms[i]
+	}
+	return multiply(lmsToLinear, lms)
+}
+
+// toPolar turns a/b into chroma and hue
+func toPolar(lab [3]float64) [3]float64 {
+	h := math.Atan2(lab[2], lab[1]) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return [3]float64{lab[0], math.Hypot(lab[1], lab[2]), h}
+}
+
+func fromPolar(lch [3]float64) [3]float64 {
+	h := lch[2] * math.Pi / 180
+	return [3]float64{lch[0], lch[1] * math.Cos(h), lch[1] * math.Sin(h)}
+}
+
+func hslToRGB(hue, saturation, lightness float64) rgba {
+	f := func(n float64) float64 {
+		k := math.Mod(n+hue/30, 12)
+		if k < 0 {
+			k += 12
+		}
+		a := saturation * math.Min(lightness, 1-lightness)
+		return lightness - a*math.Max(-1, math.Min(math.Min(k-3, 9-k), 1))
+	}
+	return rgba{f(0), f(8), f(4), 1}
+}
+
+func hwbToRGB(hue, white, black float64) rgba {
+	if white+black >= 1 {
+		gray := white / (white + black)
+		return rgba{gray, gray, gray, 1}
+	}
+	c := hslToRGB(hue, 1, 0.5)
+	scale := 1 - white - black
+	return rgba{c.r*scale + white, c.g*scale + white, c.b*scale + white, 1}
+}
+
+// rgbToHSL returns the hue in degrees and the saturation and lightness between 0 and 1
+func rgbToHSL(c rgba) [3]float64 {
+	max := math.Max(c.r, math.Max(c.g, c.b))
+	min := math.Min(c.r, math.Min(c.g, c.b))
+	l := (max + min) / 2
+	d := max - min
+
+	var h, s float64
+	if d != 0 {
+		if l != 0 && l != 1 {
+			s = (max - l) / math.Min(l, 1-l)
+		}
+		switch max {
+		case c.r:
+			h = (c.g-c.b)/d + 6
+		case c.g:
+			h = (c.b-c.r)/d + 2
+		default:
+			h = (c.r-c.g)/d + 4
+		}
+		h = math.Mod(h*60, 360)
+	}
+	return [3]float64{h, s, l}
+}
+
+// toSpace converts a color to the channels of a color space, hsl and hwb use 0-1 instead of percentages
+func toSpace(space string, c rgba) [3]float64 {
+	rgb := [3]float64{c.r, c.g, c.b}
+	linear := [3]float64{toLinear(c.r), toLinear(c.g), toLinear(c.b)}
+
+	switch space {
+	case "srgb":
+		return rgb
+	case "srgb-linear":
+		return linear
+	case "xyz", "xyz-d65":
+		return multiply(linearToXYZ, linear)
+	case "xyz-d50":
+		return multiply(d65ToD50, multiply(linearToXYZ, linear))
+	case "lab":
+		return xyzToLab(multiply(d65ToD50, multiply(linearToXYZ, linear)))
+	case "lch":
+		return toPolar(xyzToLab(multiply(d65ToD50, multiply(linearToXYZ, linear))))
+	case "oklab":
+		return linearToOklab(linear)
+	case "oklch":
+		return toPolar(linearToOklab(linear))
+	case "hsl":
+		return rgbToHSL(c)
+	case "hwb":
+		hsl := rgbToHSL(c)
+		return [3]float64{hsl[0], math.Min(c.r, math.Min(c.g, c.b)), 1 - math.Max(c.r, math.Max(c.g, c.b))}
+	}
+	return rgb
+}
+
+// fromSpace converts the channels of a color space to a (possibly out of gamut) opaque sRGB color
+func fromSpace(space string, v [3]float64) rgba {
+	var linear [3]float64
+	switch space {
+	case "srgb":
+		return rgba{v[0], v[1], v[2], 1}
+	case "srgb-linear":
+		linear = v
+	case "xyz", "xyz-d65":
+		linear = multiply(xyzToLinear, v)
+	case "xyz-d50":
+		linear = multiply(xyzToLinear, multiply(d50ToD65, v))
+	case "lab":
+		linear = multiply(xyzToLinear, multiply(d50ToD65, labToXYZ(v)))
+	case "lch":
+		linear = multiply(xyzToLinear, multiply(d50ToD65, labToXYZ(fromPolar(v))))
+	case "oklab":
+		linear = oklabToLinear(v)
+	case "oklch":
+		linear = oklabToLinear(fromPolar(v))
+	case "hsl":
+		return hslToRGB(v[0], v[1], v[2])
+	case "hwb":
+		return hwbToRGB(v[0], v[1], v[2])
+	}
+	return rgba{fromLinear(linear[0]), fromLinear(linear[1]), fromLinear(linear[2]), 1}
+}
+
+// mix interpolates from a to b by t in the given space with premultiplied alpha
+func mix(a, b rgba, t float64, space, interpolation string) rgba {
+	va, vb := toSpace(space, a), toSpace(space, b)
+	h := hueIndex[space]
+
+	if h >= 0 {
+		// An achromatic color has no hue, it takes the hue of the other color
+		chroma := func(v [3]float64) float64 {
+			if space == "hwb" {
+				return 1 - v[1] - v[2]
+			}
+			return v[1]
+		}
+		if chroma(va) < 1e-4 {
+			va[h] = vb[h]
+		} else if chroma(vb) < 1e-4 {
+			vb[h] = va[h]
+		}
+		va[h], vb[h] = fixHues(math.Mod(va[h]+360, 360), math.Mod(vb[h]+360, 360), interpolation)
+	}
+
+	var v [3]float64
+	alpha := a.a + (b.a-a.a)*t
+	for i := range v {
+		if i == h {
+			v[i] = math.Mod(va[i]+(vb[i]-va[i])*t+360, 360)
+			continue
+		}
+		v[i] = va[i]*a.a + (vb[i]*b.a-va[i]*a.a)*t
+		if alpha != 0 {
+			v[i] /= alpha
+		}
+	}
+
+	c := fromSpace(space, v)
+	c.a = alpha
+	return c
+}
+
+// fixHues adjusts two hues so interpolating between them follows the hue interpolation method
+func fixHues(h1, h2 float64, interpolation string) (float64, float64) {
+	d := h2 - h1
+	switch interpolation {
+	case "longer":
+		if d > 0 && d < 180 {
+			h1 += 360
+		} else if d > -180 && d <= 0 {
+			h2 += 360
+		}
+	case "increasing":
+		if d < 0 {
+			h2 += 360
+		}
+	case "decreasing":
+		if d > 0 {
+			h1 += 360
+		}
+	default:
+		if d > 180 {
+			h1 += 360
+		} else if d < -180 {
+			h2 += 360
+		}
+	}
+	return h1, h2
+}
+
+func inGamut(c rgba) bool {
+	const e = 0.000075
+	return c.r >= -e && c.r <= 1+e && c.g >= -e && c.g <= 1+e && c.b >= -e && c.b <= 1+e
+}
+
+func clip(c rgba) rgba {
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(1, v))
+	}
+	return rgba{clamp(c.r), clamp(c.g), clamp(c.b), c.a}
+}
+
+func deltaEOK(a, b rgba) float64 {
+	la, lb := toSpace("oklab", a), toSpace("oklab", b)
+	return math.Sqrt((la[0]-lb[0])*(la[0]-lb[0]) + (la[1]-lb[1])*(la[1]-lb[1]) + (la[2]-lb[2])*(la[2]-lb[2]))
+}
+
+// gamutMap brings a color into sRGB by lowering its oklch chroma until clipping it is no
+// longer noticeable, this keeps the lightness and hue that clipping alone would change
+func gamutMap(c rgba) rgba {
+	if inGamut(c) {
+		return c
+	}
+	origin := toSpace("oklch", c)
+	if origin[0] >= 1 {
+		return rgba{1, 1, 1, c.a}
+	}
+	if origin[0] <= 0 {
+		return rgba{0, 0, 0, c.a}
+	}
+
+	const jnd, epsilon = 0.02, 0.0001
+	current := origin
+	atChroma := func(v [3]float64) rgba {
+		r := fromSpace("oklch", v)
+		r.a = c.a
+		return r
+	}
+	clipped := clip(atChroma(current))
+	if deltaEOK(clipped, atChroma(current)) < jnd {
+		return clipped
+	}
+
+	min, max, minInGamut := 0.0, origin[1], true
+	for max-min > epsilon {
+		current[1] = (min + max) / 2
+		candidate := atChroma(current)
+		if minInGamut && inGamut(candidate) {
+			min = current[1]
+			continue
+		}
+		clipped = clip(candidate)
+		e := deltaEOK(clipped, candidate)
+		if e < jnd {
+			if jnd-e < epsilon {
+				return clipped
+			}
+			minInGamut = false
+			min = current[1]
+		} else {
+			max = current[1]
+		}
+	}
+	return clipped
+}
+
+// toRGBA gamut maps the color and converts it to 8 bits per channel
+func toRGBA(c rgba) ic.RGBA {
+	c = clip(gamutMap(c))
+	to8 := func(v float64) uint8 {
+		return uint8(math.Round(v * 255))
+	}
+	return ic.RGBA{to8(c.r), to8(c.g), to8(c.b), to8(math.Max(0, math.Min(1, c.a)))}
+}
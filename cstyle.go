@@ -3,6 +3,7 @@ package grim
 import (
 	"fmt"
 	"github.com/golang/freetype/truetype"
+	"grim/color"
 	"image"
 	"strconv"
 	"strings"
@@ -26,9 +27,16 @@ type CSS struct {
 	Plugins      []Plugin
 	Transformers []Transformer
 	Fonts        map[string]*truetype.Font
-	Adapter      *Adapter
-	Path         string
-	State        map[string]State
+	// fontUnits caches the size of ch and ex by the font and its size
+	fontUnits map[string]float32
+	units     unitContext
+	// floats are the floats placed so far in the block formatting context being laid out
+	floats []*Node
+	// TextMasks keeps the rendered text of elements inside a background-clip: text element
+	TextMasks map[string]image.Image
+	Adapter   *Adapter
+	Path      string
+	State     map[string]State
 }
 
 func (c *CSS) AddPlugin(plugin Plugin) {
@@ -54,6 +62,12 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 	if self.Textures == nil {
 		self.Textures = map[string]string{}
 	}
+	// Fragments are rebuilt by the inline formatting context the node is part of
+	self.Fragments = nil
+	self.Layer = nil
+	// visibility: hidden keeps the space of the element but it isn't drawn or hit, the inline layout
+	// and the plugins can hide it after this
+	self.Hidden = n.ComputedStyle["visibility"] == "hidden"
 
 	if nonRenderTags[n.tagName] {
 		return self
@@ -75,17 +89,47 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 		style[k] = v
 	}
 
-	self.Border, _ = parseBorder(style, self, parent)
+	// dir="auto" takes the direction of the first strong character of the text,
+	// children that inherited it use the direction resolved by the parent
+	if style["direction"] == "auto" {
+		if strings.EqualFold(n.GetAttribute("dir"), "auto") {
+			style["direction"] = ContentDirection(n)
+		} else {
+			style["direction"] = parentNode.ComputedStyle["direction"]
+		}
+	}
+
+	// Floats are laid out as blocks
+	if isFloated(n) && inlineDisplays[style["display"]] {
+		style["display"] = strings.TrimPrefix(style["display"], "inline-")
+		if style["display"] == "inline" {
+			style["display"] = "block"
+		}
+	}
+
+	current := currentColor(n)
+	self.Border, _ = parseBorder(c.unitsOf(style), style, current, self, parent)
 	// Remove border if its 0
-	if self.Border.Top.Width+self.Border.Right.Width+self.Border.Left.Width+self.Border.Bottom.Width == 0 {
+	if self.Border.Top.Width+self.Border.Right.Width+self.Border.Left.Width+self.Border.Bottom.Width == 0 && self.Border.Image.Source == "" {
 		self.Textures["border"] = ""
 	}
 
 	if style["font-size"] == "" {
 		n.ComputedStyle["font-size"] = "1em"
 	}
-	fs := ConvertToPixels(n.ComputedStyle["font-size"], parent.EM, parent.Width)
+	// rem is relative to the font size of the root element and the viewport units to the window, the font size
+	// of the root element resolves rem against the initial font size
+	if n.tagName == "html" {
+		c.units = unitContext{rootEM: 16, viewportWidth: c.Width, viewportHeight: c.Height}
+	}
+	// The font size is measured in the font of the parent
+	fs := c.unitsOf(parentNode.ComputedStyle).px(n.ComputedStyle["font-size"], parent.EM, parent.Width)
 	self.EM = fs
+	if n.tagName == "html" {
+		c.units.rootEM = fs
+		c.units.style = style
+	}
+	units := c.unitsOf(style)
 
 	if style["display"] == "none" {
 		self.X, self.Y, self.Width, self.Height = 0, 0, 0, 0
@@ -104,10 +148,11 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 
 	c.State[n.Properties.Id] = self
 
-	self.Background = parseBackground(style)
+	self.Background = parseBackground(style, current)
+	self.Layer = parseEffects(units, style, current, self.EM, self.Border.Radius)
 
 	c.State[n.Properties.Id] = self
-	wh, m, p := FindBounds(*n, style, &c.State)
+	wh, m, p := findBounds(units, *n, style, &c.State)
 
 	self.Margin = m
 	self.Padding = p
@@ -122,7 +167,7 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 	y += offsetY
 
 	var top, left, right, bottom bool
-	if style["position"] == "absolute" {
+	if isPositioned(style) {
 		// !DEVMAN: Properties.Id is the ancestory of an element with colons seperating them
 		// + if we split them up we can check the parents without recursion or a while (for true) loop
 		// + NOTE: See GenerateUnqineId to see how they are made
@@ -133,36 +178,49 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 		for i := len(ancestors) - 2; i > 0; i-- {
 			offsetNode = offsetNode.parent
 			pos := offsetNode.ComputedStyle["position"]
-			if pos == "relative" || pos == "absolute" {
+			if pos == "relative" || pos == "absolute" || pos == "fixed" || pos == "sticky" {
 				break
 			}
 		}
 
 		base := s[offsetNode.Properties.Id]
+		// The offsets of absolute elements are relative to the width of the parent
+		cbWidth, cbHeight := parent.Width, parent.Width
+		// Fixed elements are placed against the viewport, the crop plugin doesn't scroll them. top and bottom
+		// are percentages of its height and left and right of its width
+		if style["position"] == "fixed" {
+			base = State{Width: c.Width, Height: c.Height}
+			cbWidth, cbHeight = c.Width, c.Height
+		}
 		if topVal := style["top"]; topVal != "" {
-			y = ConvertToPixels(topVal, self.EM, parent.Width) + base.Y
+			y = units.px(topVal, self.EM, cbHeight) + base.Y
 			top = true
 		}
 		if leftVal := style["left"]; leftVal != "" {
-			x = ConvertToPixels(leftVal, self.EM, parent.Width) + base.X
+			x = units.px(leftVal, self.EM, cbWidth) + base.X
 			left = true
 		}
 		if rightVal := style["right"]; rightVal != "" {
-			x = base.X + ((base.Width - self.Width) - ConvertToPixels(rightVal, self.EM, parent.Width))
+			x = base.X + ((base.Width - self.Width) - units.px(rightVal, self.EM, cbWidth))
 			right = true
 		}
 		if bottomVal := style["bottom"]; bottomVal != "" {
-			y = base.Y + ((base.Height - self.Height) - ConvertToPixels(bottomVal, self.EM, parent.Width))
+			y = base.Y + ((base.Height - self.Height) - units.px(bottomVal, self.EM, cbHeight))
 			bottom = true
 		}
 	} else {
 		for i, v := range parentNode.Children {
-			if v.ComputedStyle["position"] != "absolute" {
+			if !isPositioned(v.ComputedStyle) && (!isFloated(v) || v == n) {
 				if v.Properties.Id == n.Properties.Id {
 					if i > 0 {
-						sib := parentNode.Children[i-1]
+						// Floats don't take up space, the box goes under the in flow sibling before them
+						j := i - 1
+						for j > 0 && isFloated(parentNode.Children[j]) {
+							j--
+						}
+						sib := parentNode.Children[j]
 						sibling := s[sib.Properties.Id]
-						if sib.ComputedStyle["position"] != "absolute" {
+						if !isPositioned(sib.ComputedStyle) && !isFloated(sib) {
 							if style["display"] == "inline" {
 								y = sibling.Y
 								if sib.ComputedStyle["display"] != "inline" {
@@ -180,6 +238,11 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 				}
 			}
 		}
+
+		// clear moves the box below the floats before it, floats clear when they are placed
+		if clear := style["clear"]; clear != "" && clear != "none" && !isFloated(n) {
+			y = Max(y, clearance(clear, c.floatBoxes(c.floats))-m.Top)
+		}
 	}
 
 	relPos := !top && !left && !right && !bottom
@@ -200,58 +263,11 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 	self.Y = y
 
 	c.State[n.Properties.Id] = self
-	innerText := n.innerText
-	if !ChildrenHaveText(n) && len(innerText) > 0 {
-		n.innerText = strings.TrimSpace(innerText)
-		italic := false
-
-		if style["font-style"] == "italic" {
-			italic = true
-		}
-
-		if c.Fonts == nil {
-			c.Fonts = map[string]*truetype.Font{}
-		}
-		fid := style["font-family"] + fmt.Sprint(style["font-weight"], italic)
-		fnt, ok := c.Fonts[fid]
-
-		if !ok {
-			f, err := LoadFont(style["font-family"], int(self.EM), style["font-weight"], italic, &c.Adapter.FileSystem)
-			if err != nil {
-				panic(err)
-			}
-			c.Fonts[fid] = f
-			fnt = f
-		}
-
-		metadata := GetMetaData(n, style, &c.State, fnt)
-		key := FontKey(metadata)
-		m, exists := c.Adapter.Textures[n.Properties.Id]["text"]
-		var width int
-
-		if exists && m == key {
-			width = MeasureText(metadata, metadata.Text+" ")
-		} else {
-			if exists {
-				c.Adapter.UnloadTexture(n.Properties.Id, "text")
-			}
-			var data image.Image
-			data, width = RenderFont(metadata)
-			c.Adapter.LoadTexture(n.Properties.Id, "text", key, data)
-		}
-		self.Textures["text"] = key
-
-		if (style["height"] == "" && style["min-height"] == "") || n.tagName == "text" {
-			self.Height = float32(metadata.LineHeight)
-			n.ComputedStyle["height"] = strconv.Itoa(int(self.Height)) + "px"
-		}
-
-		if style["width"] == "" && style["min-width"] == "" {
-			self.Width = float32(width)
-			n.ComputedStyle["width"] = strconv.Itoa(int(self.Width)) + "px"
-		}
+	// A block formatting context goes beside the floats before it instead of over them
+	if avoidsFloats(n) {
+		c.avoidFloats(n, c.floatBoxes(c.floats))
+		self = c.State[n.Properties.Id]
 	}
-
 	// Load canvas into textures
 	if n.tagName == "canvas" {
 		if n.Canvas != nil {
@@ -280,20 +296,30 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 	self.ScrollWidth = 0
 	var childYOffset float32
 
+	// The floats inside of a block formatting context stay in it
+	outer := c.floats
+	bfc := establishesBFC(n)
+	if bfc {
+		c.floats = nil
+	}
+	prior := len(c.floats)
+
 	for i := 0; i < len(n.Children); i++ {
 		cState := c.ComputeNodeState(n.Children[i])
 
 		if style["height"] == "" && style["max-height"] == "" {
-			if n.Children[i].ComputedStyle["position"] != "absolute" && cState.Y+cState.Height > childYOffset {
+			if !isPositioned(n.Children[i].ComputedStyle) && !isFloated(n.Children[i]) && cState.Y+cState.Height > childYOffset {
 				childYOffset = cState.Y + cState.Height
 				self.Height = cState.Y - self.Border.Top.Width - self.Y + cState.Height
 				self.Height += cState.Margin.Top + cState.Margin.Bottom + cState.Padding.Top + cState.Padding.Bottom + cState.Border.Top.Width + cState.Border.Bottom.Width
 			}
 		}
 
+		// Fixed elements don't scroll with the element so they don't add to what can be scrolled
+		fixed := n.Children[i].ComputedStyle["position"] == "fixed"
 		sh := int((cState.Y + cState.Height) - self.Y)
 		if self.ScrollHeight < sh {
-			if n.Children[i].tagName != "grim-track" {
+			if n.Children[i].tagName != "grim-track" && !fixed {
 				self.ScrollHeight = sh
 				self.ScrollHeight += int(cState.Margin.Top + cState.Margin.Bottom + cState.Padding.Top + cState.Padding.Bottom + cState.Border.Top.Width + cState.Border.Bottom.Width)
 
@@ -303,7 +329,7 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 		sw := int((cState.X + cState.Width) - self.X)
 
 		if self.ScrollWidth < sw {
-			if n.Children[i].tagName != "grim-track" {
+			if n.Children[i].tagName != "grim-track" && !fixed {
 				self.ScrollWidth = sw
 			}
 		}
@@ -323,7 +349,28 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 	}
 	c.State[n.Properties.Id] = self
 
-	drawBorder(&self, c.Adapter, n.Properties.Id)
+	if establishesInlineContext(n) {
+		c.layoutInline(n, shrinksToFit(n), c.floatBoxes(c.floats[:prior]))
+		self = c.State[n.Properties.Id]
+	}
+
+	// A block formatting context grows to contain the floats inside of it
+	if bfc {
+		c.containFloats(n, c.floatBoxes(c.floats))
+		self = c.State[n.Properties.Id]
+		c.floats = outer
+	}
+
+	// Floats are placed once their size is known, from where they would have been in the flow
+	if isFloated(n) {
+		c.placeFloat(n, self.Y-self.Margin.Top, c.floatBoxes(c.floats))
+		self = c.State[n.Properties.Id]
+		c.floats = append(c.floats, n)
+	}
+
+	drawBorder(&self, c, n.Properties.Id)
+	self.Outline = parseOutline(units, style, current, self.EM, parent.Width)
+	drawOutline(&self, c.Adapter, n.Properties.Id)
 	c.State[n.Properties.Id] = self
 
 	for _, v := range plugins {
@@ -334,3 +381,71 @@ func (c *CSS) ComputeNodeState(n *Node) State {
 	self = c.State[n.Properties.Id]
 	return self
 }
+
+// DrawBorder draws the border and outline of n again, plugins call it after they change the size of n
+func (c *CSS) DrawBorder(n *Node) {
+	self := c.State[n.Properties.Id]
+	drawBorder(&self, c, n.Properties.Id)
+	drawOutline(&self, c.Adapter, n.Properties.Id)
+	c.State[n.Properties.Id] = self
+}
+
+// initialColor is the color of the text when nothing sets it
+const initialColor = "black"
+
+// currentColor returns the value of currentcolor for the node, the color of the node with currentcolor in it
+// resolved against the color of the parent. The keyword is resolved when the colors are parsed so it follows
+// the color when it changes (on :hover...)
+func currentColor(n *Node) string {
+	value := strings.TrimSpace(n.ComputedStyle["color"])
+	if value == "" || strings.EqualFold(value, "inherit") {
+		value = "currentcolor"
+	}
+	if !strings.Contains(strings.ToLower(value), "currentcolor") {
+		return value
+	}
+	if n.parent == nil {
+		return initialColor
+	}
+	return color.ResolveCurrentColor(value, currentColor(n.parent))
+}
+
+// isPositioned reports if the element is taken out of the flow by position: absolute or fixed
+func isPositioned(style map[string]string) bool {
+	return style["position"] == "absolute" || style["position"] == "fixed"
+}
+
+// getFont loads the font matching the font-family, font-weight and font-style of the style,
+// fonts are cached on the CSS by their family, weight and style
+func (c *CSS) getFont(style map[string]string, em float32) *truetype.Font {
+	fnt, err := c.loadFont(style, em)
+	if err != nil {
+		panic(err)
+	}
+	return fnt
+}
+
+// fontId is the key a font is cached by
+func fontId(style map[string]string) string {
+	return style["font-family"] + fmt.Sprint(style["font-weight"], style["font-style"] == "italic")
+}
+
+func (c *CSS) loadFont(style map[string]string, em float32) (*truetype.Font, error) {
+	italic := style["font-style"] == "italic"
+
+	if c.Fonts == nil {
+		c.Fonts = map[string]*truetype.Font{}
+	}
+	fid := fontId(style)
+	fnt, ok := c.Fonts[fid]
+
+	if !ok {
+		f, err := LoadFont(style["font-family"], int(em), style["font-weight"], italic, &c.Adapter.FileSystem)
+		if err != nil {
+			return nil, err
+		}
+		c.Fonts[fid] = f
+		fnt = f
+	}
+	return fnt, nil
+}
@@ -0,0 +1,290 @@
+package grim
+
+import (
+	"strings"
+)
+
+// !DEVMAN: Drag and drop starts when the mouse is pressed on a element with draggable="true" (or inside of one)
+// + and moved more than dragThreshold pixels, canceling the mousedown keeps it from starting. dragstart goes to the
+// + dragged element and canceling it stops the drag. While dragging, drag goes to the dragged element and dragenter,
+// + dragover and dragleave to the element under the mouse, a element is a drop target if it cancels dragover.
+// + Letting go over a drop target sends drop to it, then dragend goes to the dragged element with the DropEffect
+// + that was used ("none" if nothing was dropped). The mouse events aren't sent while dragging. The preview is the
+// + render data of the dragged element (or the one given to SetDragImage) drawn again under the mouse at half opacity,
+// + it reuses the textures the element already has
+
+// dragThreshold is how far the mouse has to move with the button down before a drag starts
+const dragThreshold = 4
+
+// DataTransfer holds the data being dragged, it's shared by all of the events of a drag
+type DataTransfer struct {
+	// DropEffect is the effect the drop target wants set in dragover: none, copy, link or move
+	DropEffect string
+	// EffectAllowed is the effects the dragged element allows set in dragstart: none, copy, copyLink, copyMove,
+	// link, linkMove, move, all or uninitialized
+	EffectAllowed string
+	data          map[string]string
+	types         []string
+	image         *Node
+	offsetX       int
+	offsetY       int
+}
+
+// SetData stores the data for the format (a mime type like text/plain), "text" is the same as text/plain
+func (d *DataTransfer) SetData(format, data string) {
+	format = dataFormat(format)
+	if _, ok := d.data[format]; !ok {
+		d.types = append(d.types, format)
+	}
+	d.data[format] = data
+}
+
+func (d *DataTransfer) GetData(format string) string {
+	return d.data[dataFormat(format)]
+}
+
+// ClearData removes the data of the formats or all of the data if no format is given
+func (d *DataTransfer) ClearData(format ...string) {
+	if len(format) == 0 {
+		d.data = map[string]string{}
+		d.types = nil
+		return
+	}
+	for _, f := range format {
+		f = dataFormat(f)
+		delete(d.data, f)
+		for i, v := range d.types {
+			if v == f {
+				d.types = append(d.types[:i:i], d.types[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Types returns the formats that have data in the order they were set
+func (d *DataTransfer) Types() []string {
+	return append([]string{}, d.types...)
+}
+
+// SetDragImage uses n as the preview with the mouse at x, y from its top left corner
+func (d *DataTransfer) SetDragImage(n *Node, x, y int) {
+	d.image = n
+	d.offsetX = x
+	d.offsetY = y
+}
+
+func dataFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "text" {
+		return "text/plain"
+	}
+	return format
+}
+
+// dragSession is a drag from the mousedown on a draggable element to the dragend
+type dragSession struct {
+	source *Node
+	// x and y is where the mouse was pressed
+	x, y     int
+	active   bool
+	over     *Node
+	canDrop  bool
+	transfer *DataTransfer
+}
+
+// startDrag gets ready to drag the draggable element target is in when the mouse is pressed on it
+func (m *Monitor) startDrag(target *Node) {
+	m.dnd = nil
+	for v := target; v != nil; v = v.parent {
+		if v.attribute["draggable"] == "false" {
+			return
+		}
+		if v.Draggable() {
+			m.dnd = &dragSession{source: v, x: m.position[0], y: m.position[1]}
+			return
+		}
+	}
+}
+
+func (d *dragSession) event(name string, m *Monitor) Event {
+	return Event{
+		Name:         name,
+		Bubbles:      true,
+		Cancelable:   name != "dragleave" && name != "dragend",
+		X:            m.position[0],
+		Y:            m.position[1],
+		DataTransfer: d.transfer,
+	}
+}
+
+// dragEvents sends the drag and drop events, it returns true while a drag is going on so the mouse events aren't sent
+func (m *Monitor) dragEvents(target *Node) bool {
+	d := m.dnd
+	if d == nil {
+		return false
+	}
+
+	if !d.active {
+		if !m.mouseDown {
+			m.dnd = nil
+			return false
+		}
+		if max(m.position[0]-d.x, d.x-m.position[0]) <= dragThreshold && max(m.position[1]-d.y, d.y-m.position[1]) <= dragThreshold {
+			return false
+		}
+		d.active = true
+		d.transfer = &DataTransfer{
+			DropEffect:    "none",
+			EffectAllowed: "uninitialized",
+			data:          map[string]string{},
+		}
+		if !d.source.DispatchEvent(d.event("dragstart", m)) {
+			m.dnd = nil
+			return false
+		}
+	}
+
+	if !m.mouseDown {
+		dropped := false
+		if d.over != nil {
+			if d.canDrop {
+				// The drop target has to cancel drop to say it took the data
+				dropped = !d.over.DispatchEvent(d.event("drop", m))
+			} else {
+				d.over.DispatchEvent(d.event("dragleave", m))
+			}
+		}
+		if !dropped {
+			d.transfer.DropEffect = "none"
+		}
+		d.source.DispatchEvent(d.event("dragend", m))
+		m.dnd = nil
+		return true
+	}
+
+	if !d.source.DispatchEvent(d.event("drag", m)) {
+		// Canceling drag cancels the whole drag
+		if d.over != nil {
+			d.over.DispatchEvent(d.event("dragleave", m))
+		}
+		d.transfer.DropEffect = "none"
+		d.source.DispatchEvent(d.event("dragend", m))
+		m.dnd = nil
+		return true
+	}
+
+	if target != d.over {
+		if target != nil {
+			e := d.event("dragenter", m)
+			e.RelatedTarget = d.over
+			target.DispatchEvent(e)
+		}
+		if d.over != nil {
+			e := d.event("dragleave", m)
+			e.RelatedTarget = target
+			d.over.DispatchEvent(e)
+		}
+		d.over = target
+	}
+
+	d.canDrop = false
+	if d.over != nil {
+		d.transfer.DropEffect = defaultDropEffect(d.transfer.EffectAllowed)
+		if !d.over.DispatchEvent(d.event("dragover", m)) {
+			d.canDrop = effectAllowed(d.transfer.DropEffect, d.transfer.EffectAllowed)
+		}
+	}
+	if !d.canDrop {
+		d.transfer.DropEffect = "none"
+	}
+	return true
+}
+
+// defaultDropEffect is the DropEffect dragover starts with for the effects allowed
+func defaultDropEffect(allowed string) string {
+	switch allowed {
+	case "none":
+		return "none"
+	case "copy", "copyLink":
+		return "copy"
+	case "link":
+		return "link"
+	}
+	return "move"
+}
+
+func effectAllowed(effect, allowed string) bool {
+	if effect == "none" {
+		return false
+	}
+	if allowed == "all" || allowed == "uninitialized" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(allowed), effect)
+}
+
+// dragPreview returns the render data of the drag preview, it's drawn over everything else under the mouse
+func (m *Monitor) dragPreview(rd []State, keys []string) []State {
+	d := m.dnd
+	if d == nil || !d.active {
+		return nil
+	}
+	n := d.source
+	if d.transfer.image != nil {
+		n = d.transfer.image
+	}
+	id := n.Properties.Id
+
+	start := -1
+	for i, k := range keys {
+		if k == id {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return nil
+	}
+	// The subtree is the states after the node with ids starting with its id
+	end := start
+	for end+1 < len(keys) && strings.HasPrefix(keys[end+1], id+":") {
+		end++
+	}
+
+	// The element stays where it was grabbed from under the mouse unless SetDragImage moved it
+	root := rd[start]
+	dx := float32(m.position[0] - d.x)
+	dy := float32(m.position[1] - d.y)
+	if d.transfer.image != nil {
+		dx = float32(m.position[0]-d.transfer.offsetX) - root.X
+		dy = float32(m.position[1]-d.transfer.offsetY) - root.Y
+	}
+
+	var top float32
+	for _, v := range rd {
+		top = Max(top, v.Z)
+	}
+
+	preview := []State{}
+	layer := Layer{Opacity: 0.5, End: len(rd) + end - start}
+	x1, y1, x2, y2 := root.X+dx, root.Y+dy, root.X+dx, root.Y+dy
+	for i := start; i <= end; i++ {
+		s := rd[i]
+		s.X += dx
+		s.Y += dy
+		s.Z += top + 1 - root.Z
+		s.Layer = nil
+		s.Transform = nil
+		if !s.Hidden {
+			x1 = Min(x1, s.X)
+			y1 = Min(y1, s.Y)
+			x2 = Max(x2, s.X+s.Width+s.Border.Left.Width+s.Border.Right.Width)
+			y2 = Max(y2, s.Y+s.Height+s.Border.Top.Width+s.Border.Bottom.Width)
+		}
+		preview = append(preview, s)
+	}
+	layer.X, layer.Y, layer.Width, layer.Height = x1, y1, x2-x1, y2-y1
+	preview[0].Layer = &layer
+	return preview
+}
@@ -0,0 +1,505 @@
+package grim
+
+import (
+	"grim/color"
+	"grim/gg"
+	"image"
+	ic "image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// !DEVMAN: opacity, filter and backdrop-filter apply to a element and everything inside of it, so
+// + they can't be done per texture. Elements that use them get a Layer in their State, the adapter
+// + draws the subtree (the States from the element to Layer.End in the render data) into a offscreen
+// + image the size of the layer, runs the filters over it with ApplyFilters and draws the result with
+// + the opacity of the layer. Layers can be nested, a inner layer is drawn into the outer one.
+
+// Filter is a single CSS filter function, Amount is a fraction for the color filters, degrees for
+// hue-rotate and the standard deviation of the blur in pixels for blur and drop-shadow
+type Filter struct {
+	Name   string
+	Amount float32
+	X      float32
+	Y      float32
+	Color  ic.RGBA
+}
+
+type Layer struct {
+	Opacity        float32
+	Filter         []Filter
+	BackdropFilter []Filter
+	// BlendMode is the mix-blend-mode the layer is drawn onto what is under it with
+	BlendMode string
+	// ClipPath is the clip-path of the element, ClipOverflow clips a overflow container to its rounded corners
+	ClipPath     string
+	ClipOverflow bool
+	// Clip is the shape the layer is clipped to in window coordinates and Mask the key of its texture,
+	// they are set once the layout is done
+	Clip *Clip
+	Mask string
+	// End is the index of the last State of the subtree in the render data
+	End int
+	// The area the layer covers, includes what the filters draw outside of the elements
+	X      float32
+	Y      float32
+	Width  float32
+	Height float32
+}
+
+// ClipsDescendants reports if the clip only applies to what is inside of the element (overflow), the element
+// itself is drawn without it
+func (l *Layer) ClipsDescendants() bool {
+	return l.ClipOverflow && l.ClipPath == ""
+}
+
+// parseEffects returns the layer of a element or nil if it isn't composited
+func parseEffects(units unitContext, style map[string]string, current string, em float32, radius BorderRadius) *Layer {
+	layer := Layer{Opacity: 1}
+
+	if v := strings.TrimSpace(style["opacity"]); v != "" {
+		layer.Opacity = clampFraction(parseAmount(v, 1))
+	}
+	layer.Filter = parseFilter(units, style["filter"], current, em)
+	layer.BackdropFilter = parseFilter(units, style["backdrop-filter"], current, em)
+
+	layer.BlendMode = strings.TrimSpace(style["mix-blend-mode"])
+	if layer.BlendMode == "" {
+		layer.BlendMode = "normal"
+	}
+
+	if v := strings.TrimSpace(style["clip-path"]); v != "none" {
+		layer.ClipPath = v
+	}
+	// Square corners are already cropped by the crop plugin
+	rounded := radius.TopLeft > 0 || radius.TopRight > 0 || radius.BottomLeft > 0 || radius.BottomRight > 0
+	if rounded {
+		for _, p := range []string{"overflow", "overflow-x", "overflow-y"} {
+			if v := style[p]; v != "" && v != "visible" {
+				layer.ClipOverflow = true
+			}
+		}
+	}
+
+	if layer.Opacity == 1 && len(layer.Filter) == 0 && len(layer.BackdropFilter) == 0 && layer.BlendMode == "normal" &&
+		layer.ClipPath == "" && !layer.ClipOverflow {
+		return nil
+	}
+	return &layer
+}
+
+// parseAmount parses a number or a percentage as a fraction
+func parseAmount(value string, fallback float32) float32 {
+	if value == "" {
+		return fallback
+	}
+	if strings.HasSuffix(value, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 32)
+		if err != nil {
+			return fallback
+		}
+		return float32(v) / 100
+	}
+	v, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return fallback
+	}
+	return float32(v)
+}
+
+func clampFraction(v float32) float32 {
+	return Max(0, Min(1, v))
+}
+
+// parseAngle returns a CSS angle in degrees
+func parseAngle(value string) float32 {
+	units := []struct {
+		suffix string
+		factor float32
+	}{{"deg", 1}, {"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}}
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			v, _ := strconv.ParseFloat(strings.TrimSuffix(value, u.suffix), 32)
+			return float32(v) * u.factor
+		}
+	}
+	v, _ := strconv.ParseFloat(value, 32)
+	return float32(v)
+}
+
+// parseFilter parses a list of filter functions like "blur(4px) brightness(50%)",
+// functions that aren't supported (url()) are skipped
+func parseFilter(units unitContext, value, current string, em float32) []Filter {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "none" {
+		return nil
+	}
+
+	filters := []Filter{}
+	for _, f := range Token('(', ')', ' ', value) {
+		open := strings.IndexByte(f, '(')
+		if open < 0 || !strings.HasSuffix(f, ")") {
+			continue
+		}
+		name := strings.ToLower(f[:open])
+		arg := strings.TrimSpace(f[open+1 : len(f)-1])
+
+		switch name {
+		case "blur":
+			if arg == "" {
+				arg = "0px"
+			}
+			filters = append(filters, Filter{Name: name, Amount: Max(0, units.px(arg, em, 0))})
+		case "grayscale", "sepia", "invert", "opacity":
+			filters = append(filters, Filter{Name: name, Amount: clampFraction(parseAmount(arg, 1))})
+		case "brightness", "contrast", "saturate":
+			filters = append(filters, Filter{Name: name, Amount: Max(0, parseAmount(arg, 1))})
+		case "hue-rotate":
+			filters = append(filters, Filter{Name: name, Amount: parseAngle(arg)})
+		case "drop-shadow":
+			// The shadow is currentcolor unless it has a color
+			shadow := Filter{Name: name, Color: ic.RGBA{0, 0, 0, 255}}
+			if c, err := color.ParseRGBA(current); err == nil {
+				shadow.Color = c
+			}
+			lengths := []float32{}
+			for _, p := range Token('(', ')', ' ', arg) {
+				if c, err := color.ParseRGBA(color.ResolveCurrentColor(p, current)); err == nil {
+					shadow.Color = c
+				} else {
+					lengths = append(lengths, units.px(p, em, 0))
+				}
+			}
+			if len(lengths) < 2 {
+				continue
+			}
+			shadow.X, shadow.Y = lengths[0], lengths[1]
+			// The blur radius of a shadow is twice the standard deviation, blur() takes the deviation itself
+			if len(lengths) > 2 {
+				shadow.Amount = Max(0, lengths[2]) / 2
+			}
+			filters = append(filters, shadow)
+		}
+	}
+	return filters
+}
+
+// filterOutset is how far the filters draw outside of the elements, a gaussian blur
+// is visible for about three times its standard deviation
+func filterOutset(filters []Filter) (left, top, right, bottom float32) {
+	for _, f := range filters {
+		switch f.Name {
+		case "blur":
+			b := f.Amount * 3
+			left, top, right, bottom = left+b, top+b, right+b, bottom+b
+		case "drop-shadow":
+			b := f.Amount * 3
+			left = Max(left, b-f.X)
+			top = Max(top, b-f.Y)
+			right = Max(right, b+f.X)
+			bottom = Max(bottom, b+f.Y)
+		}
+	}
+	return left, top, right, bottom
+}
+
+// setLayers finds the end of the subtree of every layer in the render data and the area it covers,
+// keys are the Properties.Id of each State. The area is limited to the window
+func setLayers(units unitContext, rd []State, keys []string, width, height float32) {
+	for i, self := range rd {
+		if self.Layer == nil {
+			continue
+		}
+		l := self.Layer
+		l.End = i
+		prefix := keys[i] + ":"
+		for j := i + 1; j < len(rd) && strings.HasPrefix(keys[j], prefix); j++ {
+			l.End = j
+		}
+
+		l.Clip = nil
+		if l.ClipPath != "" || l.ClipOverflow {
+			l.Clip = clipShape(units, self, l)
+		}
+
+		// The outline is drawn around the border box
+		x1, y1, x2, y2 := transformedBounds(self)
+		if o := self.Outline; o.Width > 0 {
+			grow := o.Width + Max(0, o.Offset)
+			x1, y1, x2, y2 = x1-grow, y1-grow, x2+grow, y2+grow
+		}
+		// Nothing inside of the element is drawn outside of the clip, a overflow clip leaves the element itself
+		dx1, dy1 := float32(math.Inf(1)), float32(math.Inf(1))
+		dx2, dy2 := float32(math.Inf(-1)), float32(math.Inf(-1))
+		for _, v := range rd[i+1 : l.End+1] {
+			if v.Hidden || v.Width+v.Height == 0 {
+				continue
+			}
+			vx1, vy1, vx2, vy2 := transformedBounds(v)
+			dx1, dy1 = Min(dx1, vx1), Min(dy1, vy1)
+			dx2, dy2 = Max(dx2, vx2), Max(dy2, vy2)
+		}
+		if l.Clip != nil && l.ClipsDescendants() {
+			dx1, dy1, dx2, dy2 = intersectBounds(dx1, dy1, dx2, dy2, l.Clip)
+		}
+		if dx1 < dx2 && dy1 < dy2 {
+			x1, y1 = Min(x1, dx1), Min(y1, dy1)
+			x2, y2 = Max(x2, dx2), Max(y2, dy2)
+		}
+
+		left, top, right, bottom := filterOutset(l.Filter)
+		x1 = float32(math.Floor(float64(Max(x1-left, -left))))
+		y1 = float32(math.Floor(float64(Max(y1-top, -top))))
+		x2 = float32(math.Ceil(float64(Min(x2+right, width+right))))
+		y2 = float32(math.Ceil(float64(Min(y2+bottom, height+bottom))))
+
+		if l.Clip != nil && !l.ClipsDescendants() {
+			x1, y1, x2, y2 = intersectBounds(x1, y1, x2, y2, l.Clip)
+		}
+
+		l.X, l.Y = x1, y1
+		l.Width, l.Height = Max(0, x2-x1), Max(0, y2-y1)
+	}
+}
+
+// intersectBounds limits the box to the bounds of the clip
+func intersectBounds(x1, y1, x2, y2 float32, clip *Clip) (float32, float32, float32, float32) {
+	cx1, cy1, cx2, cy2 := clip.bounds()
+	return Max(x1, float32(math.Floor(float64(cx1)))), Max(y1, float32(math.Floor(float64(cy1)))),
+		Min(x2, float32(math.Ceil(float64(cx2)))), Min(y2, float32(math.Ceil(float64(cy2))))
+}
+
+// ApplyFilters runs the filters over img in order. The pixels are not premultiplied, that is how
+// adapters read back what they drew
+func ApplyFilters(img *image.RGBA, filters []Filter) *image.RGBA {
+	for _, f := range filters {
+		switch f.Name {
+		case "blur":
+			img = blurImage(img, f.Amount)
+		case "drop-shadow":
+			img = dropShadow(img, f)
+		case "opacity":
+			for i := 3; i < len(img.Pix); i += 4 {
+				img.Pix[i] = uint8(float32(img.Pix[i]) * f.Amount)
+			}
+		default:
+			m := colorMatrix(f)
+			for i := 0; i < len(img.Pix); i += 4 {
+				r, g, b := float32(img.Pix[i])/255, float32(img.Pix[i+1])/255, float32(img.Pix[i+2])/255
+				img.Pix[i] = to8(m[0][0]*r + m[0][1]*g + m[0][2]*b + m[0][3])
+				img.Pix[i+1] = to8(m[1][0]*r + m[1][1]*g + m[1][2]*b + m[1][3])
+				img.Pix[i+2] = to8(m[2][0]*r + m[2][1]*g + m[2][2]*b + m[2][3])
+			}
+		}
+	}
+	return img
+}
+
+// ClipToRadius makes the pixels of img outside of the rounded corners transparent, img is the border box of the
+// element. Only the alpha is scaled so it works on the straight alpha pixels the adapters read back
+func ClipToRadius(img *image.RGBA, r BorderRadius) {
+	if r.TopLeft+r.TopRight+r.BottomRight+r.BottomLeft <= 0 {
+		return
+	}
+	b := img.Bounds()
+	dc := gg.NewContext(b.Dx(), b.Dy())
+	for i, p := range roundedRect(0, 0, float32(b.Dx()), float32(b.Dy()), [4]float32{r.TopLeft, r.TopRight, r.BottomRight, r.BottomLeft}) {
+		if i == 0 {
+			dc.MoveTo(float64(p.X), float64(p.Y))
+		} else {
+			dc.LineTo(float64(p.X), float64(p.Y))
+		}
+	}
+	dc.ClosePath()
+	dc.Fill()
+	mask := dc.AsMask()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			i := img.PixOffset(b.Min.X+x, b.Min.Y+y) + 3
+			img.Pix[i] = uint8(uint16(img.Pix[i]) * uint16(mask.AlphaAt(x, y).A) / 255)
+		}
+	}
+}
+
+func to8(v float32) uint8 {
+	return uint8(Max(0, Min(1, v))*255 + 0.5)
+}
+
+// colorMatrix returns the matrix of the color filters from the Filter Effects spec,
+// the last column is a offset
+func colorMatrix(f Filter) [3][4]float32 {
+	a := f.Amount
+	switch f.Name {
+	case "grayscale":
+		a = 1 - a
+		return [3][4]float32{
+			{0.2126 + 0.7874*a, 0.7152 - 0.7152*a, 0.0722 - 0.0722*a, 0},
+			{0.2126 - 0.2126*a, 0.7152 + 0.2848*a, 0.0722 - 0.0722*a, 0},
+			{0.2126 - 0.2126*a, 0.7152 - 0.7152*a, 0.0722 + 0.9278*a, 0},
+		}
+	case "sepia":
+		a = 1 - a
+		return [3][4]float32{
+			{0.393 + 0.607*a, 0.769 - 0.769*a, 0.189 - 0.189*a, 0},
+			{0.349 - 0.349*a, 0.686 + 0.314*a, 0.168 - 0.168*a, 0},
+			{0.272 - 0.272*a, 0.534 - 0.534*a, 0.131 + 0.869*a, 0},
+		}
+	case "saturate":
+		return [3][4]float32{
+			{0.213 + 0.787*a, 0.715 - 0.715*a, 0.072 - 0.072*a, 0},
+			{0.213 - 0.213*a, 0.715 + 0.285*a, 0.072 - 0.072*a, 0},
+			{0.213 - 0.213*a, 0.715 - 0.715*a, 0.072 + 0.928*a, 0},
+		}
+	case "hue-rotate":
+		rad := float64(a) * math.Pi / 180
+		cos, sin := float32(math.Cos(rad)), float32(math.Sin(rad))
+		return [3][4]float32{
+			{0.213 + cos*0.787 - sin*0.213, 0.715 - cos*0.715 - sin*0.715, 0.072 - cos*0.072 + sin*0.928, 0},
+			{0.213 - cos*0.213 + sin*0.143, 0.715 + cos*0.285 + sin*0.140, 0.072 - cos*0.072 - sin*0.283, 0},
+			{0.213 - cos*0.213 - sin*0.787, 0.715 - cos*0.715 + sin*0.715, 0.072 + cos*0.928 + sin*0.072, 0},
+		}
+	case "invert":
+		return [3][4]float32{
+			{1 - 2*a, 0, 0, a},
+			{0, 1 - 2*a, 0, a},
+			{0, 0, 1 - 2*a, a},
+		}
+	case "brightness":
+		return [3][4]float32{{a, 0, 0, 0}, {0, a, 0, 0}, {0, 0, a, 0}}
+	case "contrast":
+		o := 0.5 - 0.5*a
+		return [3][4]float32{{a, 0, 0, o}, {0, a, 0, o}, {0, 0, a, o}}
+	}
+	return [3][4]float32{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}}
+}
+
+// blurImage approximates a gaussian blur with the standard deviation sigma with three box blurs,
+// the channels are premultiplied while blurring so transparent pixels don't bleed their color
+func blurImage(img *image.RGBA, sigma float32) *image.RGBA {
+	if sigma <= 0 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	buf := make([]float32, w*h*4)
+	for i := 0; i < len(buf); i += 4 {
+		a := float32(img.Pix[i+3]) / 255
+		buf[i] = float32(img.Pix[i]) * a
+		buf[i+1] = float32(img.Pix[i+1]) * a
+		buf[i+2] = float32(img.Pix[i+2]) * a
+		buf[i+3] = float32(img.Pix[i+3])
+	}
+
+	tmp := make([]float32, len(buf))
+	for _, size := range boxSizes(sigma, 3) {
+		r := (size - 1) / 2
+		boxBlur(buf, tmp, w, h, r, 4, w*4)
+		boxBlur(tmp, buf, h, w, r, w*4, 4)
+	}
+
+	for i := 0; i < len(buf); i += 4 {
+		a := buf[i+3]
+		img.Pix[i+3] = uint8(Max(0, Min(255, a+0.5)))
+		if a <= 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = 0, 0, 0
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			img.Pix[i+c] = uint8(Max(0, Min(255, buf[i+c]*255/a+0.5)))
+		}
+	}
+	return img
+}
+
+// boxSizes returns the sizes of n box blurs that together are close to a gaussian blur
+func boxSizes(sigma float32, n int) []int {
+	ideal := math.Sqrt(float64(12*sigma*sigma/float32(n)) + 1)
+	wl := int(math.Floor(ideal))
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+	mIdeal := (12*float64(sigma*sigma) - float64(n*wl*wl) - 4*float64(n*wl) - 3*float64(n)) / (-4*float64(wl) - 4)
+	m := int(math.Round(mIdeal))
+
+	sizes := make([]int, n)
+	for i := range sizes {
+		if i < m {
+			sizes[i] = wl
+		} else {
+			sizes[i] = wu
+		}
+	}
+	return sizes
+}
+
+// boxBlur blurs src into dst along one axis, lines are the rows (or columns) to blur, length the
+// number of pixels in each and step/stride the distance between two pixels and two lines in the buffer
+func boxBlur(src, dst []float32, length, lines, r, step, stride int) {
+	if r <= 0 {
+		copy(dst, src)
+		return
+	}
+	scale := 1 / float32(2*r+1)
+	for line := 0; line < lines; line++ {
+		base := line * stride
+		for c := 0; c < 4; c++ {
+			at := func(i int) float32 {
+				// The edges are extended with transparency
+				if i < 0 || i >= length {
+					return 0
+				}
+				return src[base+i*step+c]
+			}
+			var sum float32
+			for i := -r; i <= r; i++ {
+				sum += at(i)
+			}
+			for i := 0; i < length; i++ {
+				dst[base+i*step+c] = sum * scale
+				sum += at(i+r+1) - at(i-r)
+			}
+		}
+	}
+}
+
+// dropShadow draws a blurred copy of the alpha of img in the shadow color under img
+func dropShadow(img *image.RGBA, f Filter) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	shadow := image.NewRGBA(b)
+	dx, dy := int(math.Round(float64(f.X))), int(math.Round(float64(f.Y)))
+	for y := 0; y < h; y++ {
+		sy := y - dy
+		if sy < 0 || sy >= h {
+			continue
+		}
+		for x := 0; x < w; x++ {
+			sx := x - dx
+			if sx < 0 || sx >= w {
+				continue
+			}
+			i, si := y*img.Stride+x*4, sy*img.Stride+sx*4
+			shadow.Pix[i] = f.Color.R
+			shadow.Pix[i+1] = f.Color.G
+			shadow.Pix[i+2] = f.Color.B
+			shadow.Pix[i+3] = uint8(uint16(img.Pix[si+3]) * uint16(f.Color.A) / 255)
+		}
+	}
+	shadow = blurImage(shadow, f.Amount)
+
+	// Source over the shadow, both are not premultiplied
+	for i := 0; i < len(img.Pix); i += 4 {
+		sa, da := float32(img.Pix[i+3])/255, float32(shadow.Pix[i+3])/255
+		a := sa + da*(1-sa)
+		if a <= 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			v := (float32(img.Pix[i+c])*sa + float32(shadow.Pix[i+c])*da*(1-sa)) / a
+			shadow.Pix[i+c] = uint8(Max(0, Min(255, v+0.5)))
+		}
+		shadow.Pix[i+3] = uint8(a*255 + 0.5)
+	}
+	return shadow
+}
@@ -2,8 +2,8 @@ package grim
 
 import (
 	"bytes"
-	"fmt"
 	"grim/canvas"
+	"grim/gg"
 	ic "image/color"
 	"slices"
 	"strconv"
@@ -33,7 +33,13 @@ type Node struct {
 	disabled          bool                         // m
 	checked           bool                         // m
 	focused           bool                         // nm
+	focusVisible      bool                         // nm
+	focusWithin       bool                         // nm
 	hovered           bool                         // nm
+	active            bool                         // nm
+	conditionalRules  []conditionalRule            // nm
+	pointerCapture    map[int]bool                 // nm
+	layout            State                        // nm
 	StyleSheets       *Styles                      // nm
 
 	// !NOTE: ScrollHeight is the amount of scroll left, not the total amount of scroll
@@ -58,22 +64,26 @@ type Node struct {
 var inheritedProps = []string{
 	"color",
 	"cursor",
+	"direction",
 	"font",
 	"font-family",
 	"font-style",
 	"font-weight",
 	"letter-spacing",
 	"line-height",
-	// "text-align",
+	"text-align",
 	"text-indent",
 	"text-justify",
 	"text-shadow",
 	"text-transform",
 	"text-decoration",
 	"visibility",
+	"white-space",
+	"tab-size",
 	"word-spacing",
 	"display",
 	"scrollbar-color",
+	"-webkit-text-fill-color",
 }
 
 type styles {
@@ -111,15 +121,12 @@ func (n *Node) GetComputedStyles() map[string]string {
 		}
 	}
 
-	// Then apply the conditional styles
-	if n.hovered && n.styles.conditional[":hover"] != nil {
-		for k, v := range n.styles.conditional[":hover"] {
-			styles[k] = v
-		}
-	}
-	if n.focused && n.styles.conditional[":focus"] != nil {
-		for k, v := range n.styles.conditional[":focus"] {
-			styles[k] = v
+	// Then apply the conditional styles in the order of the style sheets
+	for _, r := range n.conditionalRules {
+		if n.inState(r.state) {
+			for k, v := range r.styles {
+				styles[k] = v
+			}
 		}
 	}
 
@@ -133,7 +140,7 @@ func (n *Node) GetComputedStyles() map[string]string {
 // !TODO: I would like to remove element.Node.Properties if possible but I don't think it is possible
 type Properties struct {
 	Id             string
-	EventListeners map[string][]func(Event)
+	EventListeners map[string][]*Listener
 	// Events         []string
 	// !TODO: Make selected work
 	Selected []float32
@@ -159,6 +166,20 @@ type State struct {
 	ContentEditable bool
 	Value           string
 	TabIndex        int
+	Fragments       []Fragment
+	Layer           *Layer
+	Transform       *gg.Matrix
+	Outline         Outline
+}
+
+// Fragment is the part of a inline node that was placed on a single line box,
+// X and Y are relative to the State of the node it belongs to
+type Fragment struct {
+	X       float32
+	Y       float32
+	Width   float32
+	Height  float32
+	Texture string
 }
 
 type Crop struct {
@@ -184,6 +205,8 @@ type Background struct {
 	Repeat     string
 	Origin     string
 	Attachment string
+	Clip       string
+	BlendMode  string
 }
 
 func (n *Node) TagName() string {
@@ -236,6 +259,14 @@ func (n *Node) ContentEditable(value ...bool) bool {
 	return n.contentEditable
 }
 
+// Draggable gets or sets the draggable attribute, only elements with draggable="true" can be dragged
+func (n *Node) Draggable(value ...bool) bool {
+	if len(value) != 0 {
+		n.SetAttribute("draggable", strconv.FormatBool(value[0]))
+	}
+	return n.attribute["draggable"] == "true"
+}
+
 func (n *Node) TabIndex(value ...int) int {
 	if len(value) != 0 {
 		n.tabIndex = value[0]
@@ -311,6 +342,7 @@ type Border struct {
 	Bottom BorderSide
 	Left   BorderSide
 	Radius BorderRadius
+	Image  BorderImage
 }
 
 type BorderSide struct {
@@ -357,8 +389,10 @@ func (n *Node) CreateElement(name string) Node {
 		"label":    true,
 	}
 
+	// Focusable elements are in the tab order after the elements with a positive tabindex, TabIndex returns 0
+	// for them like tabIndex in browsers. It returned 9999999 before the tab order followed the spec
 	if focusableElements[name] {
-		ti = 9999999
+		ti = 0
 	}
 	return Node{
 		tagName:   name,
@@ -381,7 +415,7 @@ func (n *Node) CreateElement(name string) Node {
 		StyleSheets:       n.StyleSheets,
 		Properties: Properties{
 			Id:             "",
-			EventListeners: make(map[string][]func(Event)),
+			EventListeners: make(map[string][]*Listener),
 			Selected:       []float32{},
 		},
 	}
@@ -465,6 +499,7 @@ func (n *Node) Focus() {
 
 func (n *Node) Blur() {
 	n.focused = false
+	n.focusVisible = false
 	ConditionalStyleHandler(n, map[string]string{})
 }
 
@@ -515,10 +550,84 @@ type Event struct {
 	KeyPress    bool
 	Input       bool
 	Target      *Node
-	Name        string
-	Data        any
-	Value       string
-	Hover       bool
+	// CurrentTarget is the node the running listener was added to, Target is the node the event was sent to
+	CurrentTarget *Node
+	// RelatedTarget is the node losing the focus on focus and focusin and the one getting it on blur and focusout
+	RelatedTarget *Node
+	Phase         int
+	Bubbles       bool
+	Cancelable    bool
+	// Button is the mouse button, 0 for the main button and 2 for the secondary one
+	Button int
+	// Detail is the number of clicks in a row on mousedown, mouseup, click and dblclick
+	Detail int
+	// DeltaX and DeltaY are how far the wheel scrolled in DeltaMode units, positive is right and down
+	DeltaX    float32
+	DeltaY    float32
+	DeltaMode int
+	Name      string
+	Data      any
+	Value     string
+	Hover     bool
+	// inside is true when the mouse is inside of the element, it's set by Monitor.GetEvents
+	inside bool
+	// PointerId, PointerType ("mouse", "pen" or "touch"), Pressure and IsPrimary describe the pointer on the pointer events
+	PointerId   int
+	PointerType string
+	Pressure    float32
+	IsPrimary   bool
+	// DataTransfer is the data being dragged on the drag and drop events
+	DataTransfer *DataTransfer
+	// passive is true while a passive listener runs
+	passive bool
+	flow    *eventFlow
+}
+
+const (
+	NoPhase = iota
+	CapturingPhase
+	AtTarget
+	BubblingPhase
+)
+
+const (
+	DeltaPixel = iota
+	DeltaLine
+	DeltaPage
+)
+
+// eventFlow is shared by the copies of a event handed to the listeners so they can stop or cancel it
+type eventFlow struct {
+	stopped          bool
+	stoppedImmediate bool
+	canceled         bool
+}
+
+// StopPropagation keeps the event from going to the next node, the listeners on the current node still run
+func (e Event) StopPropagation() {
+	if e.flow != nil {
+		e.flow.stopped = true
+	}
+}
+
+// StopImmediatePropagation stops the event from reaching any other listener
+func (e Event) StopImmediatePropagation() {
+	if e.flow != nil {
+		e.flow.stopped = true
+		e.flow.stoppedImmediate = true
+	}
+}
+
+// PreventDefault cancels the default action of the event (moving the focus on mousedown, scrolling on wheel,
+// text insertion and tabbing on keydown...), it does nothing if the event isn't cancelable or in passive listeners
+func (e Event) PreventDefault() {
+	if e.flow != nil && e.Cancelable && !e.passive {
+		e.flow.canceled = true
+	}
+}
+
+func (e Event) DefaultPrevented() bool {
+	return e.flow != nil && e.flow.canceled
 }
 
 type EventList struct {
@@ -526,32 +635,149 @@ type EventList struct {
 	List  []string
 }
 
-func (node *Node) AddEventListener(name string, callback func(Event)) {
-	if node.Properties.EventListeners == nil {
-		node.Properties.EventListeners = make(map[string][]func(Event))
+// Listener is the handle AddEventListener returns, pass it to RemoveEventListener to remove the listener
+type Listener struct {
+	Name     string
+	Options  ListenerOptions
+	callback func(Event)
+	// legacy listeners call the On* field of the node for the event
+	legacy  bool
+	removed bool
+}
+
+type ListenerOptions struct {
+	// Capture runs the listener in the capture phase on the way down to the target instead of when the event bubbles up
+	Capture bool
+	// Once removes the listener before it runs the first time
+	Once bool
+	// Passive listeners can't cancel the event, PreventDefault does nothing in them
+	Passive bool
+}
+
+// !NOTE: Listeners are kept in the order they were added. The On* fields are in the same list as the first listener for
+// + their event, so they run before the listeners added with AddEventListener and StopImmediatePropagation works the same
+// + on both. A On* field can be changed or set to nil at any time, it is read when the event is sent. Functions can't be
+// + compared in go so adding the same callback twice adds two listeners, keep the handle to remove it
+
+// AddEventListener adds a listener for the event name and returns the handle to remove it with. This is the only
+// signature, the useCapture bool it took before the options is ListenerOptions{Capture: true}
+func (node *Node) AddEventListener(name string, callback func(Event), options ...ListenerOptions) *Listener {
+	l := &Listener{
+		Name:     name,
+		callback: callback,
+	}
+	if len(options) > 0 {
+		l.Options = options[0]
+	}
+	// listeners makes the map so it has to run before the map is written to
+	list := node.listeners(name)
+	node.Properties.EventListeners[name] = append(list, l)
+	return l
+}
+
+// RemoveEventListener removes a listener added with AddEventListener, if the event is being sent the listener won't run
+func (node *Node) RemoveEventListener(l *Listener) {
+	if l == nil || l.legacy {
+		return
+	}
+	l.removed = true
+	list := node.Properties.EventListeners[l.Name]
+	for i, v := range list {
+		if v == l {
+			// The slice is copied so a event that's being sent keeps the list it started with
+			node.Properties.EventListeners[l.Name] = append(append([]*Listener{}, list[:i]...), list[i+1:]...)
+			return
+		}
 	}
-	if node.Properties.EventListeners[name] == nil {
-		node.Properties.EventListeners[name] = []func(Event){}
+}
+
+// listeners returns the listeners for the event name, the first time it adds the listener for the On* field
+func (node *Node) listeners(name string) []*Listener {
+	if node.Properties.EventListeners == nil {
+		node.Properties.EventListeners = make(map[string][]*Listener)
 	}
-	if !funcInSlice(callback, node.Properties.EventListeners[name]) {
-		node.Properties.EventListeners[name] = append(node.Properties.EventListeners[name], callback)
+	list, ok := node.Properties.EventListeners[name]
+	if !ok {
+		list = []*Listener{}
+		if _, ok := handlers[name]; ok {
+			list = append(list, &Listener{Name: name, legacy: true})
+		}
+		node.Properties.EventListeners[name] = list
 	}
+	return list
 }
 
-func (node *Node) DispatchEvent(event Event) {
-	for _, v := range node.Properties.EventListeners[event.Name] {
-		v(event)
+// DispatchEvent sends the event to the node. The capture listeners of the ancestors run first from the root down,
+// then the listeners of the node and if the event bubbles the listeners of the ancestors back up to the root.
+// It returns false if a listener called PreventDefault
+func (node *Node) DispatchEvent(event Event) bool {
+	event.Target = node
+	event.flow = &eventFlow{}
+
+	path := []*Node{}
+	for p := node.parent; p != nil; p = p.parent {
+		path = append(path, p)
 	}
-}
 
-func funcInSlice(f func(Event), slice []func(Event)) bool {
-	for _, item := range slice {
-		// Compare function values directly
-		if fmt.Sprintf("%p", item) == fmt.Sprintf("%p", f) {
-			return true
+	event.Phase = CapturingPhase
+	for i := len(path) - 1; i >= 0 && !event.flow.stopped; i-- {
+		event.CurrentTarget = path[i]
+		path[i].runListeners(event, true)
+	}
+
+	// Both the capture and bubble listeners of the target run at the target
+	if !event.flow.stopped {
+		event.Phase = AtTarget
+		event.CurrentTarget = node
+		node.runListeners(event, true)
+		node.runListeners(event, false)
+	}
+
+	if event.Bubbles {
+		event.Phase = BubblingPhase
+		for i := 0; i < len(path) && !event.flow.stopped; i++ {
+			event.CurrentTarget = path[i]
+			path[i].runListeners(event, false)
 		}
 	}
-	return false
+	return !event.flow.canceled
+}
+
+// runListeners calls the capture or bubble listeners of the node
+func (node *Node) runListeners(event Event, capture bool) {
+	for _, l := range node.listeners(event.Name) {
+		if event.flow.stoppedImmediate {
+			return
+		}
+		if l.removed || l.Options.Capture != capture {
+			continue
+		}
+		callback := l.callback
+		if l.legacy {
+			callback = handlers[event.Name](node)
+		}
+		if callback == nil {
+			continue
+		}
+		if l.Options.Once {
+			node.RemoveEventListener(l)
+		}
+		event.passive = l.Options.Passive
+		callback(event)
+	}
+}
+
+// handlers returns the On* field of the events that have one
+var handlers = map[string]func(n *Node) func(Event){
+	"click":       func(n *Node) func(Event) { return n.OnClick },
+	"contextmenu": func(n *Node) func(Event) { return n.OnContextMenu },
+	"mousedown":   func(n *Node) func(Event) { return n.OnMouseDown },
+	"mouseup":     func(n *Node) func(Event) { return n.OnMouseUp },
+	"mouseenter":  func(n *Node) func(Event) { return n.OnMouseEnter },
+	"mouseleave":  func(n *Node) func(Event) { return n.OnMouseLeave },
+	"mouseover":   func(n *Node) func(Event) { return n.OnMouseOver },
+	"mousemove":   func(n *Node) func(Event) { return n.OnMouseMove },
+	"scroll":      func(n *Node) func(Event) { return n.OnScroll },
 }
 
 func NodeToHTML(node *Node) (string, string) {
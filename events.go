@@ -1,9 +1,9 @@
 package grim
 
 import (
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -31,8 +31,46 @@ type Monitor struct {
 	CSS      *CSS
 	Focus    Focus
 	Drag     Drag
+	// fired is the event flags that were on last time RunEvents ran, a event is only sent when its flag turns on
+	fired map[string]bool
+	// root is the document RunEvents ran on last and trap the element the focus is kept in (see focus.go)
+	root *Node
+	trap *Node
+	// key, keyup and wheel are the keydown, keyup and wheel GetEvents found for RunEvents to send
+	key   Event
+	keyup Event
+	wheel Event
+	// held is the keys that are down and canceledKey the held key that had its keydown canceled
+	held        map[int]bool
+	canceledKey int
+	moved       [2]int
+	// clicks counts the clicks in a row on clickTarget, the last one was at clickTime
+	clicks      int
+	clickTarget string
+	clickTime   time.Time
+	// lost and gained are the nodes that lost and got the focus in runDefaults
+	lost, gained *Node
+	// mouseDown and position are the main button and the position of the mouse, pressed and released are true
+	// when the button went down or up since the last RunEvents and dnd is the drag and drop going on
+	mouseDown bool
+	pressed   bool
+	released  bool
+	position  [2]int
+	dnd       *dragSession
+	// pressTarget is the element the main button went down on, it and the elements it's in are :active
+	pressTarget *Node
+	// pointers are the pointers that are down, pointerQueue the pointer events for RunEvents to send, captures
+	// the nodes that got the capture of the pointers and pinch the pinch going on
+	pointers     map[int]*pointerState
+	pointerQueue []pointerInput
+	captures     map[int]*Node
+	pinch        *pinchState
 }
 
+// A click within clickInterval of the last one on the same element adds to the click count
+const clickInterval = 500 * time.Millisecond
+
+// Drag is the scrollbar thumb being dragged, dragging elements is done with drag and drop (see dnd.go)
 type Drag struct {
 	Position []int
 	Type     string
@@ -40,13 +78,36 @@ type Drag struct {
 }
 
 type Focus struct {
-	Selected            int
+	Selected    int
+	Nodes       []string
+	SoftFocused string
+	// Visible is true when the focus was moved with the keyboard, it's what :focus-visible matches
+	Visible bool
+	// Deprecated: LastClickWasFocused isn't set anymore, a click focuses the element or the closest focusable
+	// element it's in (see focusClicked) and Tab moves on from there
 	LastClickWasFocused bool
-	Nodes               []string
-	SoftFocused         string
 }
 
-// !ISSUE: Events fire multiple times find a way to prevent sending repeat events at 120 fps also during drag click gets fired for every element moused over
+// !NOTE: Events are sent with Node.DispatchEvent so they go through the capture and bubble phases. The mouse events
+// + go to the element on top under the mouse and bubble up from it, mouseenter and mouseleave go to every element the
+// + mouse entered or left and don't bubble. A event is only sent when its flag turns on so holding the mouse down
+// + doesn't send a mousedown every frame. The default actions (moving the focus, scrolling and typing) are done
+// + after the events are sent so a listener can cancel them with PreventDefault
+
+// mouseEvents are the events GetEvents flags on the elements, in the order they're sent
+var mouseEvents = []struct {
+	name    string
+	bubbles bool
+	flag    func(e Event) bool
+}{
+	{"mouseenter", false, func(e Event) bool { return e.MouseEnter }},
+	{"mouseover", true, func(e Event) bool { return e.MouseOver }},
+	{"mousedown", true, func(e Event) bool { return e.MouseDown }},
+	{"mouseup", true, func(e Event) bool { return e.MouseUp }},
+	{"click", true, func(e Event) bool { return e.Click }},
+	{"contextmenu", true, func(e Event) bool { return e.ContextMenu }},
+	{"mouseleave", false, func(e Event) bool { return e.MouseLeave }},
+}
 
 // !TODO: Should return modified elements, take nothing as a input bc m.CSS.Document
 // + need to find what changed, remove GetStyles might have to do after adding
@@ -54,78 +115,220 @@ type Focus struct {
 // + for k,v := range m.EventMap
 // + prob storing computed styles should be first bc then you can tell if the event matters
 func (m *Monitor) RunEvents(n *Node) bool {
-	var scrolled bool
-	for _, v := range n.Children {
-		r := m.RunEvents(v)
-		if r {
-			scrolled = r
-		}
+	m.root = n
+	canceled := m.dispatchEvents(n)
+
+	if canceled["keydown"] {
+		m.canceledKey = m.key.KeyCode
 	}
 
-	evt := m.EventMap[n.Properties.Id]
-	evt.Target = n
+	m.lost, m.gained = nil, nil
+	scrolled := m.runDefaults(n, canceled["wheel"] || canceled["keydown"])
 
-	if scrolled {
-		evt.ScrollX = 0
-		evt.ScrollY = 0
-		m.EventMap[n.Properties.Id] = evt
+	// Turning it off first keeps it on for the elements both of them are in
+	setFocusWithin(m.lost, false)
+	setFocusWithin(m.gained, true)
+
+	// blur and focus don't bubble, focusout and focusin are the ones that do
+	if m.lost != nil {
+		m.lost.DispatchEvent(Event{Name: "blur", RelatedTarget: m.gained})
+		m.lost.DispatchEvent(Event{Name: "focusout", Bubbles: true, RelatedTarget: m.gained})
+	}
+	if m.gained != nil {
+		m.gained.DispatchEvent(Event{Name: "focus", RelatedTarget: m.lost})
+		m.gained.DispatchEvent(Event{Name: "focusin", Bubbles: true, RelatedTarget: m.lost})
 	}
-	eventListeners := []string{}
 
-	if evt.MouseDown {
-		if n.OnMouseDown != nil {
-			n.OnMouseDown(evt)
-		}
-		eventListeners = append(eventListeners, "mousedown")
+	m.key = Event{}
+	m.keyup = Event{}
+	m.wheel = Event{}
+	m.pressed = false
+	m.released = false
+	return scrolled
+}
+
+// dispatchEvents sends the events GetEvents found to the nodes and returns the names of the canceled events
+func (m *Monitor) dispatchEvents(root *Node) map[string]bool {
+	s := m.CSS.State
+	canceled := map[string]bool{}
+	nodes := flatten(root)
+
+	focusedId := m.focusedId()
+	if focusedId == "" {
+		focusedId = m.Focus.SoftFocused
 	}
 
-	if evt.MouseUp {
-		if n.OnMouseUp != nil {
-			n.OnMouseUp(evt)
+	// The target is the element on top under the mouse, elements later in the document are drawn over the ones before
+	var target, focused *Node
+	for _, v := range nodes {
+		id := v.Properties.Id
+		if m.EventMap[id].inside && (target == nil || s[id].Z >= s[target.Properties.Id].Z) {
+			target = v
+		}
+		if id == focusedId {
+			focused = v
 		}
-		eventListeners = append(eventListeners, "mouseup")
 	}
 
-	if evt.Click {
-		if n.OnClick != nil {
-			n.OnClick(evt)
-		}
-		eventListeners = append(eventListeners, "click")
+	// Pointer events are sent before the mouse events
+	m.dispatchPointers(nodes)
+	dragging := m.dragEvents(target)
+	if m.pressed {
+		m.pressTarget = target
 	}
 
-	if evt.ContextMenu {
-		if n.OnContextMenu != nil {
-			n.OnContextMenu(evt)
+	fired := map[string]bool{}
+	for _, v := range nodes {
+		id := v.Properties.Id
+		evt := m.EventMap[id]
+		for _, e := range mouseEvents {
+			on := e.flag(evt)
+			// The flags turn on when the mouse moves onto the element, mousedown, click and mouseup are only
+			// sent when the button goes down or up and mouseleave when the mouse leaves a element it entered
+			dispatch := on && !m.fired[e.name+id] && !dragging && (!e.bubbles || v == target)
+			switch e.name {
+			case "mousedown", "click":
+				dispatch = dispatch && m.pressed
+			case "mouseup":
+				dispatch = dispatch && m.released
+			case "mouseleave":
+				dispatch = dispatch && m.fired["mouseenter"+id]
+			}
+			if dispatch {
+				send := evt
+				send.Name = e.name
+				send.Bubbles = e.bubbles
+				send.Cancelable = e.bubbles
+				switch e.name {
+				case "mousedown":
+					m.countClick(id)
+					send.Detail = m.clicks
+				case "mouseup", "click":
+					send.Detail = m.clicks
+				case "contextmenu":
+					send.Button = 2
+				}
+				if !v.DispatchEvent(send) {
+					canceled[e.name] = true
+				} else if e.name == "mousedown" {
+					m.focusClicked(v)
+					m.startDrag(v)
+				}
+				if e.name == "click" && m.clicks == 2 {
+					send.Name = "dblclick"
+					v.DispatchEvent(send)
+				}
+			}
+			fired[e.name+id] = on
 		}
-		eventListeners = append(eventListeners, "contextmenu")
 	}
-
-	if evt.MouseEnter {
-		if n.OnMouseEnter != nil {
-			n.OnMouseEnter(evt)
+	m.fired = fired
+
+	if target != nil && !dragging {
+		evt := m.EventMap[target.Properties.Id]
+		if evt.MouseMove && (evt.X != m.moved[0] || evt.Y != m.moved[1]) {
+			send := evt
+			send.Name = "mousemove"
+			send.Bubbles = true
+			send.Cancelable = true
+			target.DispatchEvent(send)
+			m.moved = [2]int{evt.X, evt.Y}
+		}
+		if m.wheel.Name != "" && !target.DispatchEvent(m.wheel) {
+			canceled["wheel"] = true
 		}
-		eventListeners = append(eventListeners, "mouseenter")
 	}
 
-	if evt.MouseOver {
-		if n.OnMouseOver != nil {
-			n.OnMouseOver(evt)
+	// Keys go to the focused element or the document if nothing has focus
+	t := focused
+	if t == nil {
+		t = root
+	}
+	if m.key.Name != "" {
+		if !t.DispatchEvent(m.key) {
+			canceled["keydown"] = true
+		} else if m.key.KeyCode == 258 {
+			// Tab
+			m.moveFocus(m.key.ShiftKey)
+		} else {
+			m.typeKey(t, focused)
 		}
-		eventListeners = append(eventListeners, "mouseover")
 	}
+	if m.keyup.Name != "" {
+		t.DispatchEvent(m.keyup)
+	}
+	return canceled
+}
+
+// countClick counts the clicks in a row on the element with the id
+func (m *Monitor) countClick(id string) {
+	now := time.Now()
+	if id == m.clickTarget && now.Sub(m.clickTime) < clickInterval {
+		m.clicks++
+	} else {
+		m.clicks = 1
+	}
+	m.clickTarget = id
+	m.clickTime = now
+}
 
-	if evt.MouseLeave {
-		if n.OnMouseLeave != nil {
-			n.OnMouseLeave(evt)
+// typeKey does the default action of a keydown that wasn't canceled. The keys that type a character send a keypress
+// first, then the text is changed in the focused element if it's editable and a input event is sent to it
+func (m *Monitor) typeKey(target, focused *Node) {
+	code := m.key.KeyCode
+	// Shortcuts don't type anything
+	if m.key.CtrlKey || m.key.MetaKey {
+		return
+	}
+	if code >= 32 && code < 256 {
+		press := m.key
+		press.Name = "keypress"
+		press.KeyDown = false
+		press.KeyPress = true
+		if !target.DispatchEvent(press) {
+			return
 		}
-		eventListeners = append(eventListeners, "mouseleave")
+	} else if code != 8 && code != 259 {
+		return
+	}
+
+	if focused == nil || !focused.contentEditable {
+		return
+	}
+	// The adapters send the upper case letter for the key
+	if code >= 'A' && code <= 'Z' && !m.key.ShiftKey {
+		code += 'a' - 'A'
+	}
+	value := ProcessText(focused.InnerText(), code)
+	if value == focused.InnerText() {
+		return
 	}
+	focused.InnerText(value)
+	focused.DispatchEvent(Event{
+		Name:    "input",
+		Bubbles: true,
+		Input:   true,
+		Value:   value,
+	})
+}
 
-	if evt.MouseMove {
-		if n.OnMouseMove != nil {
-			n.OnMouseMove(evt)
+// runDefaults updates the hover and focus of the nodes and scrolls them, noScroll drops the scroll of canceled events
+func (m *Monitor) runDefaults(n *Node, noScroll bool) bool {
+	var scrolled bool
+	for _, v := range n.Children {
+		r := m.runDefaults(v, noScroll)
+		if r {
+			scrolled = r
 		}
-		eventListeners = append(eventListeners, "mousemove")
+	}
+
+	evt := m.EventMap[n.Properties.Id]
+	evt.Target = n
+
+	if scrolled || noScroll {
+		evt.ScrollX = 0
+		evt.ScrollY = 0
+		m.EventMap[n.Properties.Id] = evt
 	}
 
 	if evt.Hover != n.hovered {
@@ -137,18 +340,30 @@ func (m *Monitor) RunEvents(n *Node) bool {
 		ConditionalStyleHandler(n, map[string]string{})
 	}
 
+	// :active is on while the main button is held down after it went down on the element or on a element in it
+	if active := m.mouseDown && m.pressTarget != nil && contains(n, m.pressTarget); active != n.active {
+		n.active = active
+		ConditionalStyleHandler(n, map[string]string{})
+	}
+
 	if len(m.Focus.Nodes) > 0 && m.Focus.Selected > -1 {
 		if m.Focus.Nodes[m.Focus.Selected] == n.Properties.Id {
 			if n.focused == false {
+				m.gained = n
+			}
+			if n.focused == false || n.focusVisible != m.Focus.Visible {
+				n.focusVisible = m.Focus.Visible
 				n.Focus()
 			}
 		} else {
 			if n.focused == true {
+				m.lost = n
 				n.Blur()
 			}
 		}
 	} else {
 		if n.focused == true {
+			m.lost = n
 			n.Blur()
 		}
 	}
@@ -172,9 +387,7 @@ func (m *Monitor) RunEvents(n *Node) bool {
 				left = 0
 			}
 
-			if n.OnScroll != nil {
-				n.OnScroll(evt)
-			}
+			n.DispatchEvent(scrollEvent(evt))
 
 			evt.ScrollX = 0
 			m.EventMap[n.Properties.Id] = evt
@@ -199,9 +412,7 @@ func (m *Monitor) RunEvents(n *Node) bool {
 				top = 0
 			}
 
-			if n.OnScroll != nil {
-				n.OnScroll(evt)
-			}
+			n.DispatchEvent(scrollEvent(evt))
 			evt.ScrollY = 0
 			m.EventMap[n.Properties.Id] = evt
 			scrolled = true
@@ -211,73 +422,46 @@ func (m *Monitor) RunEvents(n *Node) bool {
 	if scrolled {
 		n.ScrollTo(left, top)
 	}
-
-	for _, v := range eventListeners {
-		if len(n.Properties.EventListeners[v]) > 0 {
-			for _, handler := range n.Properties.EventListeners[v] {
-				handler(evt)
-			}
-		}
-	}
 	return scrolled
 }
 
-type fn struct {
-	Id       string
-	TabIndex int
+// scrollEvent is sent to the element that scrolled, it doesn't bubble and can't be canceled
+func scrollEvent(evt Event) Event {
+	evt.Name = "scroll"
+	evt.Bubbles = false
+	evt.Cancelable = false
+	return evt
 }
 
 func (m *Monitor) GetEvents(data *EventData) {
-	headElements := []string{
-		"head",
-		"title",    // Defines the title of the document
-		"base",     // Specifies the base URL for all relative URLs in the document
-		"link",     // Links to external resources like stylesheets
-		"meta",     // Provides metadata about the document (e.g., character set, viewport)
-		"style",    // Embeds internal CSS styles
-		"script",   // Embeds or references JavaScript code
-		"noscript", // Provides alternate content for users without JavaScript
-		"template", // Used to define a client-side template
-	}
-
 	s := m.CSS.State
 
-	m.Focus.LastClickWasFocused = false
-	// update focesable nodes
-	nodes := []fn{}
-	for k, self := range s {
-		if self.TabIndex > -1 {
-			nodes = append(nodes, fn{Id: k, TabIndex: self.TabIndex})
-		}
+	// A keydown is sent once when the key goes down, not every time GetEvents runs while it's held
+	if m.held == nil {
+		m.held = map[int]bool{}
 	}
-
-	sort.Slice(nodes, func(i, j int) bool {
-		return nodes[i].TabIndex < nodes[j].TabIndex // Ascending order by TabIndex
-	})
-
-	m.Focus.Nodes = []string{}
-	for _, v := range nodes {
-		good := true
-
-		for _, tag := range headElements {
-			if len(v.Id) >= len(tag) {
-				if v.Id[0:len(tag)] == tag {
-					good = false
-				}
+	if data.Key != 0 {
+		if data.KeyState && !m.held[data.Key] {
+			m.held[data.Key] = true
+			m.key = keyEvent("keydown", data)
+			m.key.KeyDown = true
+		} else if !data.KeyState && m.held[data.Key] {
+			delete(m.held, data.Key)
+			m.keyup = keyEvent("keyup", data)
+			m.keyup.KeyUp = true
+			if data.Key == m.canceledKey {
+				m.canceledKey = 0
 			}
 		}
-
-		if good {
-			m.Focus.Nodes = append(m.Focus.Nodes, v.Id)
-		}
 	}
+	// update focesable nodes
+	m.updateFocus()
 	if m.Drag.Position == nil {
 		m.Drag = Drag{Position: []int{-1, -1}}
 	}
 	drag := false
 	if m.Drag.Position != nil {
 		if m.Drag.Position[0] > -1 && m.Drag.Position[1] > -1 {
-			// !ISSUE: it should only fire on only draggable
 			drag = true
 		}
 	}
@@ -285,6 +469,30 @@ func (m *Monitor) GetEvents(data *EventData) {
 	if data.Position == nil {
 		return
 	}
+	m.pressed = m.pressed || (!m.mouseDown && data.Click)
+	m.released = m.released || (m.mouseDown && !data.Click)
+	m.mouseDown = data.Click
+	m.position = [2]int{data.Position[0], data.Position[1]}
+
+	if data.ScrollX != 0 || data.ScrollY != 0 {
+		// ScrollY is positive when the wheel is turned up, deltaY is positive down like in browsers
+		m.wheel = Event{
+			Name:       "wheel",
+			Bubbles:    true,
+			Cancelable: true,
+			X:          data.Position[0],
+			Y:          data.Position[1],
+			ScrollX:    data.ScrollX,
+			ScrollY:    data.ScrollY,
+			DeltaX:     float32(data.ScrollX),
+			DeltaY:     float32(-data.ScrollY),
+			DeltaMode:  DeltaPixel,
+			CtrlKey:    data.Modifiers.CtrlKey,
+			ShiftKey:   data.Modifiers.ShiftKey,
+			MetaKey:    data.Modifiers.MetaKey,
+			AltKey:     data.Modifiers.AltKey,
+		}
+	}
 
 	// if !data.Click {
 	// 	m.Drag.Id = ""
@@ -296,7 +504,7 @@ func (m *Monitor) GetEvents(data *EventData) {
 		var isMouseOver, isFocused bool
 
 		if m.Focus.Selected > -1 {
-			isFocused = m.Focus.Nodes[m.Focus.Selected] == k
+			isFocused = m.focusedId() == k
 		} else if m.Focus.SoftFocused != "" {
 			isFocused = m.Focus.SoftFocused == k
 		} else {
@@ -309,19 +517,14 @@ func (m *Monitor) GetEvents(data *EventData) {
 			evt = Event{}
 		}
 
-		boxLeft := self.X - self.Border.Left.Width
-		boxRight := self.X + self.Width + self.Border.Left.Width + self.Border.Right.Width
-		boxTop := self.Y - self.Border.Top.Width
-		boxBottom := self.Y + self.Height + self.Border.Top.Width + self.Border.Bottom.Width
-
-		insideX := (boxLeft < float32(data.Position[0]) && boxRight > float32(data.Position[0]))
-		insideY := (boxTop < float32(data.Position[1]) && boxBottom > float32(data.Position[1]))
-		inside := (insideX && insideY)
+		inside := insideBox(self, float32(data.Position[0]), float32(data.Position[1]))
+		evt.inside = inside
 
 		arrowScrollX := 0
 		arrowScrollY := 0
 
-		if m.Focus.SoftFocused == k || inside {
+		// The arrow keys don't scroll while their keydown is canceled
+		if (m.Focus.SoftFocused == k || inside) && data.KeyState && data.Key != m.canceledKey {
 			if data.Key == 265 {
 				// up
 				arrowScrollY += 20
@@ -338,36 +541,7 @@ func (m *Monitor) GetEvents(data *EventData) {
 			}
 		}
 
-		if isFocused {
-
-			// This allows for arrow scrolling when off the element and typing
-
-			// Get the keycode of the pressed key
-			if data.Key != 0 {
-				if self.ContentEditable {
-					// Sync the innertext and value but idk
-					// !ISSUE: This may not work
-					ProcessText(self, int(data.Key))
-					evt.Value = self.Value
-				}
-			}
-
-			if data.Key == 258 && data.KeyState && !m.Focus.LastClickWasFocused {
-				// Tab
-				mfsLen := len(m.Focus.Nodes)
-				if mfsLen > 0 {
-					store := m.Focus.Selected
-					m.Focus.Selected += 1
-					if m.Focus.Selected >= mfsLen {
-						m.Focus.Selected = 0
-					}
-					if store != m.Focus.Selected {
-						m.Focus.LastClickWasFocused = true
-					}
-				}
-
-			}
-		}
+		// Typing and moving the focus with Tab are done by RunEvents after the keydown is sent
 
 		if inside || isFocused {
 			// Mouse is over element
@@ -414,69 +588,14 @@ func (m *Monitor) GetEvents(data *EventData) {
 
 			if data.Click && !evt.Click && !drag {
 				evt.Click = true
+				// Focusing the element is the default action of the mousedown (see focusClicked)
 
-				if !inside && !(self.TabIndex > -1) {
-					if m.Focus.SoftFocused == k {
-						m.Focus.SoftFocused = ""
-						softFocus = ""
-					}
-				}
-
-				if self.TabIndex > -1 {
-					if m.Focus.Selected > -1 {
-						if len(m.Focus.Nodes) > 0 && m.Focus.Selected > -1 {
-							if m.Focus.Nodes[m.Focus.Selected] != k {
-								for i, v := range m.Focus.Nodes {
-									if v == k {
-										m.Focus.Selected = i
-										m.Focus.LastClickWasFocused = true
-										break
-									}
-								}
-							} else {
-								m.Focus.LastClickWasFocused = true
-							}
-						} else {
-							m.Focus.LastClickWasFocused = true
-						}
-
-					} else {
-						selectedIndex := -1
-						for i, v := range m.Focus.Nodes {
-							if v == k {
-								selectedIndex = i
-							}
-						}
-						if selectedIndex == -1 {
-							nodes = append(nodes, fn{Id: k, TabIndex: self.TabIndex})
-							sort.Slice(nodes, func(i, j int) bool {
-								return nodes[i].TabIndex < nodes[j].TabIndex // Ascending order by TabIndex
-							})
-							m.Focus.Nodes = []string{}
-							for _, v := range nodes {
-								m.Focus.Nodes = append(m.Focus.Nodes, v.Id)
-							}
-
-							for i, v := range m.Focus.Nodes {
-								if v == k {
-									selectedIndex = i
-								}
-							}
-						}
-
-						m.Focus.Selected = selectedIndex
-						m.Focus.LastClickWasFocused = true
-					}
-
-				} else if m.Focus.Selected > -1 {
-					if len(m.Focus.Nodes) > 0 && m.Focus.Selected > -1 {
-						if m.Focus.Nodes[m.Focus.Selected] != k && !m.Focus.LastClickWasFocused {
-							m.Focus.Selected = -1
-						}
-					}
+				if !inside && m.Focus.SoftFocused == k {
+					m.Focus.SoftFocused = ""
+					softFocus = ""
 				}
 
-				if inside && m.Focus.Selected == -1 {
+				if inside {
 					if softFocus == "" {
 						softFocus = k
 					} else {
@@ -493,9 +612,7 @@ func (m *Monitor) GetEvents(data *EventData) {
 			}
 			// Regardless set soft focus to trigger events to the selected element: when non is set default body???
 
-			if data.Context {
-				evt.ContextMenu = true
-			}
+			evt.ContextMenu = data.Context
 			if (data.ScrollY != 0 && (inside)) || (data.ScrollX != 0 && (inside)) || arrowScrollX != 0 || arrowScrollY != 0 || drag {
 				if drag && m.Drag.Id != "" {
 					e := m.EventMap[m.Drag.Id]
@@ -544,6 +661,12 @@ func (m *Monitor) GetEvents(data *EventData) {
 		} else {
 			isMouseOver = false
 			evt.Hover = false
+			// Letting go of the mouse outside of the element ends its press
+			if !data.Click {
+				evt.MouseDown = false
+				evt.MouseUp = true
+				evt.Click = false
+			}
 		}
 
 		if !isMouseOver && !evt.MouseLeave {
@@ -566,15 +689,94 @@ func (m *Monitor) GetEvents(data *EventData) {
 	}
 }
 
-// ProcessText processes key events for text entry.
-func ProcessText(self State, key int) {
+// keyEvent makes the key event with the name for the key in data
+func keyEvent(name string, data *EventData) Event {
+	return Event{
+		Name:       name,
+		Bubbles:    true,
+		Cancelable: true,
+		KeyCode:    data.Key,
+		Key:        keyName(data.Key, data.Modifiers.ShiftKey),
+		CtrlKey:    data.Modifiers.CtrlKey,
+		ShiftKey:   data.Modifiers.ShiftKey,
+		MetaKey:    data.Modifiers.MetaKey,
+		AltKey:     data.Modifiers.AltKey,
+	}
+}
+
+// keyNames are the names of the keys that don't type a character, the codes are the ones the adapters send
+var keyNames = map[int]string{
+	8:   "Backspace",
+	256: "Escape",
+	257: "Enter",
+	258: "Tab",
+	259: "Backspace",
+	260: "Insert",
+	261: "Delete",
+	262: "ArrowRight",
+	263: "ArrowLeft",
+	264: "ArrowDown",
+	265: "ArrowUp",
+	266: "PageUp",
+	267: "PageDown",
+	268: "Home",
+	269: "End",
+	280: "CapsLock",
+	340: "Shift",
+	341: "Control",
+	342: "Alt",
+	343: "Meta",
+	344: "Shift",
+	345: "Control",
+	346: "Alt",
+	347: "Meta",
+}
+
+// keyName returns the value of Event.Key for the key code
+func keyName(code int, shift bool) string {
+	if name, ok := keyNames[code]; ok {
+		return name
+	}
+	if code >= 290 && code <= 314 {
+		return "F" + strconv.Itoa(code-289)
+	}
+	if code >= 'A' && code <= 'Z' && !shift {
+		code += 'a' - 'A'
+	}
+	if code >= 32 && code < 256 {
+		return string(rune(code))
+	}
+	return "Unidentified"
+}
+
+// insideBox reports if x, y is inside of the border box of the state, the point is moved into the space of
+// the element before the transform
+func insideBox(self State, x, y float32) bool {
+	boxLeft := self.X - self.Border.Left.Width
+	boxRight := self.X + self.Width + self.Border.Left.Width + self.Border.Right.Width
+	boxTop := self.Y - self.Border.Top.Width
+	boxBottom := self.Y + self.Height + self.Border.Top.Width + self.Border.Bottom.Width
+
+	if self.Transform != nil {
+		inverse, ok := self.Transform.Invert()
+		if !ok {
+			return false
+		}
+		tx, ty := inverse.TransformPoint(float64(x), float64(y))
+		x, y = float32(tx), float32(ty)
+	}
+	return boxLeft < x && boxRight > x && boxTop < y && boxBottom > y
+}
+
+// ProcessText returns value with the key typed into it
+func ProcessText(value string, key int) string {
 	// Handle key events for text entry
 	switch key {
-	case 8:
+	case 8, 259:
 		// Backspace: remove the last character
-		if len(self.Value) > 0 {
-			self.Value = self.Value[:len(self.Value)-1]
-			// n.InnerText = n.InnerText[:len(n.InnerText)-1]
+		if len(value) > 0 {
+			r := []rune(value)
+			value = string(r[:len(r)-1])
 		}
 
 	case 65:
@@ -605,9 +807,12 @@ func ProcessText(self State, key int) {
 		// }
 
 	default:
-		// Record other key presses
-		self.Value += string(rune(key))
+		// Record other key presses, keys past 255 are keys like tab, the arrows and shift that don't type anything
+		if key < 256 {
+			value += string(rune(key))
+		}
 	}
+	return value
 }
 
 func extractNumber(input string) int {
@@ -143,18 +143,13 @@ func parseLayer(layer string) map[string]string {
 		"background-clip":       "border-box",
 	}
 
-	// Extract url() part first
-	if strings.Contains(layer, "url(") {
-		urlMatch := extractFunc("url", layer)
-		if urlMatch != "" {
-			result["background-image"] = urlMatch
-			layer = strings.Replace(layer, urlMatch, "", 1)
-		}
-	} else if strings.Contains("linear-gradient(", layer) {
-		urlMatch := extractFunc("linear-gradient", layer)
-		if urlMatch != "" {
+	// Extract url() part first, the repeating gradients go before the others so
+	// linear-gradient doesn't match inside of repeating-linear-gradient
+	for _, name := range []string{"url", "repeating-linear-gradient", "repeating-radial-gradient", "repeating-conic-gradient", "linear-gradient", "radial-gradient", "conic-gradient"} {
+		if urlMatch := extractFunc(name, layer); urlMatch != "" {
 			result["background-image"] = urlMatch
 			layer = strings.Replace(layer, urlMatch, "", 1)
+			break
 		}
 	}
 
@@ -177,12 +172,19 @@ func parseLayer(layer string) map[string]string {
 	}
 	// Process remaining tokens
 	tokens := Token('(', ')', ' ', layer)
+	repeat := false
 	for _, token := range tokens {
 		switch {
 		case isColorValue(token):
 			result["background-color"] = token
 		case isRepeatValue(token):
-			result["background-repeat"] = token
+			// Two values are the horizontal and vertical repeat
+			if repeat {
+				result["background-repeat"] += " " + token
+			} else {
+				result["background-repeat"] = token
+				repeat = true
+			}
 		case isAttachmentValue(token):
 			result["background-attachment"] = token
 		case isBoxValue(token):
@@ -354,7 +356,7 @@ func isAttachmentValue(value string) bool {
 }
 
 func isBoxValue(value string) bool {
-	return value == "border-box" || value == "padding-box" || value == "content-box"
+	return value == "border-box" || value == "padding-box" || value == "content-box" || value == "text"
 }
 
 func isPositionValue(value string) bool {
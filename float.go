@@ -0,0 +1,258 @@
+package grim
+
+import (
+	"math"
+)
+
+// !DEVMAN: Floats are taken out of the flow and pushed to the left or right of their containing block, as
+// + high as they fit but never above a float that came before them. CSS.floats carries the floats placed so far
+// + in the current block formatting context through the layout, a element that establishes one (flow-root, a
+// + float, overflow other than visible, inline-block, flex and grid items...) starts a empty list for its
+// + content and puts the list of its parent back when it's done. Line boxes get shorter where they overlap a
+// + float, a float in the middle of the text goes on the current line if it fits, and clear moves a box below
+// + them. A block formatting context grows to contain the floats inside of it and its border box is moved beside
+// + the floats before it instead of overlapping them
+
+// floatBox is the margin box of a placed float in window coordinates
+type floatBox struct {
+	side   string
+	x1, y1 float32
+	x2, y2 float32
+}
+
+// floatSide returns the side the node floats to, "" if it doesn't float. Floats are ignored on positioned
+// elements and on flex and grid items
+func floatSide(n *Node) string {
+	style := n.ComputedStyle
+	if isPositioned(style) || (n.parent != nil && itemDisplays[n.parent.ComputedStyle["display"]]) {
+		return ""
+	}
+	switch style["float"] {
+	case "left", "right":
+		return style["float"]
+	case "inline-start":
+		if style["direction"] == "rtl" {
+			return "right"
+		}
+		return "left"
+	case "inline-end":
+		if style["direction"] == "rtl" {
+			return "left"
+		}
+		return "right"
+	}
+	return ""
+}
+
+func isFloated(n *Node) bool {
+	return floatSide(n) != ""
+}
+
+// establishesBFC reports if the node contains the floats inside of it and keeps the floats outside out
+func establishesBFC(n *Node) bool {
+	style := n.ComputedStyle
+	if n.parent == nil || n.tagName == "html" || isFloated(n) || isPositioned(style) {
+		return true
+	}
+	switch style["display"] {
+	case "flow-root", "inline-block", "table-cell", "table-caption", "flex", "inline-flex", "grid", "inline-grid":
+		return true
+	}
+	if itemDisplays[n.parent.ComputedStyle["display"]] {
+		return true
+	}
+	for _, p := range []string{"overflow", "overflow-x", "overflow-y"} {
+		if v := style[p]; v != "" && v != "visible" && v != "clip" {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFloats adds the floats of n to floats, floats inside of a other block formatting context are contained by it
+func (c *CSS) collectFloats(n *Node, floats []floatBox) []floatBox {
+	if side := floatSide(n); side != "" {
+		return append(floats, c.floatBox(n, side))
+	}
+	if n.ComputedStyle["display"] == "none" || establishesBFC(n) {
+		return floats
+	}
+	for _, v := range n.Children {
+		floats = c.collectFloats(v, floats)
+	}
+	return floats
+}
+
+// floatBoxes returns where the floats are now, the list holds the nodes because the blocks they are in can still
+// be moved after they are placed
+func (c *CSS) floatBoxes(floats []*Node) []floatBox {
+	boxes := make([]floatBox, 0, len(floats))
+	for _, v := range floats {
+		boxes = append(boxes, c.floatBox(v, floatSide(v)))
+	}
+	return boxes
+}
+
+func (c *CSS) floatBox(n *Node, side string) floatBox {
+	s := c.State[n.Properties.Id]
+	return floatBox{
+		side: side,
+		x1:   s.X - s.Margin.Left,
+		y1:   s.Y - s.Margin.Top,
+		x2:   s.X + s.Width + s.Border.Left.Width + s.Border.Right.Width + s.Margin.Right,
+		y2:   s.Y + s.Height + s.Border.Top.Width + s.Border.Bottom.Width + s.Margin.Bottom,
+	}
+}
+
+// placeFloat moves a float to the left or right of its containing block at or below y, the top of its
+// margin box, and returns where it went
+func (c *CSS) placeFloat(n *Node, y float32, floats []floatBox) floatBox {
+	parent := c.State[n.parent.Properties.Id]
+	left := parent.X + parent.Border.Left.Width + parent.Padding.Left
+	right := parent.X + parent.Border.Left.Width + parent.Width - parent.Padding.Right
+
+	side := floatSide(n)
+	b := c.floatBox(n, side)
+	width, height := b.x2-b.x1, b.y2-b.y1
+	y = Max(y, clearance(n.ComputedStyle["clear"], floats))
+	x, y := floatPosition(side, width, height, y, left, right, floats)
+	shiftNode(n, c.State, x-b.x1, y-b.y1)
+	return c.floatBox(n, side)
+}
+
+// floatPosition returns where the margin box of a float goes, left and right are the edges of the containing block
+func floatPosition(side string, width, height, y, left, right float32, floats []floatBox) (float32, float32) {
+	// A float can't be higher than the floats before it
+	for _, f := range floats {
+		y = Max(y, f.y1)
+	}
+	return fitBeside(side, width, height, y, left, right, floats)
+}
+
+// fitBeside returns the first place at or below y where a box fits on the side of the floats
+func fitBeside(side string, width, height, y, left, right float32, floats []floatBox) (float32, float32) {
+	for {
+		l, r := left, right
+		next := float32(math.Inf(1))
+		for _, f := range floats {
+			if f.y2 <= y || f.y1 >= y+Max(height, 1) {
+				continue
+			}
+			if f.side == "left" {
+				l = Max(l, f.x2)
+			} else {
+				r = Min(r, f.x1)
+			}
+			next = Min(next, f.y2)
+		}
+		// Floats wider than the containing block go below the other floats
+		if r-l >= width || math.IsInf(float64(next), 1) {
+			if side == "right" {
+				return r - width, y
+			}
+			return l, y
+		}
+		y = next
+	}
+}
+
+// floatSpace returns the part of left to right that isn't covered by floats between y and y+height
+func floatSpace(y, height, left, right float32, floats []floatBox) (float32, float32) {
+	for _, f := range floats {
+		if f.y2 <= y || f.y1 >= y+Max(height, 1) {
+			continue
+		}
+		if f.side == "left" {
+			left = Max(left, f.x2)
+		} else {
+			right = Min(right, f.x1)
+		}
+	}
+	return left, Max(left, right)
+}
+
+// clearance returns the bottom of the floats the clear value moves a box below
+func clearance(clear string, floats []floatBox) float32 {
+	var bottom float32
+	for _, f := range floats {
+		if clear == "both" || clear == f.side {
+			bottom = Max(bottom, f.y2)
+		}
+	}
+	return bottom
+}
+
+// innerFloats returns the floats inside of n that n has to contain
+func (c *CSS) innerFloats(n *Node) []floatBox {
+	floats := []floatBox{}
+	for _, v := range n.Children {
+		floats = c.collectFloats(v, floats)
+	}
+	return floats
+}
+
+// containFloats makes a block formatting context without a height tall enough for the floats inside of it
+func (c *CSS) containFloats(n *Node, floats []floatBox) {
+	self := c.State[n.Properties.Id]
+	var bottom float32
+	for _, f := range floats {
+		bottom = Max(bottom, f.y2)
+	}
+	if bottom == 0 {
+		return
+	}
+	if n.ComputedStyle["height"] == "" {
+		self.Height = Max(self.Height, bottom-self.Y-self.Border.Top.Width+self.Padding.Bottom)
+	}
+	if sh := int(bottom-self.Y) + int(self.Padding.Bottom); sh > self.ScrollHeight {
+		self.ScrollHeight = sh
+	}
+	c.State[n.Properties.Id] = self
+}
+
+// avoidsFloats reports if n is a block in the flow that establishes a block formatting context, its border box
+// can't overlap the floats before it
+func avoidsFloats(n *Node) bool {
+	if n.parent == nil || n.tagName == "html" || !isInFlow(n) || isInlineLevel(n) || !establishesBFC(n) {
+		return false
+	}
+	switch n.ComputedStyle["display"] {
+	case "table-cell", "table-caption":
+		return false
+	}
+	return !itemDisplays[n.parent.ComputedStyle["display"]]
+}
+
+// avoidFloats moves a block formatting context beside the floats before it. A box without a width is made
+// narrower to fit between them, down to its padding and border, and a box that doesn't fit goes below them
+func (c *CSS) avoidFloats(n *Node, floats []floatBox) {
+	self := c.State[n.Properties.Id]
+	parent := c.State[n.parent.Properties.Id]
+	left := parent.X + parent.Border.Left.Width + parent.Padding.Left
+	right := parent.X + parent.Border.Left.Width + parent.Width - parent.Padding.Right
+
+	m := self.Margin
+	x, y := self.X-m.Left, self.Y-m.Top
+	width := self.Width + self.Border.Left.Width + self.Border.Right.Width + m.Left + m.Right
+	if l, r := floatSpace(y, 1, left, right, floats); x >= l && x+width <= r {
+		return
+	}
+
+	auto := n.ComputedStyle["width"] == ""
+	minWidth := width
+	if auto {
+		minWidth -= self.Width - self.Padding.Left - self.Padding.Right
+	}
+	fx, fy := fitBeside("left", minWidth, 1, y, left, right, floats)
+	if auto {
+		_, r := floatSpace(fy, 1, left, right, floats)
+		if over := fx + width - r; over > 0 {
+			self.Width -= over
+		}
+	}
+	if fx > x {
+		self.X += fx - x
+	}
+	self.Y += fy - y
+	c.State[n.Properties.Id] = self
+}
@@ -0,0 +1,197 @@
+package grim
+
+import (
+	"slices"
+	"sort"
+)
+
+// !DEVMAN: Tab moves the focus forward through the elements in the tab order and Shift+Tab moves it back, both wrap
+// + around. The order is the elements with a positive tabindex from low to high (ties in document order) then the
+// + elements with a tabindex of 0 in document order, elements with a tabindex of -1, disabled elements and elements
+// + that aren't shown are skipped. Clicking a element focuses it or the closest focusable element it's in.
+// + Window.TrapFocus keeps the focus inside of a element (a modal) until Window.ReleaseFocus is called
+
+// unfocusable are the elements Tab skips along with everything in them
+var unfocusable = map[string]bool{
+	"head":     true,
+	"title":    true, // Defines the title of the document
+	"base":     true, // Specifies the base URL for all relative URLs in the document
+	"link":     true, // Links to external resources like stylesheets
+	"meta":     true, // Provides metadata about the document (e.g., character set, viewport)
+	"style":    true, // Embeds internal CSS styles
+	"script":   true, // Embeds or references JavaScript code
+	"noscript": true, // Provides alternate content for users without JavaScript
+	"template": true, // Used to define a client-side template
+}
+
+// tabOrder returns the ids of the elements Tab moves through in order, only the elements in the focus trap are
+// in it while there is one
+func (m *Monitor) tabOrder() []string {
+	root := m.root
+	if m.trap != nil {
+		root = m.trap
+	}
+	if root == nil {
+		return []string{}
+	}
+
+	var positive, rest []*Node
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if unfocusable[n.tagName] || n.ComputedStyle["display"] == "none" {
+			return
+		}
+		if n.tabIndex > -1 && !n.disabled && n.ComputedStyle["visibility"] != "hidden" {
+			if n.tabIndex > 0 {
+				positive = append(positive, n)
+			} else {
+				rest = append(rest, n)
+			}
+		}
+		for _, v := range n.Children {
+			walk(v)
+		}
+	}
+	walk(root)
+
+	// The sort is stable so elements with the same tabindex stay in document order
+	sort.SliceStable(positive, func(i, j int) bool {
+		return positive[i].tabIndex < positive[j].tabIndex
+	})
+
+	ids := make([]string, 0, len(positive)+len(rest))
+	for _, v := range append(positive, rest...) {
+		ids = append(ids, v.Properties.Id)
+	}
+	return ids
+}
+
+// updateFocus rebuilds the tab order and keeps the focus on the same element, the focus is dropped when the
+// element can't be focused anymore
+func (m *Monitor) updateFocus() {
+	focused := m.focusedId()
+	m.Focus.Nodes = m.tabOrder()
+	m.Focus.Selected = slices.Index(m.Focus.Nodes, focused)
+}
+
+// focusedId returns the id of the focused element or "" if nothing has focus
+func (m *Monitor) focusedId() string {
+	if m.Focus.Selected > -1 && m.Focus.Selected < len(m.Focus.Nodes) {
+		return m.Focus.Nodes[m.Focus.Selected]
+	}
+	return ""
+}
+
+// moveFocus moves the focus to the next element in the tab order or the previous one when backward is true
+func (m *Monitor) moveFocus(backward bool) {
+	count := len(m.Focus.Nodes)
+	if count == 0 {
+		return
+	}
+	if backward {
+		if m.Focus.Selected <= 0 {
+			m.Focus.Selected = count - 1
+		} else {
+			m.Focus.Selected--
+		}
+	} else {
+		m.Focus.Selected = (m.Focus.Selected + 1) % count
+	}
+	m.Focus.Visible = true
+}
+
+// focusClicked is the default action of mousedown, it focuses the target or the closest focusable element it's in.
+// Clicking something that can't be focused blurs the focused element unless it's outside of the focus trap
+func (m *Monitor) focusClicked(target *Node) {
+	for v := target; v != nil; v = v.parent {
+		if i := slices.Index(m.Focus.Nodes, v.Properties.Id); i > -1 {
+			m.Focus.Selected = i
+			// Focus from the mouse doesn't show the focus ring
+			m.Focus.Visible = false
+			return
+		}
+	}
+	if m.trap != nil && !contains(m.trap, target) {
+		return
+	}
+	m.Focus.Selected = -1
+}
+
+// setFocusWithin turns :focus-within on or off for the node and the elements it's in
+func setFocusWithin(n *Node, on bool) {
+	for v := n; v != nil; v = v.parent {
+		if v.focusWithin == on {
+			continue
+		}
+		v.focusWithin = on
+		if slices.ContainsFunc(v.conditionalRules, func(r conditionalRule) bool { return r.state == ":focus-within" }) {
+			ConditionalStyleHandler(v, map[string]string{})
+		}
+	}
+}
+
+// contains reports if n is parent or inside of it
+func contains(parent, n *Node) bool {
+	for v := n; v != nil; v = v.parent {
+		if v == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// findNode returns the node with the id in the tree under n
+func findNode(n *Node, id string) *Node {
+	if n.Properties.Id == id {
+		return n
+	}
+	for _, v := range n.Children {
+		if f := findNode(v, id); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// ActiveElement returns the focused element, the body is returned when nothing has focus like document.activeElement
+func (w *Window) ActiveElement() *Node {
+	if w.monitor == nil || len(w.document.Children) == 0 {
+		return nil
+	}
+	root := w.document.Children[0]
+	if id := w.monitor.focusedId(); id != "" {
+		if n := findNode(root, id); n != nil {
+			return n
+		}
+	}
+	for _, v := range root.Children {
+		if v.tagName == "body" {
+			return v
+		}
+	}
+	return root
+}
+
+// TrapFocus keeps the focus inside of n, Tab and Shift+Tab wrap around the elements in it and clicking outside of
+// it doesn't move the focus. The focus is moved to the first element in n if it was outside of it
+func (w *Window) TrapFocus(n *Node) {
+	if w.monitor == nil {
+		return
+	}
+	m := w.monitor
+	m.trap = n
+	m.updateFocus()
+	if m.Focus.Selected == -1 && len(m.Focus.Nodes) > 0 {
+		m.Focus.Selected = 0
+		m.Focus.Visible = true
+	}
+}
+
+// ReleaseFocus ends the focus trap, the focus stays where it is
+func (w *Window) ReleaseFocus() {
+	if w.monitor == nil {
+		return
+	}
+	w.monitor.trap = nil
+	w.monitor.updateFocus()
+}
@@ -32,6 +32,8 @@ type MetaData struct {
 	LineHeight          int
 	WordSpacing         int
 	WhiteSpace          string
+	TabSize             int
+	WordGap             float32
 	Shadows             []Shadow // need
 	Width               int
 	WordBreak           string
@@ -200,10 +202,96 @@ func MeasureSpace(t *MetaData) int {
 func FontKey(text *MetaData) string {
 	key := text.Text + RGBAtoString(text.Color) + RGBAtoString(text.DecorationColor) + text.Align + text.WordBreak + strconv.Itoa(text.WordSpacing) + strconv.Itoa(text.LetterSpacing) + text.WhiteSpace + strconv.Itoa(text.DecorationThickness) + strconv.Itoa(text.EM)
 	key += strconv.FormatBool(text.Overlined) + strconv.FormatBool(text.Underlined) + strconv.FormatBool(text.LineThrough) + text.FontFamily
+	key += strconv.Itoa(text.LineHeight) + strconv.FormatFloat(float64(text.WordGap), 'f', 2, 32)
 	return key
 }
 
+// CollapseWhiteSpace applies the white-space collapsing rules of the given mode to the
+// raw text of a node. The parser keeps whitespace as is so it can be resolved here once the styles are known
+func CollapseWhiteSpace(text, mode string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	switch mode {
+	case "pre", "pre-wrap", "break-spaces":
+		return text
+	case "pre-line":
+		lines := strings.Split(text, "\n")
+		for i, v := range lines {
+			lines[i] = strings.Join(strings.Fields(v), " ")
+		}
+		return strings.Trim(strings.Join(lines, "\n"), "\n")
+	default:
+		return strings.Join(strings.Fields(text), " ")
+	}
+}
+
+// WhiteSpaceWraps reports if lines are allowed to soft wrap in the given white-space mode
+func WhiteSpaceWraps(mode string) bool {
+	return mode != "nowrap" && mode != "pre"
+}
+
+// PreservesWhiteSpace reports if the given white-space mode keeps spaces and newlines
+func PreservesWhiteSpace(mode string) bool {
+	return mode == "pre" || mode == "pre-wrap" || mode == "break-spaces" || mode == "pre-line"
+}
+
+// ExpandTabs replaces tabs with spaces up to the next tab stop
+func ExpandTabs(text string, size int) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+	if size <= 0 {
+		size = 8
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range text {
+		switch r {
+		case '\t':
+			spaces := size - (col % size)
+			b.WriteString(strings.Repeat(" ", spaces))
+			col += spaces
+		case '\n':
+			b.WriteRune(r)
+			col = 0
+		default:
+			b.WriteRune(r)
+			col++
+		}
+	}
+	return b.String()
+}
+
+// FontMetrics returns the ascent and descent of the font at the size of the text
+func FontMetrics(t *MetaData) (float32, float32) {
+	face := truetype.NewFace(t.Font, &truetype.Options{
+		Size:    (float64(t.EM) * 72) / 96,
+		DPI:     96,
+		Hinting: font.HintingNone,
+	})
+	defer face.Close()
+	m := face.Metrics()
+	return float32(m.Ascent) / 64, float32(m.Descent) / 64
+}
+
+// ellipsize cuts the line until it fits in t.Width with an ellipsis added to the end,
+// if force is set the ellipsis is added even if the line already fits
+func ellipsize(t *MetaData, line string, force bool) string {
+	if !force && MeasureText(t, line) <= t.Width {
+		return line
+	}
+	runes := []rune(strings.TrimRight(line, " "))
+	for len(runes) > 0 && MeasureText(t, string(runes)+"…") > t.Width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// GetMetaData returns the text metrics of the node without a window (see ConvertToPixels)
 func GetMetaData(n *Node, style map[string]string, state *map[string]State, font *truetype.Font) *MetaData {
+	return getMetaData(unitContext{rootEM: 16}, n, style, state, font)
+}
+
+func getMetaData(units unitContext, n *Node, style map[string]string, state *map[string]State, font *truetype.Font) *MetaData {
 	s := *state
 	self := s[n.Properties.Id]
 	parent := s[n.parent.Properties.Id]
@@ -229,10 +317,15 @@ func GetMetaData(n *Node, style map[string]string, state *map[string]State, font
 	text := MetaData{}
 	text.Font = font
 	text.FontFamily = style["font-family"]
-	letterSpacing := ConvertToPixels(style["letter-spacing"], self.EM, parent.Width)
-	wordSpacing := ConvertToPixels(style["word-spacing"], self.EM, parent.Width)
-	lineHeight := ConvertToPixels(style["line-height"], self.EM, parent.Width)
-	underlineoffset := ConvertToPixels(style["text-underline-offset"], self.EM, parent.Width)
+	letterSpacing := units.px(style["letter-spacing"], self.EM, parent.Width)
+	wordSpacing := units.px(style["word-spacing"], self.EM, parent.Width)
+	lineHeight := units.px(style["line-height"], self.EM, parent.Width)
+	underlineoffset := units.px(style["text-underline-offset"], self.EM, parent.Width)
+
+	// Unitless line heights are a multiple of the font size
+	if lh, err := strconv.ParseFloat(style["line-height"], 32); err == nil {
+		lineHeight = float32(lh) * self.EM
+	}
 
 	if lineHeight == 0 {
 		lineHeight = self.EM + 3
@@ -256,21 +349,30 @@ func GetMetaData(n *Node, style map[string]string, state *map[string]State, font
 	if style["text-decoration-thickness"] == "auto" || style["text-decoration-thickness"] == "" {
 		dt = self.EM / 7
 	} else {
-		dt = ConvertToPixels(style["text-decoration-thickness"], self.EM, parent.Width)
+		dt = units.px(style["text-decoration-thickness"], self.EM, parent.Width)
 	}
 
-	col, err := cc.ParseRGBA(style["color"])
+	current := currentColor(n)
+	col, err := cc.ParseRGBA(current)
+	// -webkit-text-fill-color paints the glyphs without changing the color decorations use
+	if fill := style["-webkit-text-fill-color"]; fill != "" {
+		if fc, ferr := cc.ParseRGBA(cc.ResolveCurrentColor(fill, current)); ferr == nil {
+			col, err = fc, nil
+		}
+	}
 
 	if err != nil {
 		col = color.RGBA{0, 0, 0, 255}
 	}
 
-	if style["text-decoration-color"] == "" {
-		style["text-decoration-color"] = style["color"]
+	// Decorations are currentcolor unless they have a color
+	decorationColor := current
+	if v := style["text-decoration-color"]; v != "" {
+		decorationColor = cc.ResolveCurrentColor(v, current)
 	}
 
 	text.Color = col
-	text.DecorationColor, _ = cc.ParseRGBA(style["text-decoration-color"])
+	text.DecorationColor, _ = cc.ParseRGBA(decorationColor)
 	text.Align = style["text-align"]
 	text.WordBreak = wb
 	text.WordSpacing = int(wordSpacing)
@@ -282,9 +384,22 @@ func GetMetaData(n *Node, style map[string]string, state *map[string]State, font
 	text.LineThrough = style["text-decoration"] == "line-through"
 	text.EM = int(self.EM)
 	text.Width = int(parent.Width)
+	// Elements with a size of their own lay the text out inside of their content box
+	if contentWidth := self.Width - self.Padding.Left - self.Padding.Right; contentWidth > 0 {
+		text.Width = int(contentWidth)
+	}
 	text.Text = n.innerText
 	text.UnderlineOffset = int(underlineoffset)
 
+	text.TabSize = 8
+	if tabSize := style["tab-size"]; tabSize != "" {
+		if ts, err := strconv.Atoi(tabSize); err == nil {
+			text.TabSize = ts
+		} else if space := MeasureSpace(&text); space > 0 {
+			text.TabSize = int(units.px(tabSize, self.EM, parent.Width)) / space
+		}
+	}
+
 	if style["text-underline-offset"] == "" {
 		text.UnderlineOffset = 2
 	}
@@ -296,6 +411,8 @@ func GetMetaData(n *Node, style map[string]string, state *map[string]State, font
 	return &text
 }
 
+// RenderFont draws a single line of text, the baseline is placed at the half leading plus the ascent
+// + of the font so the texture lines up with the line box it was laid out in
 func RenderFont(text *MetaData) (image.Image, int) {
 	if text.LineHeight == 0 {
 		text.LineHeight = text.EM + 3
@@ -309,15 +426,34 @@ func RenderFont(text *MetaData) (image.Image, int) {
 
 	// Create a new font face with the specified size
 	font := truetype.NewFace(text.Font, &options)
-
-	width := MeasureText(text, text.Text+" ")
+	metrics := font.Metrics()
+	ascent, descent := float64(metrics.Ascent)/64, float64(metrics.Descent)/64
+	baseline := (float64(text.LineHeight)-(ascent+descent))/2 + ascent
+
+	words := strings.SplitAfter(text.Text, " ")
+	width := MeasureText(text, text.Text) + int(text.WordGap*float32(strings.Count(strings.TrimRight(text.Text, " "), " ")))
+	if width == 0 {
+		width = 1
+	}
 
 	ctx := canvas.NewCanvas(width, text.LineHeight)
 	r, g, b, a := text.Color.RGBA()
 
 	ctx.SetFillStyle(uint8(r), uint8(g), uint8(b), uint8(a))
 	ctx.Context.SetFontFace(font)
-	ctx.Context.DrawStringAnchored(text.Text, 0, float64(text.LineHeight)/2, 0, 0.3)
+	if text.WordGap > 0 {
+		// Justified text spreads the extra space between the words
+		var x float64
+		for _, word := range words {
+			ctx.Context.DrawStringAnchored(word, x, baseline, 0, 0)
+			x += float64(MeasureText(text, word))
+			if strings.HasSuffix(word, " ") {
+				x += float64(text.WordGap)
+			}
+		}
+	} else {
+		ctx.Context.DrawStringAnchored(text.Text, 0, baseline, 0, 0)
+	}
 	font.Close()
 	if text.Underlined || text.Overlined || text.LineThrough {
 		ctx.SetLineWidth(float64(text.DecorationThickness))
@@ -326,13 +462,13 @@ func RenderFont(text *MetaData) (image.Image, int) {
 		ctx.BeginPath()
 		var y float64
 		if text.Underlined {
-			y = (float64(text.LineHeight) / 2) + (float64(text.EM) / 2.5) + float64(text.UnderlineOffset)
+			y = baseline + float64(text.UnderlineOffset)
 		}
 		if text.LineThrough {
-			y = (float64(text.LineHeight) / 2)
+			y = baseline - ascent*0.3
 		}
 		if text.Overlined {
-			y = (float64(text.LineHeight) / 2) - (float64(text.EM) / 2) - (float64(text.DecorationThickness) / 2)
+			y = baseline - ascent - (float64(text.DecorationThickness) / 2)
 		}
 		ctx.MoveTo(0, y)
 		ctx.LineTo(float64(width), y)
@@ -0,0 +1,216 @@
+package grim
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// !DEVMAN: The layout of the nodes is in CSS.State which scripts can't get to, addScroll copies the State of each
+// + node into it after the render so the geometry can be read from the node. The rects are the border box in window
+// + pixels after the scroll and the transforms, a node that wasn't rendered (display: none) has a empty rect.
+// + ElementFromPoint hit tests like the events do but skips the hidden parts of the elements (Hidden and Crop)
+
+// DOMRect is a rectangle in window pixels, Top, Right, Bottom and Left are the edges
+type DOMRect struct {
+	X      float32
+	Y      float32
+	Width  float32
+	Height float32
+	Top    float32
+	Right  float32
+	Bottom float32
+	Left   float32
+}
+
+func newRect(x, y, width, height float32) DOMRect {
+	return DOMRect{
+		X:      x,
+		Y:      y,
+		Width:  width,
+		Height: height,
+		Top:    y,
+		Right:  x + width,
+		Bottom: y + height,
+		Left:   x,
+	}
+}
+
+// transformedRect returns the smallest rect that holds the box after the transform of the state
+func transformedRect(self State, x, y, width, height float32) DOMRect {
+	if self.Transform == nil {
+		return newRect(x, y, width, height)
+	}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]float32{{x, y}, {x + width, y}, {x, y + height}, {x + width, y + height}} {
+		tx, ty := self.Transform.TransformPoint(float64(p[0]), float64(p[1]))
+		minX, minY = math.Min(minX, tx), math.Min(minY, ty)
+		maxX, maxY = math.Max(maxX, tx), math.Max(maxY, ty)
+	}
+	return newRect(float32(minX), float32(minY), float32(maxX-minX), float32(maxY-minY))
+}
+
+// rendered reports if the node was in the last render
+func (n *Node) rendered() bool {
+	return n.layout.Width+n.layout.Height+n.layout.Border.Left.Width+n.layout.Border.Top.Width > 0
+}
+
+// GetBoundingClientRect returns the border box of the node
+func (n *Node) GetBoundingClientRect() DOMRect {
+	if !n.rendered() {
+		return DOMRect{}
+	}
+	self := n.layout
+	return transformedRect(self, self.X, self.Y, n.offsetWidth(), n.offsetHeight())
+}
+
+// GetClientRects returns a rect for each line a inline node was placed on and the border box for the others
+func (n *Node) GetClientRects() []DOMRect {
+	if !n.rendered() {
+		return []DOMRect{}
+	}
+	self := n.layout
+	if len(self.Fragments) == 0 {
+		return []DOMRect{n.GetBoundingClientRect()}
+	}
+	rects := make([]DOMRect, 0, len(self.Fragments))
+	for _, f := range self.Fragments {
+		rects = append(rects, transformedRect(self, self.X+f.X, self.Y+f.Y, f.Width, f.Height))
+	}
+	return rects
+}
+
+func (n *Node) offsetWidth() float32 {
+	return n.layout.Width + n.layout.Border.Left.Width + n.layout.Border.Right.Width
+}
+
+func (n *Node) offsetHeight() float32 {
+	return n.layout.Height + n.layout.Border.Top.Width + n.layout.Border.Bottom.Width
+}
+
+// OffsetParent returns the closest positioned element the node is in, or the body
+func (n *Node) OffsetParent() *Node {
+	if n.ComputedStyle["position"] == "fixed" {
+		return nil
+	}
+	for v := n.parent; v != nil; v = v.parent {
+		p := v.ComputedStyle["position"]
+		if (p != "" && p != "static") || v.tagName == "body" || v.tagName == "td" || v.tagName == "th" || v.tagName == "table" {
+			return v
+		}
+	}
+	return nil
+}
+
+// OffsetLeft returns the distance from the left of the padding box of the offset parent to the left of the node,
+// transforms are ignored like in browsers
+func (n *Node) OffsetLeft() int {
+	if p := n.OffsetParent(); p != nil {
+		return int(n.layout.X - p.layout.X - p.layout.Border.Left.Width)
+	}
+	return int(n.layout.X)
+}
+
+// OffsetTop returns the distance from the top of the padding box of the offset parent to the top of the node
+func (n *Node) OffsetTop() int {
+	if p := n.OffsetParent(); p != nil {
+		return int(n.layout.Y - p.layout.Y - p.layout.Border.Top.Width)
+	}
+	return int(n.layout.Y)
+}
+
+// OffsetWidth returns the width of the border box
+func (n *Node) OffsetWidth() int {
+	return int(n.offsetWidth())
+}
+
+// OffsetHeight returns the height of the border box
+func (n *Node) OffsetHeight() int {
+	return int(n.offsetHeight())
+}
+
+// ClientLeft returns the width of the left border
+func (n *Node) ClientLeft() int {
+	return int(n.layout.Border.Left.Width)
+}
+
+// ClientTop returns the width of the top border
+func (n *Node) ClientTop() int {
+	return int(n.layout.Border.Top.Width)
+}
+
+// ClientWidth returns the width of the padding box
+func (n *Node) ClientWidth() int {
+	return int(n.layout.Width)
+}
+
+// ClientHeight returns the height of the padding box
+func (n *Node) ClientHeight() int {
+	return int(n.layout.Height)
+}
+
+// ScrollWidth returns the width of the content of the node including the part scrolled out of view
+func (n *Node) ScrollWidth() int {
+	return n.scrollWidth + int(n.layout.Width)
+}
+
+// ScrollHeight returns the height of the content of the node including the part scrolled out of view
+func (n *Node) ScrollHeight() int {
+	return n.scrollHeight + int(n.layout.Height)
+}
+
+// visibleAt reports if x, y is on a part of the element that is shown
+func visibleAt(self State, x, y float32) bool {
+	if self.Hidden || !insideBox(self, x, y) {
+		return false
+	}
+	// The crop plugin sets Crop to the part of the element inside of its scroll container
+	c := self.Crop
+	if c.Width == 0 && c.Height == 0 && c.X == 0 && c.Y == 0 {
+		return true
+	}
+	if self.Transform != nil {
+		inverse, ok := self.Transform.Invert()
+		if !ok {
+			return false
+		}
+		tx, ty := inverse.TransformPoint(float64(x), float64(y))
+		x, y = float32(tx), float32(ty)
+	}
+	left, top := self.X+float32(c.X), self.Y+float32(c.Y)
+	return x >= left && x < left+float32(c.Width) && y >= top && y < top+float32(c.Height)
+}
+
+// ElementFromPoint returns the element on top at x, y or nil if there is none
+func (w *Window) ElementFromPoint(x, y float32) *Node {
+	if hits := w.ElementsFromPoint(x, y); len(hits) > 0 {
+		return hits[0]
+	}
+	return nil
+}
+
+// ElementsFromPoint returns the elements at x, y from the one on top to the one at the bottom
+func (w *Window) ElementsFromPoint(x, y float32) []*Node {
+	hits := []*Node{}
+	if len(w.document.Children) == 0 {
+		return hits
+	}
+	nodes := flatten(w.document.Children[0])
+	// Later elements are drawn over the ones before with the same Z
+	for i := len(nodes) - 1; i >= 0; i-- {
+		v := nodes[i]
+		self, ok := w.CSS.State[v.Properties.Id]
+		// The scrollbars are part of the element they scroll
+		if !ok || strings.HasPrefix(v.tagName, "grim-") {
+			continue
+		}
+		if visibleAt(self, x, y) {
+			hits = append(hits, v)
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return w.CSS.State[hits[i].Properties.Id].Z > w.CSS.State[hits[j].Properties.Id].Z
+	})
+	return hits
+}
@@ -15,4 +15,5 @@ require (
 require (
 	github.com/gen2brain/raylib-go/raylib v0.0.0-20231123174446-48309e2407b7
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
+	golang.org/x/text v0.20.0
 )
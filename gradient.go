@@ -0,0 +1,399 @@
+package grim
+
+import (
+	"grim/color"
+	"image"
+	ic "image/color"
+	"math"
+	"strings"
+)
+
+// !DEVMAN: Gradients are drawn a pixel at a time, each pixel gets its position t along the gradient
+// + (0 at the start and 1 at the end of the gradient line, ray or turn) and colorAt finds the color for
+// + it from the color stops. Repeating gradients wrap t between the first and last stop
+
+type gradient struct {
+	// linear, radial or conic
+	kind      string
+	repeating bool
+	// The direction of a linear gradient or the start of a conic gradient in degrees, clockwise from the top
+	angle float64
+	// Center of radial and conic gradients
+	x float64
+	y float64
+	// Radii of radial gradients
+	rx    float64
+	ry    float64
+	stops []step
+}
+
+type step struct {
+	color  ic.RGBA
+	offset float64
+	// hint is the color hint between the previous stop and this one
+	hint    float64
+	hasHint bool
+}
+
+// isGradient reports if a background-image is one of the gradient functions
+func isGradient(image string) bool {
+	open := strings.IndexByte(image, '(')
+	if open < 0 {
+		return false
+	}
+	switch strings.TrimPrefix(image[:open], "repeating-") {
+	case "linear-gradient", "radial-gradient", "conic-gradient":
+		return true
+	}
+	return false
+}
+
+// parseGradient parses a gradient function for a image of width x height
+func parseGradient(units unitContext, value string, width, height float64, em float32) (gradient, bool) {
+	value = strings.TrimSpace(value)
+	open := strings.IndexByte(value, '(')
+	if open < 0 || !strings.HasSuffix(value, ")") {
+		return gradient{}, false
+	}
+	name := value[:open]
+	g := gradient{
+		repeating: strings.HasPrefix(name, "repeating-"),
+		kind:      strings.TrimSuffix(strings.TrimPrefix(name, "repeating-"), "-gradient"),
+		angle:     180,
+		x:         width / 2,
+		y:         height / 2,
+	}
+
+	args := Token('(', ')', ',', value[open+1:len(value)-1])
+	if len(args) == 0 {
+		return g, false
+	}
+
+	// The first argument is the shape of the gradient unless it starts with a color
+	first := Token('(', ')', ' ', args[0])
+	if len(first) > 0 && !isColorValue(first[0]) {
+		args = args[1:]
+	} else {
+		first = nil
+	}
+
+	// length is what percentages of the color stops are relative to
+	var length float64
+	switch g.kind {
+	case "linear":
+		g.angle = linearAngle(first, width, height)
+		rad := g.angle * math.Pi / 180
+		length = math.Abs(width*math.Sin(rad)) + math.Abs(height*math.Cos(rad))
+	case "radial":
+		g.radialShape(units, first, width, height, em)
+		length = g.rx
+	case "conic":
+		g.angle = 0
+		for i := 0; i < len(first); i++ {
+			if first[i] == "from" && i+1 < len(first) {
+				g.angle = float64(parseAngle(first[i+1]))
+				i++
+			} else if first[i] == "at" {
+				g.x, g.y = gradientPosition(units, first[i+1:], width, height, em)
+				break
+			}
+		}
+	default:
+		return g, false
+	}
+
+	g.stops = parseColorStops(units, args, g.kind, length, em)
+	return g, len(g.stops) > 0
+}
+
+// linearAngle returns the direction of a linear gradient from its angle or side/corner ("to top right")
+func linearAngle(first []string, width, height float64) float64 {
+	if len(first) == 0 {
+		return 180
+	}
+	if first[0] != "to" {
+		return float64(parseAngle(first[0]))
+	}
+	var vertical, horizontal string
+	for _, v := range first[1:] {
+		switch v {
+		case "top", "bottom":
+			vertical = v
+		case "left", "right":
+			horizontal = v
+		}
+	}
+	if vertical == "" || horizontal == "" {
+		switch vertical + horizontal {
+		case "top":
+			return 0
+		case "right":
+			return 90
+		case "left":
+			return 270
+		}
+		return 180
+	}
+	// Corners point so the middle of the gradient goes through the other two corners
+	corner := math.Atan2(height, width) * 180 / math.Pi
+	switch vertical + horizontal {
+	case "topright":
+		return corner
+	case "bottomright":
+		return 180 - corner
+	case "bottomleft":
+		return 180 + corner
+	}
+	return 360 - corner
+}
+
+// radialShape sets the center and radii of a radial gradient from "circle 20px at top left" like values
+func (g *gradient) radialShape(units unitContext, first []string, width, height float64, em float32) {
+	shape := ""
+	extent := "farthest-corner"
+	sizes := []string{}
+	for i := 0; i < len(first); i++ {
+		v := first[i]
+		switch v {
+		case "circle", "ellipse":
+			shape = v
+		case "closest-side", "closest-corner", "farthest-side", "farthest-corner":
+			extent = v
+		case "at":
+			g.x, g.y = gradientPosition(units, first[i+1:], width, height, em)
+			i = len(first)
+		default:
+			sizes = append(sizes, v)
+		}
+	}
+	// A single length is a circle, two are a ellipse
+	if shape == "" {
+		shape = "ellipse"
+		if len(sizes) == 1 {
+			shape = "circle"
+		}
+	}
+
+	if len(sizes) > 0 {
+		g.rx = float64(units.px(sizes[0], em, float32(width)))
+		g.ry = g.rx
+		if shape == "ellipse" && len(sizes) > 1 {
+			g.ry = float64(units.px(sizes[1], em, float32(height)))
+		}
+	} else if shape == "circle" {
+		g.rx = getSize(units, extent, width, height, em, g.x, g.y)
+		g.ry = g.rx
+	} else {
+		// Ellipses touch the sides, the corner sizes keep the ratio of the side sizes
+		side := strings.Replace(strings.Replace(extent, "corner", "side", 1), "-side", "", 1)
+		dx := []float64{g.x, math.Abs(width - g.x)}
+		dy := []float64{g.y, math.Abs(height - g.y)}
+		if side == "closest" {
+			g.rx, g.ry = math.Min(dx[0], dx[1]), math.Min(dy[0], dy[1])
+		} else {
+			g.rx, g.ry = math.Max(dx[0], dx[1]), math.Max(dy[0], dy[1])
+		}
+		if strings.HasSuffix(extent, "corner") {
+			g.rx *= math.Sqrt2
+			g.ry *= math.Sqrt2
+		}
+	}
+	g.rx = math.Max(g.rx, 0.0001)
+	g.ry = math.Max(g.ry, 0.0001)
+}
+
+// gradientPosition parses the position after "at", a single value is centered on the other axis
+func gradientPosition(units unitContext, parts []string, width, height float64, em float32) (float64, float64) {
+	w, h := int(width), int(height)
+	switch len(parts) {
+	case 0:
+		return width / 2, height / 2
+	case 1:
+		if parts[0] == "top" || parts[0] == "bottom" {
+			return width / 2, getPosition(units, parts[0], w, h, em, "y")
+		}
+		return getPosition(units, parts[0], w, h, em, "x"), height / 2
+	}
+	x, y := parts[0], parts[1]
+	if x == "top" || x == "bottom" || y == "left" || y == "right" {
+		x, y = y, x
+	}
+	return getPosition(units, x, w, h, em, "x"), getPosition(units, y, w, h, em, "y")
+}
+
+// parseColorStops parses the color stops and hints, stops can have two positions ("red 10% 20%")
+// and hints are a position on their own. Positions are fractions of the length of the gradient
+func parseColorStops(units unitContext, args []string, kind string, length float64, em float32) []step {
+	position := func(v string) float64 {
+		if kind == "conic" {
+			if strings.HasSuffix(v, "%") {
+				return float64(parseAmount(v, 0))
+			}
+			return float64(parseAngle(v)) / 360
+		}
+		if length == 0 {
+			return 0
+		}
+		return float64(units.px(v, em, float32(length))) / length
+	}
+
+	steps := []step{}
+	set := []bool{}
+	hint, hasHint := 0.0, false
+	for _, arg := range args {
+		parts := Token('(', ')', ' ', arg)
+		if len(parts) == 0 {
+			continue
+		}
+		c, err := color.ParseRGBA(parts[0])
+		if err != nil {
+			if len(parts) == 1 && len(steps) > 0 {
+				hint, hasHint = position(parts[0]), true
+			}
+			continue
+		}
+		s := step{color: c, hint: hint, hasHint: hasHint}
+		hasHint = false
+		if len(parts) == 1 {
+			steps = append(steps, s)
+			set = append(set, false)
+			continue
+		}
+		for i, p := range parts[1:] {
+			if i > 0 {
+				s.hasHint = false
+			}
+			s.offset = position(p)
+			steps = append(steps, s)
+			set = append(set, true)
+		}
+	}
+	if len(steps) == 0 {
+		return steps
+	}
+
+	// The first and last stop default to the start and end, a stop can't be before the one before it
+	if !set[0] {
+		steps[0].offset, set[0] = 0, true
+	}
+	if last := len(steps) - 1; !set[last] {
+		steps[last].offset, set[last] = 1, true
+	}
+	max := steps[0].offset
+	for i := range steps {
+		if set[i] {
+			max = math.Max(max, steps[i].offset)
+			steps[i].offset = max
+		}
+	}
+	// Stops without a position are spread out evenly between the ones that have one
+	for i := 1; i < len(steps); i++ {
+		if set[i] {
+			continue
+		}
+		end := i
+		for !set[end] {
+			end++
+		}
+		start := steps[i-1].offset
+		gap := (steps[end].offset - start) / float64(end-i+1)
+		for j := i; j < end; j++ {
+			steps[j].offset = start + gap*float64(j-i+1)
+			set[j] = true
+		}
+	}
+	for i := 1; i < len(steps); i++ {
+		if steps[i].hasHint {
+			steps[i].hint = math.Max(steps[i-1].offset, math.Min(steps[i].offset, steps[i].hint))
+		}
+	}
+	return steps
+}
+
+// colorAt returns the premultiplied color at t
+func (g gradient) colorAt(t float64) [4]float64 {
+	stops := g.stops
+	first, last := stops[0].offset, stops[len(stops)-1].offset
+	if g.repeating && last > first {
+		t = first + math.Mod(t-first, last-first)
+		if t < first {
+			t += last - first
+		}
+	}
+
+	premultiply := func(c ic.RGBA) [4]float64 {
+		a := float64(c.A) / 255
+		return [4]float64{float64(c.R) / 255 * a, float64(c.G) / 255 * a, float64(c.B) / 255 * a, a}
+	}
+	if t <= first {
+		return premultiply(stops[0].color)
+	}
+	for i := 1; i < len(stops); i++ {
+		a, b := stops[i-1], stops[i]
+		if t > b.offset {
+			continue
+		}
+		if b.offset == a.offset {
+			return premultiply(b.color)
+		}
+		p := (t - a.offset) / (b.offset - a.offset)
+		if b.hasHint {
+			// The hint is where the colors are mixed half and half
+			h := (b.hint - a.offset) / (b.offset - a.offset)
+			switch {
+			case h <= 0:
+				p = 1
+			case h >= 1:
+				p = 0
+			default:
+				p = math.Pow(p, math.Log(0.5)/math.Log(h))
+			}
+		}
+		ca, cb := premultiply(a.color), premultiply(b.color)
+		return [4]float64{
+			ca[0] + (cb[0]-ca[0])*p,
+			ca[1] + (cb[1]-ca[1])*p,
+			ca[2] + (cb[2]-ca[2])*p,
+			ca[3] + (cb[3]-ca[3])*p,
+		}
+	}
+	return premultiply(stops[len(stops)-1].color)
+}
+
+// render draws the gradient into a image of width x height
+func (g gradient) render(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rad := g.angle * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	length := math.Abs(float64(width)*sin) + math.Abs(float64(height)*cos)
+	cx, cy := float64(width)/2, float64(height)/2
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			var t float64
+			switch g.kind {
+			case "linear":
+				if length > 0 {
+					t = ((px-cx)*sin-(py-cy)*cos)/length + 0.5
+				}
+			case "radial":
+				dx, dy := (px-g.x)/g.rx, (py-g.y)/g.ry
+				t = math.Sqrt(dx*dx + dy*dy)
+			case "conic":
+				// Angle clockwise from the top
+				a := math.Atan2(px-g.x, g.y-py)*180/math.Pi - g.angle
+				t = math.Mod(a, 360) / 360
+				if t < 0 {
+					t++
+				}
+			}
+			c := g.colorAt(t)
+			i := img.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				img.Pix[i+k] = uint8(math.Max(0, math.Min(255, c[k]*255+0.5)))
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,969 @@
+package grim
+
+import (
+	"image"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// !DEVMAN: The inline formatting context lays out the text and inline elements of a block into line boxes.
+// + Each text node gets a Fragment for every line it lands on, so a paragraph renders one texture per line
+// + instead of one node per word. Inline boxes (span, a, b...) are sized to the union of their fragments
+// + and atomic inlines (inline-block, img, input...) are moved into place with their subtrees
+
+var inlineDisplays = map[string]bool{
+	"inline":       true,
+	"inline-block": true,
+	"inline-flex":  true,
+	"inline-grid":  true,
+	"inline-table": true,
+}
+
+// Children of these containers are blockified, so they don't take part in a inline formatting context
+var itemDisplays = map[string]bool{
+	"flex":        true,
+	"inline-flex": true,
+	"grid":        true,
+	"inline-grid": true,
+}
+
+// Replaced elements are laid out as a single box even if they are display: inline
+var atomicTags = map[string]bool{
+	"img":      true,
+	"canvas":   true,
+	"input":    true,
+	"button":   true,
+	"select":   true,
+	"textarea": true,
+	"video":    true,
+	"svg":      true,
+}
+
+type inlineItem struct {
+	node     *Node
+	meta     *MetaData
+	text     string
+	width    float32
+	ascent   float32
+	descent  float32
+	shift    float32 // baseline shift from sub, super and lengths, positive raises the item
+	align    string  // vertical-align keywords that depend on the line box
+	trailing float32 // width of the collapsible space at the end of the text
+	gap      float32 // extra space added to each space by text-align: justify
+	atomic   bool
+	edge     bool  // margin, border and padding at the start or end of a inline box
+	forced   bool  // <br> or a preserved newline
+	float    *Node // a float in the middle of the text, it takes no space on the line
+	wrap     bool  // a soft wrap opportunity follows the item
+	hidden   bool
+	boxes    []*Node // inline boxes the item is inside of
+	x, y     float32
+}
+
+type lineBox struct {
+	items  []*inlineItem
+	forced bool
+	top    float32
+}
+
+type inlineContext struct {
+	c         *CSS
+	root      *Node
+	em        float32
+	ascent    float32 // font metrics of the root
+	descent   float32
+	strut     [2]float32 // ascent and descent of the roots line height
+	rtl       bool
+	lastSpace bool
+	floats    []floatBox // floats the lines flow around
+}
+
+func isInFlow(n *Node) bool {
+	if isPositioned(n.ComputedStyle) || isFloated(n) {
+		return false
+	}
+	return n.ComputedStyle["display"] != "none" && !nonRenderTags[n.tagName] && n.tagName != "grim-track"
+}
+
+// isInlineLevel reports if the node takes part in the inline formatting context of its parent
+func isInlineLevel(n *Node) bool {
+	if !inlineDisplays[n.ComputedStyle["display"]] || !isInFlow(n) {
+		return false
+	}
+	if n.parent != nil && itemDisplays[n.parent.ComputedStyle["display"]] {
+		return false
+	}
+	return true
+}
+
+func hasOwnText(n *Node) bool {
+	if len(n.innerText) == 0 || ChildrenHaveText(n) {
+		return false
+	}
+	return strings.TrimSpace(n.innerText) != "" || PreservesWhiteSpace(n.ComputedStyle["white-space"])
+}
+
+// establishesInlineContext reports if the node lays out its content in line boxes
+func establishesInlineContext(n *Node) bool {
+	display := n.ComputedStyle["display"]
+	if display == "none" || nonRenderTags[n.tagName] || itemDisplays[display] {
+		return false
+	}
+	if display == "inline" && isInlineLevel(n) && !atomicTags[n.tagName] {
+		return false
+	}
+	if hasOwnText(n) {
+		return true
+	}
+	for _, v := range n.Children {
+		if isInlineLevel(v) || v.tagName == "br" {
+			return true
+		}
+	}
+	return false
+}
+
+// LayoutInline lays out the inline content of n into line boxes again, plugins call it after
+// they change the width of n. The floats outside of n are ignored, the flex items and table cells
+// the plugins lay out are block formatting contexts
+func (c *CSS) LayoutInline(n *Node) {
+	if establishesInlineContext(n) {
+		c.layoutInline(n, false, nil)
+		if establishesBFC(n) {
+			c.containFloats(n, c.innerFloats(n))
+		}
+	}
+}
+
+// ContentWidths returns the min-content and max-content width of the content of n, the widest piece that
+// can't be wrapped and the width of the content without soft wraps. Plugins use it to size boxes by their content
+func (c *CSS) ContentWidths(n *Node) (float32, float32) {
+	self := c.State[n.Properties.Id]
+	ic := &inlineContext{c: c, root: n, em: self.EM, lastSpace: true}
+	var minWidth, maxWidth, line, word float32
+	end := func() {
+		maxWidth, minWidth = Max(maxWidth, line), Max(minWidth, word)
+		line, word = 0, 0
+	}
+	measure := func(items []*inlineItem) {
+		for _, item := range items {
+			if item.forced {
+				end()
+				continue
+			}
+			line += item.width
+			word += item.width - item.trailing
+			if item.wrap && !item.edge {
+				minWidth = Max(minWidth, word)
+				word = 0
+			}
+		}
+	}
+
+	children := n.Children
+	if hasOwnText(n) {
+		measure(ic.textItems(n, nil, 0, ""))
+		children = nil
+	}
+	for _, v := range children {
+		if isInlineLevel(v) || v.tagName == "br" {
+			measure(ic.collect(v, nil, 0, ""))
+		} else if isInFlow(v) || isFloated(v) {
+			// Blocks are on lines of their own, boxes with a width keep it
+			end()
+			vState := c.State[v.Properties.Id]
+			extra := vState.Border.Left.Width + vState.Border.Right.Width + vState.Margin.Left + vState.Margin.Right
+			if v.ComputedStyle["width"] != "" {
+				word, line = vState.Width+extra, vState.Width+extra
+			} else {
+				vMin, vMax := c.ContentWidths(v)
+				extra += vState.Padding.Left + vState.Padding.Right
+				word, line = vMin+extra, vMax+extra
+			}
+			end()
+		}
+	}
+	end()
+	return minWidth, maxWidth
+}
+
+// shrinksToFit reports if the width of the node comes from its content
+func shrinksToFit(n *Node) bool {
+	return n.ComputedStyle["width"] == "" && (inlineDisplays[n.ComputedStyle["display"]] || isFloated(n))
+}
+
+// layoutInline lays out the inline content of n into line boxes, moves the block level children
+// in between them and renders the text fragments. floats are the floats before n in its block
+// formatting context
+func (c *CSS) layoutInline(n *Node, shrink bool, floats []floatBox) {
+	self := c.State[n.Properties.Id]
+	style := n.ComputedStyle
+
+	left := self.X + self.Border.Left.Width + self.Padding.Left
+	top := self.Y + self.Border.Top.Width + self.Padding.Top
+	avail := self.Width - self.Padding.Left - self.Padding.Right
+
+	// Boxes that shrink to fit their content get laid out in the space of their container first
+	if shrink {
+		avail = containingWidth(n, c.State) - self.Margin.Left - self.Margin.Right - self.Border.Left.Width - self.Border.Right.Width - self.Padding.Left - self.Padding.Right
+	}
+	if avail < 0 {
+		avail = 0
+	}
+
+	ic := &inlineContext{c: c, root: n, em: self.EM, rtl: style["direction"] == "rtl", lastSpace: true, floats: floats}
+	rootMeta := ic.metaData(n)
+	ic.ascent, ic.descent = FontMetrics(rootMeta)
+	half := (float32(rootMeta.LineHeight) - (ic.ascent + ic.descent)) / 2
+	ic.strut = [2]float32{ic.ascent + half, ic.descent + half}
+
+	lines := []*lineBox{}
+	cursorY := top
+	var maxWidth float32
+	items := []*inlineItem{}
+
+	flush := func() {
+		if len(items) == 0 {
+			return
+		}
+		// Lines are expected to be as high as the strut until they are placed
+		lineHeight := ic.strut[0] + ic.strut[1]
+		start := cursorY
+		tops := func(i int) float32 {
+			return start + float32(i)*lineHeight
+		}
+		widths := func(i int) float32 {
+			l, r := floatSpace(tops(i), lineHeight, left, left+avail, ic.floats)
+			return r - l
+		}
+		for _, line := range ic.layoutLines(items, widths, tops) {
+			line.top = cursorY
+			cursorY += ic.placeLine(line)
+			lines = append(lines, line)
+			if w := lineWidth(line); w > maxWidth {
+				maxWidth = w
+			}
+		}
+		items = []*inlineItem{}
+		ic.lastSpace = true
+	}
+
+	if hasOwnText(n) {
+		items = append(items, ic.textItems(n, nil, 0, "")...)
+	} else {
+		for _, v := range n.Children {
+			if isInlineLevel(v) || v.tagName == "br" {
+				items = append(items, ic.collect(v, nil, 0, "")...)
+			} else if isFloated(v) {
+				items = append(items, &inlineItem{float: v})
+			} else if isInFlow(v) {
+				flush()
+				// Block level children start on a new line under the previous line boxes
+				vState := c.State[v.Properties.Id]
+				if clear := v.ComputedStyle["clear"]; clear != "" && clear != "none" {
+					cursorY = Max(cursorY, clearance(clear, ic.floats))
+				}
+				y := cursorY + vState.Margin.Top
+				shiftNode(v, c.State, 0, y-vState.Y)
+				cursorY = y + vState.Height + vState.Border.Top.Width + vState.Border.Bottom.Width + vState.Margin.Bottom
+				if w := vState.Width + vState.Margin.Left + vState.Margin.Right + vState.Border.Left.Width + vState.Border.Right.Width; w > maxWidth {
+					maxWidth = w
+				}
+			}
+		}
+	}
+	flush()
+
+	if shrink && maxWidth < avail {
+		avail = maxWidth
+	}
+
+	align := style["text-align"]
+	for i, line := range lines {
+		last := i == len(lines)-1 || lines[i+1].top != line.top+ic.lineHeight(line)
+		l, r := floatSpace(line.top, ic.lineHeight(line), left, left+avail, ic.floats)
+		ic.alignLine(line, r-l, align, last)
+		for _, item := range line.items {
+			item.x += l - left
+		}
+	}
+
+	ic.place(lines, left)
+
+	self = c.State[n.Properties.Id]
+	if shrink {
+		self.Width = avail + self.Padding.Left + self.Padding.Right
+	}
+	if style["height"] == "" {
+		self.Height = cursorY - self.Y - self.Border.Top.Width + self.Padding.Bottom
+		if minHeight := style["min-height"]; minHeight != "" {
+			self.Height = Max(self.Height, c.unitsOf(style).px(minHeight, self.EM, c.State[n.parent.Properties.Id].Height))
+		}
+	}
+	if sh := int(cursorY-self.Y) + int(self.Padding.Bottom); sh > self.ScrollHeight {
+		self.ScrollHeight = sh
+	}
+	if sw := int(maxWidth + self.Padding.Left + self.Padding.Right); sw > self.ScrollWidth {
+		self.ScrollWidth = sw
+	}
+	c.State[n.Properties.Id] = self
+}
+
+// containingWidth returns the content width of the closest ancestor that has a width
+func containingWidth(n *Node, s map[string]State) float32 {
+	for p := n.parent; p != nil; p = p.parent {
+		pState := s[p.Properties.Id]
+		if w := pState.Width - pState.Padding.Left - pState.Padding.Right; w > 0 {
+			return w
+		}
+	}
+	return 0
+}
+
+// shiftNode moves a node and all of its children
+func shiftNode(n *Node, s map[string]State, dx, dy float32) {
+	if dx == 0 && dy == 0 {
+		return
+	}
+	self := s[n.Properties.Id]
+	self.X += dx
+	self.Y += dy
+	s[n.Properties.Id] = self
+	for _, v := range n.Children {
+		shiftNode(v, s, dx, dy)
+	}
+}
+
+func hideNode(n *Node, s map[string]State) {
+	self := s[n.Properties.Id]
+	self.Hidden = true
+	s[n.Properties.Id] = self
+	for _, v := range n.Children {
+		hideNode(v, s)
+	}
+}
+
+func (ic *inlineContext) metaData(n *Node) *MetaData {
+	self := ic.c.State[n.Properties.Id]
+	fnt := ic.c.getFont(n.ComputedStyle, self.EM)
+	return getMetaData(ic.c.unitsOf(n.ComputedStyle), n, n.ComputedStyle, &ic.c.State, fnt)
+}
+
+// collect turns a inline level node into the items that are placed on the lines
+func (ic *inlineContext) collect(v *Node, boxes []*Node, shift float32, align string) []*inlineItem {
+	if v.tagName == "br" {
+		ic.lastSpace = true
+		return []*inlineItem{{node: v, forced: true, boxes: boxes}}
+	}
+	if !isInFlow(v) {
+		return nil
+	}
+
+	vState := ic.c.State[v.Properties.Id]
+	s, a := ic.verticalAlign(v, vState)
+	shift += s
+	if a != "" {
+		align = a
+	}
+
+	if v.ComputedStyle["display"] != "inline" || atomicTags[v.tagName] {
+		ic.lastSpace = false
+		return []*inlineItem{{
+			node:   v,
+			atomic: true,
+			width:  vState.Width + vState.Border.Left.Width + vState.Border.Right.Width + vState.Margin.Left + vState.Margin.Right,
+			ascent: vState.Height + vState.Border.Top.Width + vState.Border.Bottom.Width + vState.Margin.Top + vState.Margin.Bottom,
+			shift:  shift,
+			align:  align,
+			wrap:   true,
+			boxes:  boxes,
+		}}
+	}
+
+	boxes = append(boxes[:len(boxes):len(boxes)], v)
+	items := []*inlineItem{}
+	if start := vState.Margin.Left + vState.Border.Left.Width + vState.Padding.Left; start > 0 {
+		items = append(items, ic.edgeItem(v, start, shift, boxes))
+	}
+	if hasOwnText(v) {
+		items = append(items, ic.textItems(v, boxes, shift, align)...)
+	} else {
+		for _, child := range v.Children {
+			items = append(items, ic.collect(child, boxes, shift, align)...)
+		}
+	}
+	if end := vState.Margin.Right + vState.Border.Right.Width + vState.Padding.Right; end > 0 {
+		items = append(items, ic.edgeItem(v, end, shift, boxes))
+	}
+	return items
+}
+
+func (ic *inlineContext) edgeItem(v *Node, width, shift float32, boxes []*Node) *inlineItem {
+	return &inlineItem{node: v, edge: true, width: width, ascent: ic.strut[0], descent: ic.strut[1], shift: shift, boxes: boxes}
+}
+
+// textItems splits the text of a node at its soft wrap opportunities
+func (ic *inlineContext) textItems(v *Node, boxes []*Node, shift float32, align string) []*inlineItem {
+	meta := ic.metaData(v)
+	mode := v.ComputedStyle["white-space"]
+	raw := strings.ReplaceAll(v.innerText, "\r\n", "\n")
+
+	var text string
+	if PreservesWhiteSpace(mode) {
+		text = CollapseWhiteSpace(raw, mode)
+		ic.lastSpace = false
+	} else {
+		// Spaces at the edges of the node collapse with the text around it
+		text = CollapseWhiteSpace(raw, mode)
+		runes := []rune(raw)
+		if len(runes) > 0 && unicode.IsSpace(runes[0]) && !ic.lastSpace {
+			text = " " + text
+		}
+		if len(runes) > 0 && unicode.IsSpace(runes[len(runes)-1]) && text != "" && text != " " {
+			text += " "
+		}
+		if text == "" {
+			return nil
+		}
+		ic.lastSpace = strings.HasSuffix(text, " ")
+	}
+	text = ShapeArabic(ExpandTabs(text, meta.TabSize))
+
+	ascent, descent := FontMetrics(meta)
+	half := (float32(meta.LineHeight) - (ascent + descent)) / 2
+	wraps := WhiteSpaceWraps(mode)
+	space := float32(MeasureSpace(meta))
+
+	items := []*inlineItem{}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			items = append(items, &inlineItem{node: v, forced: true, boxes: boxes})
+		}
+		segments := []string{line}
+		if wraps {
+			segments = strings.SplitAfter(line, " ")
+		}
+		for _, seg := range segments {
+			if seg == "" {
+				continue
+			}
+			item := &inlineItem{
+				node:    v,
+				meta:    meta,
+				text:    seg,
+				width:   float32(MeasureText(meta, seg)),
+				ascent:  ascent + half,
+				descent: descent + half,
+				shift:   shift,
+				align:   align,
+				wrap:    wraps && strings.HasSuffix(seg, " "),
+				boxes:   boxes,
+			}
+			if !PreservesWhiteSpace(mode) && strings.HasSuffix(seg, " ") {
+				item.trailing = space
+			}
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// verticalAlign returns the baseline shift of the node and the keyword that has to be resolved
+// against the line box
+func (ic *inlineContext) verticalAlign(v *Node, vState State) (float32, string) {
+	switch va := v.ComputedStyle["vertical-align"]; va {
+	case "", "baseline", "inherit":
+		return 0, ""
+	case "sub":
+		return -ic.em * 0.2, ""
+	case "super":
+		return ic.em * 0.35, ""
+	case "middle", "top", "bottom", "text-top", "text-bottom":
+		return 0, va
+	default:
+		// Percentages are relative to the line height of the element
+		return ic.c.unitsOf(v.ComputedStyle).px(va, vState.EM, vState.EM+3), ""
+	}
+}
+
+// layoutLines breaks the items into lines as wide as widths returns for them (floats make lines shorter),
+// places the floats between the items at the top of the lines tops returns and applies line clamping and
+// text-overflow
+func (ic *inlineContext) layoutLines(items []*inlineItem, widths, tops func(line int) float32) []*lineBox {
+	line := &lineBox{}
+	lines := []*lineBox{line}
+	var width float32
+	canBreak := false
+
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		if item.forced {
+			line.forced = true
+			line = &lineBox{}
+			lines = append(lines, line)
+			width, canBreak = 0, false
+			continue
+		}
+		// A float goes on the current line if it fits next to what is already on it, else on the next line
+		if item.float != nil {
+			b := ic.c.floatBox(item.float, floatSide(item.float))
+			current := len(lines) - 1
+			if width > 0 && width+b.x2-b.x1 > widths(current) {
+				current++
+			}
+			ic.floats = append(ic.floats, ic.c.placeFloat(item.float, tops(current), ic.floats))
+			continue
+		}
+		if width > 0 && (canBreak || item.atomic) && width+item.width-item.trailing > widths(len(lines)-1) {
+			line = &lineBox{}
+			lines = append(lines, line)
+			width = 0
+		}
+		avail := widths(len(lines) - 1)
+		// Collapsible spaces at the start of a line are removed
+		if width == 0 && item.trailing > 0 && strings.TrimSpace(item.text) == "" {
+			continue
+		}
+		// Words that don't fit on a line of their own are only broken if word-wrap allows it
+		if width == 0 && item.text != "" && item.width-item.trailing > avail && item.meta.WordBreak == "" {
+			if head, tail := splitItem(item, avail); tail != nil {
+				item = head
+				items = slices.Insert(items, i+1, tail)
+			}
+		}
+		line.items = append(line.items, item)
+		width += item.width
+		if !item.edge {
+			canBreak = item.wrap
+		}
+	}
+
+	// A line break at the end doesn't start a new line, and only spaces and floats don't make one
+	if len(lines[len(lines)-1].items) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	style := ic.root.ComputedStyle
+	lineClamp := style["-webkit-line-clamp"]
+	if lineClamp == "" {
+		lineClamp = style["line-clamp"]
+	}
+	if clamp, err := strconv.Atoi(lineClamp); err == nil && clamp > 0 && len(lines) > clamp {
+		for _, v := range lines[clamp:] {
+			for _, item := range v.items {
+				ic.hide(item)
+			}
+		}
+		lines = lines[:clamp]
+		ic.ellipsize(lines[clamp-1], widths(clamp-1), true)
+	}
+
+	// text-overflow only applies when the overflow is clipped
+	overflow := style["overflow-x"]
+	if overflow == "" {
+		overflow = style["overflow"]
+	}
+	if style["text-overflow"] == "ellipsis" && overflow != "" && overflow != "visible" {
+		for i, v := range lines {
+			if lineWidth(v) > widths(i) {
+				ic.ellipsize(v, widths(i), false)
+			}
+		}
+	}
+
+	for _, v := range lines {
+		// The collapsible space at the end of the line hangs, it is removed before the line
+		// is reordered so it can't end up at the start of a right to left line
+		for i := len(v.items) - 1; i >= 0; i-- {
+			item := v.items[i]
+			if item.hidden || item.edge {
+				continue
+			}
+			if item.trailing > 0 {
+				item.text = strings.TrimRight(item.text, " ")
+				item.width -= item.trailing
+				item.trailing = 0
+			}
+			break
+		}
+		ic.reorder(v)
+	}
+	return lines
+}
+
+// splitItem breaks a text item at the last character that fits in the width
+func splitItem(item *inlineItem, width float32) (*inlineItem, *inlineItem) {
+	runes := []rune(item.text)
+	i := 1
+	for i < len(runes) && float32(MeasureText(item.meta, string(runes[:i+1]))) <= width {
+		i++
+	}
+	if i >= len(runes) {
+		return item, nil
+	}
+	head, tail := *item, *item
+	head.text = string(runes[:i])
+	head.width = float32(MeasureText(item.meta, head.text))
+	head.trailing = 0
+	head.wrap = true
+	tail.text = string(runes[i:])
+	tail.width = float32(MeasureText(item.meta, tail.text))
+	return &head, &tail
+}
+
+func (ic *inlineContext) hide(item *inlineItem) {
+	item.hidden = true
+	if item.atomic {
+		hideNode(item.node, ic.c.State)
+	}
+}
+
+// lineWidth returns the width of the visible items without the trailing space
+func lineWidth(line *lineBox) float32 {
+	var width float32
+	var last *inlineItem
+	for _, v := range line.items {
+		if v.hidden {
+			continue
+		}
+		width += v.width
+		if !v.edge {
+			last = v
+		}
+	}
+	if last != nil {
+		width -= last.trailing
+	}
+	return width
+}
+
+// ellipsize cuts the line so it fits in the width with a ellipsis, if force is set the ellipsis
+// is added even if the line already fits
+func (ic *inlineContext) ellipsize(line *lineBox, avail float32, force bool) {
+	var x float32
+	var prev *inlineItem
+	var prevX float32
+	for i, item := range line.items {
+		if item.hidden {
+			continue
+		}
+		ellipsis := float32(0)
+		if item.meta != nil {
+			ellipsis = float32(MeasureText(item.meta, "…"))
+		}
+		if x+item.width-item.trailing+ellipsis <= avail {
+			if item.text != "" {
+				prev, prevX = item, x
+			}
+			x += item.width
+			continue
+		}
+		// The item that crosses the edge gets cut, everything after it is hidden
+		for _, v := range line.items[i+1:] {
+			ic.hide(v)
+		}
+		if item.text != "" {
+			cutText(item, avail-x)
+			return
+		}
+		ic.hide(item)
+		force = true
+		break
+	}
+	if prev != nil && force {
+		cutText(prev, avail-prevX)
+	}
+}
+
+func lastText(line *lineBox) *inlineItem {
+	for i := len(line.items) - 1; i >= 0; i-- {
+		if !line.items[i].hidden && line.items[i].text != "" {
+			return line.items[i]
+		}
+	}
+	return nil
+}
+
+func cutText(item *inlineItem, width float32) {
+	m := *item.meta
+	m.Width = int(width)
+	item.text = ellipsize(&m, item.text, true)
+	item.width = float32(MeasureText(item.meta, item.text))
+	item.trailing = 0
+}
+
+// itemShift resolves the baseline shift of a item, vertical-align: top and bottom are handled by placeLine
+func (ic *inlineContext) itemShift(item *inlineItem) float32 {
+	switch item.align {
+	case "middle":
+		// The middle of the item lines up with the baseline plus half the x-height of the parent
+		return item.shift + ic.em*0.25 - (item.ascent-item.descent)/2
+	case "text-top":
+		return item.shift + ic.ascent - item.ascent
+	case "text-bottom":
+		return item.shift + item.descent - ic.descent
+	}
+	return item.shift
+}
+
+func (ic *inlineContext) lineMetrics(line *lineBox) (float32, float32) {
+	ascent, descent := ic.strut[0], ic.strut[1]
+	for _, item := range line.items {
+		if item.hidden || item.align == "top" || item.align == "bottom" {
+			continue
+		}
+		shift := ic.itemShift(item)
+		ascent = Max(ascent, shift+item.ascent)
+		descent = Max(descent, item.descent-shift)
+	}
+	height := ascent + descent
+	for _, item := range line.items {
+		if h := item.ascent + item.descent; !item.hidden && h > height {
+			if item.align == "top" {
+				descent += h - height
+				height = h
+			} else if item.align == "bottom" {
+				ascent += h - height
+				height = h
+			}
+		}
+	}
+	return ascent, descent
+}
+
+func (ic *inlineContext) lineHeight(line *lineBox) float32 {
+	ascent, descent := ic.lineMetrics(line)
+	return ascent + descent
+}
+
+// placeLine sets the y of the items on the line and returns the height of the line
+func (ic *inlineContext) placeLine(line *lineBox) float32 {
+	ascent, descent := ic.lineMetrics(line)
+	height := ascent + descent
+	baseline := line.top + ascent
+	for _, item := range line.items {
+		switch item.align {
+		case "top":
+			item.y = line.top
+		case "bottom":
+			item.y = line.top + height - (item.ascent + item.descent)
+		default:
+			item.y = baseline - (ic.itemShift(item) + item.ascent)
+		}
+	}
+	return height
+}
+
+// alignLine sets the x of the items on the line relative to the start of the line
+func (ic *inlineContext) alignLine(line *lineBox, avail float32, align string, last bool) {
+	used := lineWidth(line)
+	var offset float32
+	// start and end depend on the direction of the block
+	switch align {
+	case "", "start":
+		align = "left"
+		if ic.rtl {
+			align = "right"
+		}
+	case "end":
+		align = "right"
+		if ic.rtl {
+			align = "left"
+		}
+	}
+	switch align {
+	case "right":
+		offset = avail - used
+	case "center":
+		offset = (avail - used) / 2
+	case "justify":
+		// The last line and lines ending in a forced break aren't justified
+		if last || line.forced || used >= avail {
+			if ic.rtl {
+				offset = avail - used
+			}
+			break
+		}
+		end := lastText(line)
+		spaces := 0
+		for _, item := range line.items {
+			if !item.hidden && item.text != "" {
+				spaces += countGaps(item, item == end)
+			}
+		}
+		if spaces == 0 {
+			break
+		}
+		gap := (avail - used) / float32(spaces)
+		for _, item := range line.items {
+			if !item.hidden && item.text != "" {
+				item.gap = gap
+				item.width += gap * float32(countGaps(item, item == end))
+			}
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	x := offset
+	for _, item := range line.items {
+		if item.hidden {
+			continue
+		}
+		item.x = x
+		x += item.width
+	}
+}
+
+func countGaps(item *inlineItem, last bool) int {
+	if last {
+		return strings.Count(strings.TrimRight(item.text, " "), " ")
+	}
+	return strings.Count(item.text, " ")
+}
+
+type textRun struct {
+	meta                *MetaData
+	text                string
+	gap                 float32
+	x, y, width, height float32
+	line                int
+}
+
+type bounds struct {
+	x1, y1, x2, y2 float32
+}
+
+func (b *bounds) add(x, y, w, h float32) {
+	b.x1, b.y1 = Min(b.x1, x), Min(b.y1, y)
+	b.x2, b.y2 = Max(b.x2, x+w), Max(b.y2, y+h)
+}
+
+// place moves the atomic items into place, sizes the inline boxes and renders the text fragments
+func (ic *inlineContext) place(lines []*lineBox, left float32) {
+	s := ic.c.State
+	runs := map[*Node][]*textRun{}
+	boxes := map[*Node]*bounds{}
+	order := []*Node{}
+
+	for i, line := range lines {
+		for _, item := range line.items {
+			if item.hidden || item.forced {
+				continue
+			}
+			x := left + item.x
+			h := item.ascent + item.descent
+
+			if item.atomic {
+				vState := s[item.node.Properties.Id]
+				shiftNode(item.node, s, x+vState.Margin.Left-vState.X, item.y+vState.Margin.Top-vState.Y)
+				vState = s[item.node.Properties.Id]
+				vState.Hidden = item.node.ComputedStyle["visibility"] == "hidden"
+				s[item.node.Properties.Id] = vState
+			} else if item.text != "" {
+				nr := runs[item.node]
+				if l := len(nr); l > 0 && nr[l-1].line == i {
+					// Text of the same node on the same line shares a fragment
+					r := nr[l-1]
+					r.text += item.text
+					r.width = x + item.width - r.x
+					r.y = Min(r.y, item.y)
+					r.height = Max(r.height, h)
+				} else {
+					runs[item.node] = append(nr, &textRun{meta: item.meta, text: item.text, gap: item.gap, x: x, y: item.y, width: item.width, height: h, line: i})
+				}
+			}
+
+			for _, b := range item.boxes {
+				if boxes[b] == nil {
+					boxes[b] = &bounds{x, item.y, x + item.width, item.y + h}
+					order = append(order, b)
+				} else {
+					boxes[b].add(x, item.y, item.width, h)
+				}
+			}
+		}
+	}
+
+	// !ISSUE: Inline boxes that span multiple lines are drawn as the union of their lines
+	for _, v := range order {
+		b := boxes[v]
+		vState := s[v.Properties.Id]
+		vState.X, vState.Y = b.x1, b.y1
+		vState.Width, vState.Height = b.x2-b.x1, b.y2-b.y1
+		s[v.Properties.Id] = vState
+	}
+
+	if r, ok := runs[ic.root]; ok {
+		ic.renderFragments(ic.root, r)
+	}
+	for _, v := range order {
+		ic.renderFragments(v, runs[v])
+	}
+}
+
+// renderFragments loads a texture for each line the text of the node is on
+func (ic *inlineContext) renderFragments(n *Node, runs []*textRun) {
+	id := n.Properties.Id
+	self := ic.c.State[id]
+	adapter := ic.c.Adapter
+	self.Fragments = []Fragment{}
+
+	// background-clip: text needs the text on the CPU to mask the background with
+	clip := false
+	for p := n; p != nil && p.ComputedStyle != nil; p = p.parent {
+		if clipsText(p.ComputedStyle) {
+			clip = true
+			break
+		}
+	}
+	if clip && ic.c.TextMasks == nil {
+		ic.c.TextMasks = map[string]image.Image{}
+	}
+
+	for i, r := range runs {
+		m := *r.meta
+		m.Text = r.text
+		m.WordGap = r.gap
+		key := FontKey(&m)
+		t := "text" + strconv.Itoa(i)
+
+		old, exists := adapter.Textures[id][t]
+		changed := !exists || old != key
+		_, masked := ic.c.TextMasks[key]
+		needsMask := clip && !masked
+		if changed {
+			img, _ := RenderFont(&m)
+			if exists {
+				adapter.UnloadTexture(id, t)
+				delete(ic.c.TextMasks, old)
+			}
+			adapter.LoadTexture(id, t, key, img)
+		}
+		if needsMask {
+			// The clipped text is usually transparent so the mask is drawn opaque
+			mask := m
+			mask.Color.A = 255
+			mask.DecorationColor.A = 255
+			img, _ := RenderFont(&mask)
+			ic.c.TextMasks[key] = img
+		}
+		self.Fragments = append(self.Fragments, Fragment{
+			X:       r.x - self.X,
+			Y:       r.y - self.Y,
+			Width:   r.width,
+			Height:  r.height,
+			Texture: key,
+		})
+	}
+
+	// Unload the lines the node no longer has
+	for t := range adapter.Textures[id] {
+		if i, err := strconv.Atoi(strings.TrimPrefix(t, "text")); err == nil && strings.HasPrefix(t, "text") && i >= len(runs) {
+			adapter.UnloadTexture(id, t)
+		}
+	}
+	ic.c.State[id] = self
+}
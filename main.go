@@ -25,6 +25,7 @@ type Window struct {
 	RenderData []State
 	Rerender   bool
 	shouldStop bool
+	monitor    *Monitor
 }
 
 func (w *Window) Document() *Node {
@@ -47,7 +48,7 @@ func (window *Window) Path(path string) {
 	}
 
 	window.CSS.Path = filepath.Dir(path)
-	createNode(htmlNodes, &window.document, &window.Styles)
+	createNode(htmlNodes, &window.document, &window.Styles, &ancestorFilter{})
 	open(window)
 }
 
@@ -145,12 +146,16 @@ func open(data *Window) {
 		Adapter:  data.CSS.Adapter,
 		CSS:      &data.CSS,
 		Focus: Focus{
-			Nodes:               []string{},
-			Selected:            -1,
-			SoftFocused:         "",
-			LastClickWasFocused: false,
+			Nodes:       []string{},
+			Selected:    -1,
+			SoftFocused: "",
 		},
 	}
+	data.monitor = &monitor
+	// RunEvents sets the root every frame, it is set here too so GetEvents has a tab order before the first frame
+	if len(data.document.Children) > 0 {
+		monitor.root = data.document.Children[0]
+	}
 
 	data.CSS.Adapter.AddEventListener("windowresize", func(e Event) {
 		wh := e.Data.(map[string]int)
@@ -182,7 +187,7 @@ func open(data *Window) {
 		getRenderData(data, &monitor)
 	})
 	data.CSS.Adapter.AddEventListener("keyup", func(e Event) {
-		currentEvent.Key = 0
+		currentEvent.Key = e.Data.(int)
 		currentEvent.KeyState = false
 		currentEvent.Modifiers = Modifiers{
 			CtrlKey:  e.CtrlKey,
@@ -199,31 +204,59 @@ func open(data *Window) {
 		if pos[0] > 0 && pos[1] > 0 {
 			if pos[0] < int(data.CSS.Width) && pos[1] < int(data.CSS.Height) {
 				currentEvent.Position = pos
+				monitor.queuePointer("pointermove", mousePointer(&currentEvent))
 				monitor.GetEvents(&currentEvent)
 				getRenderData(data, &monitor)
 			}
 		}
 	})
 
+	// The data is the vertical scroll or the horizontal and vertical scroll
 	data.CSS.Adapter.AddEventListener("scroll", func(e Event) {
-		currentEvent.ScrollY = e.Data.(int)
+		switch d := e.Data.(type) {
+		case int:
+			currentEvent.ScrollY = d
+		case []int:
+			currentEvent.ScrollX = d[0]
+			currentEvent.ScrollY = d[1]
+		}
 		monitor.GetEvents(&currentEvent)
+		currentEvent.ScrollX = 0
 		currentEvent.ScrollY = 0
 		getRenderData(data, &monitor)
 	})
 
 	data.CSS.Adapter.AddEventListener("mousedown", func(e Event) {
 		currentEvent.Click = true
+		if currentEvent.Position != nil {
+			monitor.queuePointer("pointerdown", mousePointer(&currentEvent))
+		}
 		monitor.GetEvents(&currentEvent)
 		getRenderData(data, &monitor)
 	})
 
 	data.CSS.Adapter.AddEventListener("mouseup", func(e Event) {
 		currentEvent.Click = false
+		if currentEvent.Position != nil {
+			monitor.queuePointer("pointerup", mousePointer(&currentEvent))
+		}
 		monitor.GetEvents(&currentEvent)
 		getRenderData(data, &monitor)
 	})
 
+	// Touches and pens come in as pointer events, the primary one also moves the mouse
+	for _, name := range []string{"pointerdown", "pointermove", "pointerup", "pointercancel"} {
+		data.CSS.Adapter.AddEventListener(name, func(e Event) {
+			p := e.Data.(Pointer)
+			if monitor.queuePointer(name, p) && p.Type != "mouse" {
+				currentEvent.Position = []int{p.X, p.Y}
+				currentEvent.Click = name == "pointerdown" || (name == "pointermove" && currentEvent.Click)
+				monitor.GetEvents(&currentEvent)
+			}
+			getRenderData(data, &monitor)
+		})
+	}
+
 	data.CSS.Adapter.AddEventListener("contextmenudown", func(e Event) {
 		currentEvent.Context = true
 		monitor.GetEvents(&currentEvent)
@@ -231,7 +264,8 @@ func open(data *Window) {
 	})
 
 	data.CSS.Adapter.AddEventListener("contextmenuup", func(e Event) {
-		currentEvent.Context = true
+		// Context stayed true after the first right click, so a second one on the same element sent no contextmenu
+		currentEvent.Context = false
 		monitor.GetEvents(&currentEvent)
 		getRenderData(data, &monitor)
 	})
@@ -253,6 +287,7 @@ func getRenderData(data *Window, monitor *Monitor) {
 	newDoc := CopyDocument(data.document.Children[0], &data.document)
 
 	data.CSS.ComputeNodeState(newDoc)
+	setTransforms(data.CSS.unitsOf(nil), newDoc, data.CSS.State, nil)
 
 	flatDoc := flatten(newDoc)
 
@@ -265,6 +300,11 @@ func getRenderData(data *Window, monitor *Monitor) {
 		keys = append(keys, v.Properties.Id)
 	}
 
+	setLayers(data.CSS.unitsOf(nil), rd, keys, data.CSS.Width, data.CSS.Height)
+	for i, self := range rd {
+		loadClipMask(data.CSS.Adapter, keys[i], self)
+	}
+
 	// Create a set of keys to keep
 	keysSet := make(map[string]struct{}, len(keys))
 	for _, key := range keys {
@@ -272,7 +312,14 @@ func getRenderData(data *Window, monitor *Monitor) {
 	}
 
 	for k, self := range s {
-		key := backgroundKey(self)
+		var text []Fragment
+		for _, bg := range self.Background {
+			if bg.Clip == "text" {
+				text = clippedText(k, s)
+				break
+			}
+		}
+		key := backgroundKey(self, text)
 		if _, found := keysSet[k]; !found {
 			for t := range data.CSS.Adapter.Textures[k] {
 				data.CSS.Adapter.UnloadTexture(k, t)
@@ -280,7 +327,7 @@ func getRenderData(data *Window, monitor *Monitor) {
 			delete(s, k)
 		} else {
 			if data.CSS.Adapter.Textures[k]["background"] != key {
-				img := generateBackground(data.CSS, self)
+				img := generateBackground(data.CSS, self, text)
 				data.CSS.Adapter.UnloadTexture(k, "background")
 				data.CSS.Adapter.LoadTexture(k, "background", key, img)
 				if self.Textures == nil {
@@ -293,6 +340,8 @@ func getRenderData(data *Window, monitor *Monitor) {
 		}
 	}
 
+	rd = append(rd, monitor.dragPreview(rd, keys)...)
+
 	addScroll(&data.document, s)
 
 	data.Script.Run(&data.document)
@@ -309,12 +358,16 @@ func addScroll(n *Node, s map[string]State) {
 	// !NOTE: This is the only spot you can pierce the vale
 	n.scrollHeight = s[n.Properties.Id].ScrollHeight
 	n.scrollWidth = s[n.Properties.Id].ScrollWidth
+	// The geometry methods read the layout from here (see geometry.go)
+	n.layout = s[n.Properties.Id]
 	for i := range n.Children {
 		addScroll(n.Children[i], s)
 	}
 }
 
-func createNode(node *html.Node, parent *Node, stylesheets *Styles) {
+// createNode adds the html node and the elements in it to parent, filter is the bloom filter of the ancestors of
+// the new node for the selectors (see matcher.go)
+func createNode(node *html.Node, parent *Node, stylesheets *Styles, filter *ancestorFilter) {
 	if node.Type == html.ElementNode {
 		newNode := parent.CreateElement(node.Data)
 		for _, attr := range node.Attr {
@@ -337,8 +390,10 @@ func createNode(node *html.Node, parent *Node, stylesheets *Styles) {
 			case "title":
 				newNode.title = attr.Val
 			case "tabindex":
-				val, _ := strconv.Atoi(attr.Val)
-				newNode.tabIndex = val + 1
+				// CreateElement gave the element its default, values that aren't a integer keep it like in browsers
+				if val, err := strconv.Atoi(strings.TrimSpace(attr.Val)); err == nil {
+					newNode.tabIndex = val
+				}
 			case "disabled":
 				newNode.disabled = true
 			case "required":
@@ -350,40 +405,26 @@ func createNode(node *html.Node, parent *Node, stylesheets *Styles) {
 			}
 		}
 
-		// We added one to the set tabIndex, if the element doesn't have one then
-		// the tabIndex will now be -1
-		newNode.tabIndex -= 1
-
-		if newNode.tabIndex == -1 {
-			// if we accidentally made one of the elements below non focusable then we correct it here
-			switch node.Data {
-			case "input":
-				newNode.tabIndex = 0
-			case "textarea":
-				newNode.tabIndex = 0
-			case "button":
-				newNode.tabIndex = 0
-			case "select":
-				newNode.tabIndex = 0
-			case "a":
-				newNode.tabIndex = 0
-			}
-		}
-
-		newNode.innerText = strings.TrimSpace(GetInnerText(node))
-		parent.AppendChild(&newNode)
-		parent.StyleSheets.GetStyles(&newNode)
+		// Whitespace is collapsed once the white-space style is known
+		newNode.innerText = GetInnerText(node)
+		// AppendChild would get the styles with a filter made from walking up the tree, they're got once here with
+		// the filter of the walk
+		newNode.parent = parent
+		newNode.Properties.Id = GenerateUniqueId(parent, newNode.tagName)
+		parent.Children = append(parent.Children, &newNode)
+		parent.StyleSheets.getStyles(&newNode, filter)
 		// Recursively traverse child nodes
+		children := childFilter(filter, &newNode)
 		for child := node.FirstChild; child != nil; child = child.NextSibling {
 			if child.Type == html.ElementNode {
-				createNode(child, &newNode, stylesheets)
+				createNode(child, &newNode, stylesheets, children)
 			}
 		}
 
 	} else {
 		for child := node.FirstChild; child != nil; child = child.NextSibling {
 			if child.Type == html.ElementNode {
-				createNode(child, parent, stylesheets)
+				createNode(child, parent, stylesheets, filter)
 			}
 		}
 	}
@@ -491,16 +532,9 @@ func wrapAllTextNodes(n *html.Node) {
 	}
 
 	for _, child := range children {
-		// Wrap text nodes
-		child.Data = strings.TrimSpace(child.Data)
-		child.Data = strings.ReplaceAll(child.Data, "\t", " ")
-
-		// Replace repeating spaces with a single space
-		for strings.Contains(child.Data, "  ") {
-			child.Data = strings.ReplaceAll(child.Data, "  ", " ")
-		}
-
-		if child.Type == html.TextNode && child.Data != "" {
+		// Wrap text nodes, the whitespace is left in place so white-space: pre can keep it.
+		// Whitespace only nodes are dropped unless they are inside of preformatted text
+		if child.Type == html.TextNode && (strings.TrimSpace(child.Data) != "" || (child.Data != "" && isPreformatted(n))) {
 			textEl := &html.Node{
 				Type: html.ElementNode,
 				Data: "text",
@@ -516,6 +550,24 @@ func wrapAllTextNodes(n *html.Node) {
 	}
 }
 
+var preformattedTags = map[string]bool{
+	"pre":       true,
+	"textarea":  true,
+	"listing":   true,
+	"plaintext": true,
+	"xmp":       true,
+}
+
+// isPreformatted checks if the node or any of its ancestors keep their whitespace by default
+func isPreformatted(n *html.Node) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && preformattedTags[p.Data] {
+			return true
+		}
+	}
+	return false
+}
+
 // unwrapSingleTextChildren removes <text> elements that are the only child of their parent
 func unwrapSingleTextChildren(n *html.Node) {
 	// Skip script and style tags
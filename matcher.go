@@ -0,0 +1,657 @@
+package grim
+
+import (
+	"errors"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// !DEVMAN,ELEMENT,TESTSELECTOR: Selectors are compiled once into a selectorList, the text is split into compound
+// + selectors (div.a:hover) and the combinators between them (" ", ">", "+" and "~") and the compounds are parsed
+// + into their tag, id, classes, attributes and pseudo classes. The compounds are matched from the right to the left,
+// + the rightmost one against the node and the ones before it against its parents and siblings. When a compound can
+// + match more than one parent or sibling ("div p", "h1 ~ p") each of them is tried until the rest matches.
+// + parseCSS puts each StyleMap in the bucket of the rightmost compound (its id, a class, a attribute name, the tag
+// + or *), GetStyles only looks in the buckets of the node (GenBaseElements). The tags, ids and classes the
+// + ancestors must have are kept as hashes and checked against a bloom filter of the ancestors of the node before
+// + anything else, most selectors that can't match are dropped there without walking up the tree
+
+// selectorList is a compiled selector, a match of any of the complex selectors (the parts between commas) is a match.
+// err is why the selector is invalid, a invalid selector doesn't match anything
+type selectorList struct {
+	complexes []complexSelector
+	err       error
+}
+
+// complexSelector is the compounds from left to right and the combinators between them, ancestors are the hashes
+// of the tags, ids and classes the ancestors of a matching node have to have
+type complexSelector struct {
+	compounds     []compoundSelector
+	combinators   []byte
+	pseudoElement bool
+	ancestors     []uint32
+}
+
+type compoundSelector struct {
+	tag           string
+	id            string
+	classes       []string
+	attributes    []AttributeSelector
+	pseudos       []pseudoClass
+	pseudoElement bool
+}
+
+// pseudoClass is a pseudo class with its argument, list is the compiled argument of :is, :where, :not and the
+// "of S" of :nth-child, relative the selectors of :has and a, b the An+B of the :nth- pseudo classes
+type pseudoClass struct {
+	name     string
+	arg      string
+	list     *selectorList
+	relative []relativeSelector
+	a, b     int
+	nth      bool
+}
+
+// relativeSelector is a selector in :has, combinator is where to look from the node (a space for the descendants)
+type relativeSelector struct {
+	combinator byte
+	list       *selectorList
+}
+
+// selectorCacheSize is how many compiled selectors are kept, scripts that build selectors from data would fill the
+// cache forever without a limit. The style sheets keep their own compiled selectors (see StyleMap.matcher)
+const selectorCacheSize = 1024
+
+var selectorCache = struct {
+	sync.RWMutex
+	lists map[string]*selectorList
+}{lists: map[string]*selectorList{}}
+
+// compileSelector returns the compiled selector, it's only compiled the first time while it's in the cache
+func compileSelector(selector string) *selectorList {
+	selectorCache.RLock()
+	l, ok := selectorCache.lists[selector]
+	selectorCache.RUnlock()
+	if ok {
+		return l
+	}
+
+	l = parseSelectorList(selector)
+	selectorCache.Lock()
+	// A full cache drops a selector at random (the map order is random), this is on purpose. A LRU would need the
+	// write lock on every hit and a selector that is still used is compiled again the next time it's asked for
+	if len(selectorCache.lists) >= selectorCacheSize {
+		for k := range selectorCache.lists {
+			delete(selectorCache.lists, k)
+			break
+		}
+	}
+	selectorCache.lists[selector] = l
+	selectorCache.Unlock()
+	return l
+}
+
+// parseSelectorList compiles the selector without looking in the cache
+func parseSelectorList(selector string) *selectorList {
+	if err := checkSelector(selector); err != nil {
+		return &selectorList{err: err}
+	}
+	l := &selectorList{}
+	for _, s := range splitSelector(selector, ',') {
+		compounds, combinators := splitComplex(s)
+		c := complexSelector{combinators: combinators}
+		for _, v := range compounds {
+			compound, err := compileCompound(v)
+			if err != nil {
+				return &selectorList{err: err}
+			}
+			c.compounds = append(c.compounds, compound)
+		}
+		// The pseudo element is in the last compound, it only has to be found on the node
+		c.pseudoElement = c.compounds[len(c.compounds)-1].pseudoElement
+		for i, v := range c.compounds[:len(c.compounds)-1] {
+			// A compound before a descendant or child combinator is a ancestor of the node, the ones before a
+			// sibling combinator are siblings of the node or of a ancestor
+			if combinators[i] == ' ' || combinators[i] == '>' {
+				c.ancestors = append(c.ancestors, v.hashes()...)
+			}
+		}
+		l.complexes = append(l.complexes, c)
+	}
+	return l
+}
+
+func compileCompound(compound string) (compoundSelector, error) {
+	parts := splitSelector(compound, ':')
+	c := compoundSelector{}
+	if len(parts) == 0 {
+		return c, errors.New("invalid selector: empty compound")
+	}
+	// splitSelector drops the empty part after a colon at the end
+	if strings.HasSuffix(compound, ":") {
+		return c, errors.New("invalid selector: missing pseudo name in " + compound)
+	}
+	if err := checkBase(parts[0]); err != nil {
+		return c, err
+	}
+	if parts[0] != "" && parts[0] != "*" {
+		base := ParseSelector(parts[0])
+		c.tag = base.TagName
+		c.id = base.Id
+		c.classes = base.ClassList
+		c.attributes = base.Attributes
+	}
+	for i, v := range parts[1:] {
+		// The empty part is from the first colon of ::
+		if v == "" {
+			if i+2 >= len(parts) || parts[i+2] == "" {
+				return c, errors.New("invalid selector: missing pseudo name in " + compound)
+			}
+			continue
+		}
+		p, err := compilePseudo(v)
+		if err != nil {
+			return c, err
+		}
+		// The pseudo elements that aren't styled are kept as pseudo classes that never match
+		if p.name == "before" || p.name == "after" {
+			c.pseudoElement = true
+			continue
+		}
+		c.pseudos = append(c.pseudos, p)
+	}
+	return c, nil
+}
+
+// pseudoElements are the pseudo elements a selector can end in, only ::before and ::after are styled
+var pseudoElements = map[string]bool{
+	"before":       true,
+	"after":        true,
+	"first-line":   true,
+	"first-letter": true,
+	"marker":       true,
+	"placeholder":  true,
+	"selection":    true,
+}
+
+// pseudoClasses are the pseudo classes and if they take a argument, the ones pseudoClass.match doesn't know are
+// valid but never match
+var pseudoClasses = map[string]bool{
+	"has": true, "is": true, "where": true, "matches": true, "not": true,
+	"nth-child": true, "nth-last-child": true, "nth-of-type": true, "nth-last-of-type": true,
+	"lang": true, "dir": true,
+	"first-child": false, "last-child": false, "only-child": false, "first-of-type": false, "last-of-type": false,
+	"only-of-type": false, "empty": false, "root": false, "scope": false, "required": false, "optional": false,
+	"enabled": false, "disabled": false, "checked": false, "indeterminate": false, "default": false,
+	"focus": false, "focus-visible": false, "focus-within": false, "hover": false, "active": false,
+	"placeholder-shown": false, "link": false, "any-link": false, "visited": false, "target": false,
+	"read-only": false, "read-write": false, "valid": false, "invalid": false, "in-range": false,
+	"out-of-range": false,
+}
+
+func compilePseudo(pseudo string) (pseudoClass, error) {
+	p := pseudoClass{name: strings.ToLower(pseudo)}
+	hasArg := false
+	if i := strings.Index(pseudo, "("); i > -1 && strings.HasSuffix(pseudo, ")") {
+		p.name, p.arg = strings.ToLower(pseudo[:i]), strings.TrimSpace(pseudo[i+1:len(pseudo)-1])
+		hasArg = true
+	}
+
+	takesArg, known := pseudoClasses[p.name]
+	if pseudoElements[p.name] && !hasArg {
+		return p, nil
+	}
+	if !known {
+		return p, errors.New("invalid selector: unknown pseudo class :" + p.name)
+	}
+	if takesArg != hasArg || (hasArg && p.arg == "") {
+		return p, errors.New("invalid selector: bad argument for :" + p.name)
+	}
+
+	switch p.name {
+	case "is", "where", "matches", "not":
+		p.list = compileSelector(p.arg)
+		return p, p.list.err
+	case "has":
+		for _, s := range splitSelector(p.arg, ',') {
+			r := relativeSelector{combinator: ' '}
+			if s != "" && (s[0] == '>' || s[0] == '+' || s[0] == '~') {
+				r.combinator = s[0]
+				s = strings.TrimSpace(s[1:])
+			}
+			r.list = compileSelector(s)
+			if r.list.err != nil {
+				return p, r.list.err
+			}
+			p.relative = append(p.relative, r)
+		}
+	case "nth-child", "nth-last-child":
+		// An+B of S only counts the siblings that match S
+		pattern := p.arg
+		if i := strings.Index(strings.ToLower(p.arg), " of "); i > -1 {
+			pattern = p.arg[:i]
+			p.list = compileSelector(strings.TrimSpace(p.arg[i+4:]))
+			if p.list.err != nil {
+				return p, p.list.err
+			}
+		}
+		p.a, p.b, p.nth = parseNth(pattern)
+	case "nth-of-type", "nth-last-of-type":
+		p.a, p.b, p.nth = parseNth(p.arg)
+	}
+	if strings.HasPrefix(p.name, "nth-") && !p.nth {
+		return p, errors.New("invalid selector: bad An+B in :" + p.name)
+	}
+	return p, nil
+}
+
+// checkSelector finds the mistakes in a selector that are outside of its compounds: unclosed brackets and quotes,
+// empty selectors in the list and combinators without a compound on both sides
+func checkSelector(selector string) error {
+	if strings.TrimSpace(selector) == "" {
+		return errors.New("invalid selector: empty selector")
+	}
+	nesting := 0
+	var quote rune
+	// afterCombinator is true at the start of each selector in the list and after a combinator
+	afterCombinator, empty := true, true
+	for _, r := range selector {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(' || r == '[':
+			nesting++
+		case r == ')' || r == ']':
+			nesting--
+			if nesting < 0 {
+				return errors.New("invalid selector: unexpected " + string(r))
+			}
+		}
+		if nesting > 0 || r == ')' || r == ']' || r == '"' || r == '\'' {
+			afterCombinator, empty = false, false
+			continue
+		}
+		switch {
+		case r == ',':
+			if empty || afterCombinator {
+				return errors.New("invalid selector: empty selector in " + selector)
+			}
+			afterCombinator, empty = true, true
+		case r == '>' || r == '+' || r == '~':
+			if afterCombinator {
+				return errors.New("invalid selector: unexpected " + string(r) + " in " + selector)
+			}
+			afterCombinator = true
+		case r == ' ' || r == '\t' || r == '\n':
+		default:
+			afterCombinator, empty = false, false
+		}
+	}
+	if nesting != 0 || quote != 0 {
+		return errors.New("invalid selector: unclosed bracket or quote in " + selector)
+	}
+	if empty {
+		return errors.New("invalid selector: empty selector in " + selector)
+	}
+	if afterCombinator {
+		return errors.New("invalid selector: " + selector + " ends with a combinator")
+	}
+	return nil
+}
+
+// checkBase checks the tag, id, classes and attributes before the pseudo classes of a compound
+func checkBase(base string) error {
+	for i := 0; i < len(base); i++ {
+		c := base[i]
+		switch {
+		case c == '*' && i == 0:
+		case c == '#' || c == '.':
+			if i+1 >= len(base) || !isValidIdClassChar(base[i+1]) {
+				return errors.New("invalid selector: missing name after " + string(c) + " in " + base)
+			}
+		case c == '[':
+			end := strings.IndexByte(base[i:], ']')
+			if end == -1 {
+				return errors.New("invalid selector: unclosed [ in " + base)
+			}
+			attr := ParseSelector(base[i : i+end+1])
+			if len(attr.Attributes) == 0 || strings.TrimFunc(attr.Attributes[0].Name, func(r rune) bool {
+				return r < 128 && isValidIdClassChar(byte(r))
+			}) != "" {
+				return errors.New("invalid selector: bad attribute " + base[i:i+end+1])
+			}
+			i += end
+		case !isValidIdClassChar(c):
+			return errors.New("invalid selector: unexpected " + string(c) + " in " + base)
+		}
+	}
+	return nil
+}
+
+// endsInPseudoElement reports if the rightmost compound has a pseudo element, the ones that aren't styled are
+// kept as pseudo classes
+func (c *complexSelector) endsInPseudoElement() bool {
+	last := c.compounds[len(c.compounds)-1]
+	if last.pseudoElement {
+		return true
+	}
+	for _, p := range last.pseudos {
+		if pseudoElements[p.name] {
+			return true
+		}
+	}
+	return false
+}
+
+// hashes returns the hashes of the tag, id and classes of the compound for the ancestor bloom filter
+func (c compoundSelector) hashes() []uint32 {
+	var h []uint32
+	if c.tag != "" {
+		h = append(h, selectorHash(c.tag))
+	}
+	if c.id != "" {
+		h = append(h, selectorHash("#"+c.id))
+	}
+	for _, v := range c.classes {
+		h = append(h, selectorHash("."+v))
+	}
+	return h
+}
+
+// buckets returns the StyleMap buckets the selector goes in, one for each complex selector
+func (l *selectorList) buckets() []string {
+	keys := []string{}
+	for _, c := range l.complexes {
+		last := c.compounds[len(c.compounds)-1]
+		key := "*"
+		if last.id != "" {
+			key = "#" + last.id
+		} else if len(last.classes) > 0 {
+			key = "." + last.classes[0]
+		} else if len(last.attributes) > 0 {
+			key = "[" + last.attributes[0].Name + "]"
+		} else if last.tag != "" {
+			key = last.tag
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// match reports if the node matches the selector and if it ends in a pseudo element, filter is the bloom filter of
+// the ancestors of the node or nil to not use one
+func (l *selectorList) match(n *Node, filter *ancestorFilter) (bool, bool) {
+	for i := range l.complexes {
+		c := &l.complexes[i]
+		if filter != nil && !filter.hasAll(c.ancestors) {
+			continue
+		}
+		if c.match(n, len(c.compounds)-1, nil) {
+			return true, c.pseudoElement
+		}
+	}
+	return false, false
+}
+
+// mayMatch reports if the node passes the parts of the selector that don't change with the state of the node (the
+// rightmost tag, id, classes and attributes and the ancestor filter), GetStyles skips the selectors that don't
+func (l *selectorList) mayMatch(n *Node, filter *ancestorFilter) bool {
+	for i := range l.complexes {
+		c := &l.complexes[i]
+		if (filter == nil || filter.hasAll(c.ancestors)) && c.compounds[len(c.compounds)-1].matchBase(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// match matches the compound at i against the node and the compounds before it against the elements the
+// combinators lead to. anchored is where the leftmost compound can match, nil is anywhere
+func (c *complexSelector) match(n *Node, i int, anchored func(*Node) bool) bool {
+	if !c.compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return anchored == nil || anchored(n)
+	}
+	switch c.combinators[i-1] {
+	case '>':
+		p := elementParent(n)
+		return p != nil && c.match(p, i-1, anchored)
+	case '+':
+		s := previousSibling(n)
+		return s != nil && c.match(s, i-1, anchored)
+	case '~':
+		for s := previousSibling(n); s != nil; s = previousSibling(s) {
+			if c.match(s, i-1, anchored) {
+				return true
+			}
+		}
+	default:
+		for p := elementParent(n); p != nil; p = elementParent(p) {
+			if c.match(p, i-1, anchored) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchBase matches the tag, id, classes and attributes of the compound
+func (c *compoundSelector) matchBase(n *Node) bool {
+	if (c.tag != "" && c.tag != n.tagName) || (c.id != "" && c.id != n.id) {
+		return false
+	}
+	for _, v := range c.classes {
+		if !slices.Contains(n.ClassList.classes, v) {
+			return false
+		}
+	}
+	for _, v := range c.attributes {
+		if !v.Match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compoundSelector) match(n *Node) bool {
+	if !c.matchBase(n) {
+		return false
+	}
+	for i := range c.pseudos {
+		if !c.pseudos[i].match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *pseudoClass) match(n *Node) bool {
+	switch p.name {
+	case "has":
+		for _, r := range p.relative {
+			if r.match(n) {
+				return true
+			}
+		}
+		return false
+	case "is", "where", "matches":
+		m, _ := p.list.match(n, nil)
+		return m
+	case "not":
+		m, _ := p.list.match(n, nil)
+		return !m
+	case "nth-child", "nth-last-child":
+		count := func(*Node) bool { return true }
+		if p.list != nil {
+			if m, _ := p.list.match(n, nil); !m {
+				return false
+			}
+			count = func(v *Node) bool {
+				m, _ := p.list.match(v, nil)
+				return m
+			}
+		}
+		return p.nth && nthMatch(p.a, p.b, siblingIndex(n, p.name == "nth-last-child", count))
+	case "nth-of-type", "nth-last-of-type":
+		return p.nth && nthMatch(p.a, p.b, siblingIndex(n, p.name == "nth-last-of-type", sameType(n)))
+	case "first-child":
+		return siblingIndex(n, false, nil) == 1
+	case "last-child":
+		return siblingIndex(n, true, nil) == 1
+	case "only-child":
+		return siblingIndex(n, false, nil) == 1 && siblingIndex(n, true, nil) == 1
+	case "first-of-type":
+		return siblingIndex(n, false, sameType(n)) == 1
+	case "last-of-type":
+		return siblingIndex(n, true, sameType(n)) == 1
+	case "only-of-type":
+		return siblingIndex(n, false, sameType(n)) == 1 && siblingIndex(n, true, sameType(n)) == 1
+	case "empty":
+		return len(n.Children) == 0 && n.innerText == ""
+	case "root":
+		return n.tagName != "ROOT" && elementParent(n) == nil
+	case "required":
+		return n.required
+	case "optional":
+		return !n.required && (n.tagName == "input" || n.tagName == "select" || n.tagName == "textarea")
+	case "enabled":
+		return !n.disabled
+	case "disabled":
+		return n.disabled
+	case "checked":
+		return n.checked
+	case "focus":
+		return n.focused
+	case "focus-visible":
+		return n.focusVisible
+	case "focus-within":
+		return n.focusWithin
+	case "hover":
+		return n.hovered
+	case "active":
+		return n.active
+	case "placeholder-shown":
+		_, ok := n.attribute["placeholder"]
+		return ok && (n.tagName == "input" || n.tagName == "textarea") && n.attribute["value"] == "" && n.innerText == ""
+	}
+	return false
+}
+
+// match reports if a element relative to the node matches the selector of :has. The leftmost compound of the
+// selector has to be on a element the combinator leads to from the node, div:has(p span) doesn't match a span
+// in the div when the p is outside of it
+func (r relativeSelector) match(n *Node) bool {
+	var anchored func(*Node) bool
+	var scope []*Node
+	switch r.combinator {
+	case '>':
+		anchored = func(v *Node) bool { return elementParent(v) == n }
+		scope = n.Children
+	case '+':
+		if s := nextSibling(n); s != nil {
+			scope = []*Node{s}
+		}
+		anchored = func(v *Node) bool { return slices.Contains(scope, v) }
+	case '~':
+		for s := nextSibling(n); s != nil; s = nextSibling(s) {
+			scope = append(scope, s)
+		}
+		anchored = func(v *Node) bool { return slices.Contains(scope, v) }
+	default:
+		anchored = func(v *Node) bool { return v != n && contains(n, v) }
+		scope = n.Children
+	}
+	for _, v := range scope {
+		if r.matchIn(v, anchored) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIn reports if the selector matches v or a element in it
+func (r relativeSelector) matchIn(v *Node, anchored func(*Node) bool) bool {
+	for i := range r.list.complexes {
+		c := &r.list.complexes[i]
+		if c.match(v, len(c.compounds)-1, anchored) {
+			return true
+		}
+	}
+	for _, child := range v.Children {
+		if r.matchIn(child, anchored) {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestorFilter is a bloom filter of the tags, ids and classes of the ancestors of a node, it can say a ancestor
+// has one when none do but never the other way around
+type ancestorFilter [4]uint64
+
+func selectorHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Each hash sets two of the 256 bits
+func (f *ancestorFilter) add(h uint32) {
+	f[(h>>6)&3] |= 1 << (h & 63)
+	f[(h>>14)&3] |= 1 << ((h >> 8) & 63)
+}
+
+func (f *ancestorFilter) has(h uint32) bool {
+	return f[(h>>6)&3]&(1<<(h&63)) != 0 && f[(h>>14)&3]&(1<<((h>>8)&63)) != 0
+}
+
+func (f *ancestorFilter) hasAll(hashes []uint32) bool {
+	for _, h := range hashes {
+		if !f.has(h) {
+			return false
+		}
+	}
+	return true
+}
+
+// addNode adds the tag, id and classes of the node
+func (f *ancestorFilter) addNode(n *Node) {
+	f.add(selectorHash(n.tagName))
+	if n.id != "" {
+		f.add(selectorHash("#" + n.id))
+	}
+	for _, c := range n.ClassList.classes {
+		f.add(selectorHash("." + c))
+	}
+}
+
+// ancestorsFilter returns the bloom filter of the ancestors of the node, it walks up to the root so the tree walks
+// build it on the way down with childFilter instead
+func ancestorsFilter(n *Node) *ancestorFilter {
+	f := &ancestorFilter{}
+	for p := elementParent(n); p != nil; p = elementParent(p) {
+		f.addNode(p)
+	}
+	return f
+}
+
+// childFilter returns the filter of the children of n from the filter of n, the document (ROOT) isn't added
+func childFilter(filter *ancestorFilter, n *Node) *ancestorFilter {
+	f := *filter
+	if n.tagName != "ROOT" {
+		f.addNode(n)
+	}
+	return &f
+}
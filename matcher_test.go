@@ -0,0 +1,279 @@
+package grim
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// el adds a element to parent, the id of the node is made like createNode makes it
+func el(parent *Node, tag string, classes ...string) *Node {
+	n := &Node{tagName: tag, parent: parent, attribute: map[string]string{}}
+	n.Properties.Id = parent.Properties.Id + ":" + tag + strconv.Itoa(len(parent.Children))
+	for _, c := range classes {
+		n.ClassList.Add(c)
+	}
+	parent.Children = append(parent.Children, n)
+	return n
+}
+
+func newRoot() *Node {
+	root := &Node{tagName: "ROOT", attribute: map[string]string{}}
+	root.Properties.Id = "ROOT"
+	return root
+}
+
+// matches reports if the node matches the selector
+func matches(t *testing.T, selector string, n *Node) bool {
+	t.Helper()
+	l := compileSelector(selector)
+	if l.err != nil {
+		t.Fatalf("%s: %v", selector, l.err)
+	}
+	m, _ := l.match(n, nil)
+	return m
+}
+
+// walk calls fn for every element under n with the filter of its ancestors built on the way down like createNode
+func walk(n *Node, filter *ancestorFilter, fn func(*Node, *ancestorFilter)) {
+	children := childFilter(filter, n)
+	for _, v := range n.Children {
+		fn(v, children)
+		walk(v, children, fn)
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	root := newRoot()
+	html := el(root, "html")
+	body := el(html, "body")
+	section := el(body, "section")
+	box := el(section, "div", "box")
+	inner := el(box, "div")
+	p := el(inner, "p")
+	ul := el(body, "ul", "list")
+	li1 := el(ul, "li", "item")
+	li2 := el(ul, "li", "item")
+	li3 := el(ul, "li", "item", "last")
+	a := el(li2, "a")
+
+	for _, tt := range []struct {
+		selector string
+		n        *Node
+		want     bool
+	}{
+		{"ul li", li1, true},
+		{"body > li", li1, false},
+		{"ul > li", li1, true},
+		{"li + li", li1, false},
+		{"li + li", li2, true},
+		{"li + .last", li3, true},
+		{"li ~ li", li3, true},
+		{"li ~ .last", li2, false},
+		{".list li a", a, true},
+		{"body > ul > li > a", a, true},
+		{"li + li > a", a, true},
+		{"li:first-child a", a, false},
+		// The div right under the p isn't in the section, the descendant combinator has to try the next one up
+		{"section > div p", p, true},
+		{"section > div > p", p, false},
+		{"section div.box > div > p", p, true},
+		{"html body section p", p, true},
+		{"ul p", p, false},
+		{":root > body", body, true},
+		{":root", html, true},
+		{"li.item:not(.last) ~ li.last", li3, true},
+	} {
+		if got := matches(t, tt.selector, tt.n); got != tt.want {
+			t.Errorf("%s on %s = %v, want %v", tt.selector, tt.n.Properties.Id, got, tt.want)
+		}
+	}
+}
+
+func TestHasAnchored(t *testing.T) {
+	body := el(el(newRoot(), "html"), "body")
+	p := el(body, "p")
+	div := el(p, "div")
+	el(div, "span")
+	next := el(body, "div")
+
+	for _, tt := range []struct {
+		selector string
+		n        *Node
+		want     bool
+	}{
+		{"div:has(span)", div, true},
+		// The p is outside of the div so it can't be the leftmost compound
+		{"div:has(p span)", div, false},
+		{"p:has(div span)", p, true},
+		{"p:has(> span)", p, false},
+		{"p:has(> div span)", p, true},
+		{"p:has(+ div)", p, true},
+		{"p:has(~ div)", p, true},
+		{"p:has(+ div span)", p, false},
+		{"body:has(> p > div > span)", body, true},
+		{"div:has(span)", next, false},
+	} {
+		if got := matches(t, tt.selector, tt.n); got != tt.want {
+			t.Errorf("%s on %s = %v, want %v", tt.selector, tt.n.Properties.Id, got, tt.want)
+		}
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	for _, tt := range []struct {
+		selector string
+		want     []string
+	}{
+		{"ul li", []string{"li"}},
+		{"div#main.a", []string{"#main"}},
+		{"ul > li.item.first", []string{".item"}},
+		{"li a[href^=\"https\"]", []string{"[href]"}},
+		{"li.a, li.b, p.a", []string{".a", ".b"}},
+		{":hover", []string{"*"}},
+		{"*.a:hover", []string{".a"}},
+		{".a > *", []string{"*"}},
+	} {
+		if got := compileSelector(tt.selector).buckets(); !slices.Equal(got, tt.want) {
+			t.Errorf("buckets of %s = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+
+	// A node has to look in one of the buckets of every selector it matches
+	root, _ := benchDocument(4, 12)
+	walk(root, &ancestorFilter{}, func(n *Node, _ *ancestorFilter) {
+		keys := GenBaseElements(n)
+		for _, s := range benchSelectors() {
+			l := compileSelector(s)
+			if m, _ := l.match(n, nil); !m {
+				continue
+			}
+			found := false
+			for _, k := range l.buckets() {
+				found = found || slices.Contains(keys, k)
+			}
+			if !found {
+				t.Errorf("%s matches %s but it isn't in the buckets %v", s, n.Properties.Id, keys)
+			}
+		}
+	})
+}
+
+func TestAncestorFilter(t *testing.T) {
+	root, _ := benchDocument(4, 12)
+	selectors := append(benchSelectors(), "body section.level2 li a", "html > body > section a.link", ".level0 .level3 ~ *")
+	walk(root, &ancestorFilter{}, func(n *Node, filter *ancestorFilter) {
+		// The filter built on the way down has the same bits as walking up from the node
+		if *filter != *ancestorsFilter(n) {
+			t.Fatalf("the filter of %s built on the way down isn't the filter of its ancestors", n.Properties.Id)
+		}
+		// A bloom filter can say yes when the answer is no but never the other way around, the filter can't
+		// drop a selector that matches
+		for _, s := range selectors {
+			l := compileSelector(s)
+			want, _ := l.match(n, nil)
+			got, _ := l.match(n, filter)
+			if want && (!got || !l.mayMatch(n, filter)) {
+				t.Errorf("the ancestor filter dropped %s on %s", s, n.Properties.Id)
+			}
+			if got != want {
+				t.Errorf("%s on %s = %v with the filter, want %v", s, n.Properties.Id, got, want)
+			}
+		}
+	})
+}
+
+func TestSelectorCacheBounded(t *testing.T) {
+	for i := 0; i < selectorCacheSize*2; i++ {
+		compileSelector("#generated" + strconv.Itoa(i))
+	}
+	selectorCache.RLock()
+	size := len(selectorCache.lists)
+	selectorCache.RUnlock()
+	if size > selectorCacheSize {
+		t.Errorf("the selector cache has %d selectors, the limit is %d", size, selectorCacheSize)
+	}
+}
+
+// benchDocument builds a document with depth levels of nested sections that each have width list items, it returns
+// the ROOT and the elements in document order
+func benchDocument(depth, width int) (*Node, []*Node) {
+	root := newRoot()
+	nodes := []*Node{}
+	add := func(parent *Node, tag string, classes ...string) *Node {
+		n := el(parent, tag, classes...)
+		nodes = append(nodes, n)
+		return n
+	}
+
+	html := add(root, "html")
+	parent := add(html, "body")
+	for d := 0; d < depth; d++ {
+		parent = add(parent, "section", "level"+strconv.Itoa(d))
+		list := add(parent, "ul", "list")
+		for w := 0; w < width; w++ {
+			item := add(list, "li", "item", "item"+strconv.Itoa(w%10))
+			add(item, "a", "link").href = "https://example.com/" + strconv.Itoa(w)
+		}
+	}
+	return root, nodes
+}
+
+// benchSelectors are rules like the ones in the style sheets of web pages
+func benchSelectors() []string {
+	selectors := []string{
+		"*", "html", "body", "a", "li", "ul.list", ".item a", "section > ul", "li + li", "li ~ li",
+		"a[href^=\"https\"]", "li:nth-child(2n+1)", "li:first-child", "li:last-of-type", ":root",
+		"ul li:hover", "a:focus-visible", "section:has(> ul)", ":is(h1, h2, h3) + p", "li:not(.item3)",
+	}
+	for i := 0; i < 100; i++ {
+		selectors = append(selectors,
+			".sidebar"+strconv.Itoa(i)+" .item a",
+			"#header"+strconv.Itoa(i)+" nav > ul li",
+			"article.post"+strconv.Itoa(i)+" p:first-child",
+		)
+	}
+	return selectors
+}
+
+// BenchmarkMatch tests every selector against every node with the compiled selectors
+func BenchmarkMatch(b *testing.B) {
+	_, nodes := benchDocument(8, 40)
+	selectors := []*selectorList{}
+	for _, s := range benchSelectors() {
+		selectors = append(selectors, compileSelector(s))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, n := range nodes {
+			for _, s := range selectors {
+				s.match(n, nil)
+			}
+		}
+	}
+}
+
+// BenchmarkStyles finds the rules of every node like GetStyles does: only the rightmost buckets of the node are
+// looked in and the ancestor filter is built on the way down the tree
+func BenchmarkStyles(b *testing.B) {
+	root, _ := benchDocument(8, 40)
+	buckets := map[string][]*selectorList{}
+	for _, s := range benchSelectors() {
+		l := compileSelector(s)
+		for _, k := range l.buckets() {
+			buckets[k] = append(buckets[k], l)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		walk(root, &ancestorFilter{}, func(n *Node, filter *ancestorFilter) {
+			for _, k := range GenBaseElements(n) {
+				for _, s := range buckets[k] {
+					if s.mayMatch(n, filter) {
+						s.match(n, filter)
+					}
+				}
+			}
+		})
+	}
+}
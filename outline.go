@@ -0,0 +1,123 @@
+package grim
+
+import (
+	"grim/canvas"
+	"grim/color"
+	ic "image/color"
+	"strconv"
+	"strings"
+)
+
+// !DEVMAN: Outlines are drawn outside of the border box and don't take up space. The outline is drawn
+// + with the border code as a border around the border box grown by outline-offset, the adapter draws
+// + the "outline" texture at X/Y minus the offset and width of the outline on top of the element
+
+type Outline struct {
+	Width  float32
+	Style  string
+	Color  ic.RGBA
+	Offset float32
+}
+
+// parseOutline parses outline and its longhands, the longhands win over the shorthand
+func parseOutline(units unitContext, style map[string]string, current string, em, width float32) Outline {
+	w, s, c := "medium", "none", current
+	for _, v := range Token('(', ')', ' ', style["outline"]) {
+		switch {
+		case v == "thin" || v == "medium" || v == "thick" || isWidthComponent(v, []string{"px", "em", "pt", "pc", "vw", "vh", "cm", "in"}):
+			w = v
+		case v == "auto" || v == "none" || v == "hidden" || v == "dotted" || v == "dashed" || v == "solid" ||
+			v == "double" || v == "groove" || v == "ridge" || v == "inset" || v == "outset":
+			s = v
+		default:
+			c = v
+		}
+	}
+	if v := strings.TrimSpace(style["outline-width"]); v != "" {
+		w = v
+	}
+	if v := strings.TrimSpace(style["outline-style"]); v != "" {
+		s = v
+	}
+	if v := strings.TrimSpace(style["outline-color"]); v != "" {
+		c = v
+	}
+
+	if s == "none" || s == "hidden" {
+		return Outline{}
+	}
+	// auto is the focus ring of the platform, grim draws it solid
+	if s == "auto" {
+		s = "solid"
+	}
+	// invert isn't possible without reading the screen so it falls back to currentcolor like browsers do
+	if c == "invert" {
+		c = current
+	}
+	o := Outline{
+		Width:  units.px(w, em, width),
+		Style:  s,
+		Offset: units.px(style["outline-offset"], em, width),
+	}
+	o.Color, _ = color.ParseRGBA(color.ResolveCurrentColor(c, current))
+	return o
+}
+
+// drawOutline loads the outline texture of the element or unloads it if it doesn't have one
+func drawOutline(self *State, a *Adapter, id string) {
+	o := self.Outline
+	width := self.Width + self.Border.Left.Width + self.Border.Right.Width + (o.Offset+o.Width)*2
+	height := self.Height + self.Border.Top.Width + self.Border.Bottom.Width + (o.Offset+o.Width)*2
+	if o.Width <= 0 || o.Color.A == 0 || width <= o.Width*2 || height <= o.Width*2 {
+		if _, exists := a.Textures[id]["outline"]; exists {
+			a.UnloadTexture(id, "outline")
+		}
+		self.Textures["outline"] = ""
+		return
+	}
+
+	// Rounded corners follow the border radius out to the outline
+	radius := func(r float32) float32 {
+		if r <= 0 {
+			return 0
+		}
+		return Max(0, r+o.Offset+o.Width)
+	}
+	side := BorderSide{Width: o.Width, Style: o.Style, Color: o.Color}
+	box := State{
+		Width:  width - o.Width*2,
+		Height: height - o.Width*2,
+		Border: Border{
+			Top:    side,
+			Right:  side,
+			Bottom: side,
+			Left:   side,
+			Radius: BorderRadius{
+				TopLeft:     radius(self.Border.Radius.TopLeft),
+				TopRight:    radius(self.Border.Radius.TopRight),
+				BottomLeft:  radius(self.Border.Radius.BottomLeft),
+				BottomRight: radius(self.Border.Radius.BottomRight),
+			},
+		},
+	}
+
+	key := "outline" + strconv.Itoa(int(width)) + strconv.Itoa(int(height)) + strconv.Itoa(int(o.Width)) + o.Style + RGBAtoString(o.Color) +
+		strconv.Itoa(int(box.Border.Radius.TopLeft)) + strconv.Itoa(int(box.Border.Radius.TopRight)) +
+		strconv.Itoa(int(box.Border.Radius.BottomLeft)) + strconv.Itoa(int(box.Border.Radius.BottomRight))
+	m, exists := a.Textures[id]["outline"]
+	if exists && m == key {
+		self.Textures["outline"] = key
+		return
+	}
+	if exists {
+		a.UnloadTexture(id, "outline")
+	}
+
+	ctx := canvas.NewCanvas(int(width), int(height))
+	ctx.SetStrokeStyle(0, 0, 0, 255)
+	for _, s := range []string{"top", "right", "bottom", "left"} {
+		drawBorderSide(ctx, s, side, &box, o.Style)
+	}
+	a.LoadTexture(id, "outline", key, ctx.Context.Image())
+	self.Textures["outline"] = key
+}
@@ -10,6 +10,25 @@ type StyleMap struct {
 	Styles       *map[string]string
 	Sheet        int
 	PsuedoStyles map[string]map[string]map[string]string
+	// compiled is the compiled Selector and order is where the rule was in the style sheets
+	compiled *selectorList
+	order    int
+}
+
+// ruleOrder counts the rules parseCSS has read so the later ones win when they're on the same Sheet
+var ruleOrder int
+
+// matcher returns the compiled selector, style maps made outside of parseCSS are compiled the first time
+func (m *StyleMap) matcher() *selectorList {
+	if m.compiled == nil {
+		m.compiled = compileSelector(m.Selector)
+	}
+	return m.compiled
+}
+
+// test matches the selector against the node, filter is the bloom filter of the ancestors of the node
+func (m *StyleMap) test(n *Node, filter *ancestorFilter) (bool, bool) {
+	return m.matcher().match(n, filter)
 }
 
 func parseCSS(css string) map[string][]*StyleMap {
@@ -37,19 +56,19 @@ func parseCSS(css string) map[string][]*StyleMap {
 		styles = Expander(styles)
 		// Add to style maps
 		for _, s := range selectors {
-			sel := ExtractBaseElements(s)
-			for _, is := range sel {
-				for _, v := range is {
-					styleMap := &StyleMap{
-						Selector: s,
-						Styles:   &styles,
-					}
-
-					if styleMaps[v] == nil {
-						styleMaps[v] = []*StyleMap{}
-					}
-					styleMaps[v] = append(styleMaps[v], styleMap)
+			ruleOrder++
+			styleMap := &StyleMap{
+				Selector: s,
+				Styles:   &styles,
+				compiled: compileSelector(s),
+				order:    ruleOrder,
+			}
+			// The selector is only in the buckets of its rightmost compounds (see matcher.go)
+			for _, v := range styleMap.compiled.buckets() {
+				if styleMaps[v] == nil {
+					styleMaps[v] = []*StyleMap{}
 				}
+				styleMaps[v] = append(styleMaps[v], styleMap)
 			}
 		}
 	}
@@ -62,7 +62,7 @@ func Init() grim.Plugin {
 							p = c.State[v.Children[0].Properties.Id]
 							p.Hidden = false
 
-							containerHeight -= grim.ConvertToPixels(v.ComputedStyle["height"], self.EM, self.Width)
+							containerHeight -= c.ConvertToPixels(v.ComputedStyle["height"], self.EM, self.Width)
 
 							p.Width = (containerWidth / contentWidth) * containerWidth
 
@@ -94,6 +94,9 @@ func Init() grim.Plugin {
 				scrollLeft = 0
 			}
 
+			// Sticky elements are moved before the children are cropped so they are cropped where they stick
+			stick(n, c, self, scrollTop, scrollLeft)
+
 			for _, v := range n.Children {
 				if v.ComputedStyle["position"] == "fixed" || v.TagName() == "grim-track" {
 					continue
@@ -148,6 +151,10 @@ func Init() grim.Plugin {
 }
 
 func updateChildren(n *grim.Node, c *grim.CSS, offsetY, offsetX int) {
+	// Fixed elements stay where they are when the container scrolls
+	if n.ComputedStyle["position"] == "fixed" {
+		return
+	}
 	self := c.State[n.Properties.Id]
 	self.X -= float32(offsetX)
 	self.Y -= float32(offsetY)
@@ -157,6 +164,71 @@ func updateChildren(n *grim.Node, c *grim.CSS, offsetY, offsetX int) {
 	}
 }
 
+// stick moves the sticky elements that scroll with the container (the children of n that aren't inside
+// of another scroll container), positions are from before the scroll is applied
+func stick(n *grim.Node, c *grim.CSS, container grim.State, scrollTop, scrollLeft int) {
+	for _, v := range n.Children {
+		if v.ComputedStyle["position"] == "fixed" || v.TagName() == "grim-track" {
+			continue
+		}
+		if v.ComputedStyle["position"] == "sticky" {
+			stickNode(v, n, c, container, scrollTop, scrollLeft)
+		}
+		if v.ComputedStyle["overflow"] == "" && v.ComputedStyle["overflow-x"] == "" && v.ComputedStyle["overflow-y"] == "" {
+			stick(v, c, container, scrollTop, scrollLeft)
+		}
+	}
+}
+
+// stickNode keeps a sticky element inside of the scrollport of the container by its top, left, bottom
+// and right, it can't be moved out of the content box of its parent
+func stickNode(n, parentNode *grim.Node, c *grim.CSS, container grim.State, scrollTop, scrollLeft int) {
+	self := c.State[n.Properties.Id]
+	parent := c.State[parentNode.Properties.Id]
+	style := n.ComputedStyle
+
+	// The padding box of the container where it is scrolled to
+	portTop := container.Y + container.Border.Top.Width + float32(scrollTop)
+	portLeft := container.X + container.Border.Left.Width + float32(scrollLeft)
+	portBottom := portTop + container.Height
+	portRight := portLeft + container.Width
+
+	top := parent.Y + parent.Border.Top.Width + parent.Padding.Top + self.Margin.Top
+	bottom := parent.Y + parent.Border.Top.Width + parent.Height - parent.Padding.Bottom - self.Margin.Bottom
+	left := parent.X + parent.Border.Left.Width + parent.Padding.Left + self.Margin.Left
+	right := parent.X + parent.Border.Left.Width + parent.Width - parent.Padding.Right - self.Margin.Right
+
+	width := self.Width + self.Border.Left.Width + self.Border.Right.Width
+	height := self.Height + self.Border.Top.Width + self.Border.Bottom.Width
+
+	inset := func(name string, size float32) (float32, bool) {
+		v := style[name]
+		if v == "" || v == "auto" {
+			return 0, false
+		}
+		return c.ConvertToPixels(v, self.EM, size), true
+	}
+
+	// Top and left push the element forward, bottom and right pull it back, never past where it started
+	x, y := self.X, self.Y
+	if t, ok := inset("top", container.Height); ok && y < portTop+t {
+		y = max(self.Y, min(portTop+t, bottom-height))
+	}
+	if b, ok := inset("bottom", container.Height); ok && y+height > portBottom-b {
+		y = min(self.Y, max(portBottom-b-height, top))
+	}
+	if l, ok := inset("left", container.Width); ok && x < portLeft+l {
+		x = max(self.X, min(portLeft+l, right-width))
+	}
+	if r, ok := inset("right", container.Width); ok && x+width > portRight-r {
+		x = min(self.X, max(portRight-r-width, left))
+	}
+
+	if dx, dy := int(x-self.X), int(y-self.Y); dx != 0 || dy != 0 {
+		updateChildren(n, c, -dy, -dx)
+	}
+}
+
 func findScroll(n *grim.Node) (int, int) {
 	left, top := n.GetScroll()
 	if top != 0 || left != 0 {
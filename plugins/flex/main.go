@@ -2,7 +2,6 @@ package flex
 
 import (
 	"grim"
-	"grim/plugins/inline"
 	"sort"
 	"strings"
 )
@@ -143,9 +142,9 @@ func Init() grim.Plugin {
 						vState.Y = fState.Y + vState.Margin.Top
 
 						c.State[v.Properties.Id] = vState
-						deInline(v, c)
-						applyInline(v, c)
-						applyBlock(v, c)
+						// Lay the text out again in the new width
+						c.LayoutInline(v)
+						vState = c.State[v.Properties.Id]
 						_, h := getInnerSize(v, c)
 						h = grim.Max(h, vState.Height)
 						maxH = grim.Max(maxH, h)
@@ -214,9 +213,9 @@ func Init() grim.Plugin {
 						vState.Y = yStore
 
 						c.State[v.Properties.Id] = vState
-						deInline(v, c)
-						applyInline(v, c)
-						applyBlock(v, c)
+						// Lay the text out again in the new width
+						c.LayoutInline(v)
+						vState = c.State[v.Properties.Id]
 						_, h := getInnerSize(v, c)
 						h = grim.Max(h, vState.Height)
 						maxH = grim.Max(maxH, h)
@@ -418,73 +417,6 @@ func Init() grim.Plugin {
 	}
 }
 
-func applyBlock(n *grim.Node, c *grim.CSS) {
-	if len(n.Children) > 0 {
-		accum := float32(0)
-		inlineOffset := float32(0)
-		lastHeight := float32(0)
-		baseY := c.State[n.Children[0].Properties.Id].Y
-		for i := 0; i < len(n.Children); i++ {
-			v := n.Children[i]
-			vState := c.State[v.Properties.Id]
-
-			if v.ComputedStyle["display"] != "block" {
-				vState.Y += inlineOffset
-				accum = (vState.Y - baseY)
-				lastHeight = vState.Height
-			} else if v.ComputedStyle["position"] != "absolute" {
-				vState.Y += accum
-				inlineOffset += (vState.Height + (vState.Border.Top.Width + vState.Border.Bottom.Width) + vState.Margin.Top + vState.Margin.Bottom + vState.Padding.Top + vState.Padding.Bottom) + lastHeight
-			}
-			c.State[v.Properties.Id] = vState
-		}
-	}
-}
-
-func deInline(n *grim.Node, c *grim.CSS) {
-	// self := c.State[n.Properties.Id]
-	baseX := float32(-1)
-	baseY := float32(-1)
-	for _, v := range n.Children {
-		vState := c.State[v.Properties.Id]
-
-		if v.ComputedStyle["display"] == "inline" {
-			if baseX < 0 && baseY < 0 {
-				baseX = vState.X
-				baseY = vState.Y
-			} else {
-				vState.X = baseX
-				vState.Y = baseY
-				c.State[v.Properties.Id] = vState
-
-			}
-		} else {
-			baseX = float32(-1)
-			baseY = float32(-1)
-		}
-
-		if len(v.Children) > 0 {
-			deInline(v, c)
-		}
-	}
-
-}
-
-func applyInline(n *grim.Node, c *grim.CSS) {
-	pl := inline.Init()
-	for i := 0; i < len(n.Children); i++ {
-		v := n.Children[i]
-
-		if len(v.Children) > 0 {
-			applyInline(v, c)
-		}
-
-		if pl.Selector(v, c) {
-			pl.Handler(v, c)
-		}
-	}
-}
-
 func propagateOffsets(n *grim.Node, prevx, prevy, newx, newy float32, c *grim.CSS) {
 	for _, v := range n.Children {
 		vState := c.State[v.Properties.Id]
@@ -502,11 +434,12 @@ func propagateOffsets(n *grim.Node, prevx, prevy, newx, newy float32, c *grim.CS
 }
 
 func countText(n *grim.Node) int {
-	count := 0
+	count := len(strings.Fields(n.InnerText()))
 	groups := []int{}
 	for _, v := range n.Children {
 		if v.TagName() == "text" {
-			count += 1
+			count += len(strings.Fields(v.InnerText()))
+			continue
 		}
 		if v.ComputedStyle["display"] == "block" {
 			groups = append(groups, count)
@@ -527,7 +460,7 @@ func countText(n *grim.Node) int {
 func minHeight(n *grim.Node, c *grim.CSS, prev float32) float32 {
 	self := c.State[n.Properties.Id]
 	if n.ComputedStyle["min-height"] != "" {
-		mw := grim.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[n.Parent().Properties.Id].Width)
+		mw := c.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[n.Parent().Properties.Id].Width)
 		return grim.Max(prev, mw)
 	} else {
 		return prev
@@ -547,7 +480,7 @@ func getMinHeight(n *grim.Node, c *grim.CSS) float32 {
 		selfHeight = self.Height
 	}
 	if n.ComputedStyle["min-height"] != "" {
-		mh := grim.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[n.Parent().Properties.Id].Width)
+		mh := c.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[n.Parent().Properties.Id].Width)
 		selfHeight = grim.Max(mh, selfHeight)
 	}
 
@@ -567,7 +500,7 @@ func getMinWidth(n *grim.Node, c *grim.CSS) float32 {
 		selfWidth = self.Width
 	}
 	if n.ComputedStyle["min-width"] != "" {
-		mw := grim.ConvertToPixels(n.ComputedStyle["min-width"], self.EM, c.State[n.Parent().Properties.Id].Width)
+		mw := c.ConvertToPixels(n.ComputedStyle["min-width"], self.EM, c.State[n.Parent().Properties.Id].Width)
 		selfWidth = grim.Max(mw, selfWidth)
 	}
 
@@ -622,17 +555,17 @@ func setWidth(n *grim.Node, c *grim.CSS, width float32) float32 {
 	prid := n.Parent().Properties.Id
 
 	if n.ComputedStyle["width"] != "" {
-		return grim.ConvertToPixels(n.ComputedStyle["width"], self.EM, c.State[prid].Width) + self.Padding.Left + self.Padding.Right
+		return c.ConvertToPixels(n.ComputedStyle["width"], self.EM, c.State[prid].Width) + self.Padding.Left + self.Padding.Right
 	}
 
 	var maxWidth, minWidth float32
 	maxWidth = 10e9
 	if n.ComputedStyle["min-width"] != "" {
-		minWidth = grim.ConvertToPixels(n.ComputedStyle["min-width"], self.EM, c.State[prid].Width)
+		minWidth = c.ConvertToPixels(n.ComputedStyle["min-width"], self.EM, c.State[prid].Width)
 		minWidth += self.Padding.Left + self.Padding.Right
 	}
 	if n.ComputedStyle["max-width"] != "" {
-		maxWidth = grim.ConvertToPixels(n.ComputedStyle["min-width"], self.EM, c.State[prid].Width)
+		maxWidth = c.ConvertToPixels(n.ComputedStyle["min-width"], self.EM, c.State[prid].Width)
 		maxWidth += self.Padding.Left + self.Padding.Right
 	}
 
@@ -644,16 +577,16 @@ func setHeight(n *grim.Node, c *grim.CSS, height float32) float32 {
 	prid := n.Parent().Properties.Id
 
 	if n.ComputedStyle["height"] != "" {
-		return grim.ConvertToPixels(n.ComputedStyle["height"], self.EM, c.State[prid].Height)
+		return c.ConvertToPixels(n.ComputedStyle["height"], self.EM, c.State[prid].Height)
 	}
 
 	var maxHeight, minHeight float32
 	maxHeight = 10e9
 	if n.ComputedStyle["min-height"] != "" {
-		minHeight = grim.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[prid].Height)
+		minHeight = c.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[prid].Height)
 	}
 	if n.ComputedStyle["max-height"] != "" {
-		maxHeight = grim.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[prid].Height)
+		maxHeight = c.ConvertToPixels(n.ComputedStyle["min-height"], self.EM, c.State[prid].Height)
 	}
 
 	return grim.Max(minHeight, grim.Min(height, maxHeight))
@@ -681,6 +614,10 @@ func getNodeHeight(n *grim.Node, c *grim.CSS) float32 {
 
 func getInnerSize(n *grim.Node, c *grim.CSS) (float32, float32) {
 	self := c.State[n.Properties.Id]
+	// Text is sized by its line boxes
+	if len(n.Children) == 0 {
+		return self.Width, self.Height
+	}
 
 	minx := float32(10e10)
 	maxw := float32(0)
@@ -0,0 +1,619 @@
+package table
+
+import (
+	"grim"
+	"strconv"
+	"strings"
+)
+
+// !DEVMAN: The table plugin lays out display: table elements once their content is computed. The rows
+// + (header groups first, footer groups last) are put in a grid with the colspan and rowspan of the cells,
+// + the columns are sized from the min and max content widths of the cells (or the first row with
+// + table-layout: fixed), the cells get the width of their columns and their content is laid out again,
+// + then each row is as tall as its tallest cell and everything is moved into place
+
+type cell struct {
+	node    *grim.Node
+	row     int
+	col     int
+	rowSpan int
+	colSpan int
+}
+
+type row struct {
+	// node is nil for cells that are directly inside of the table
+	node  *grim.Node
+	cells []*grim.Node
+	group int
+}
+
+type group struct {
+	// node is nil for rows directly inside of the table
+	node  *grim.Node
+	first int
+	last  int
+	// anonymous are cells directly inside of the table, they make up a row
+	anonymous []*grim.Node
+}
+
+type grid struct {
+	rows     []row
+	groups   []group
+	cells    []*cell
+	columns  int
+	captions []*grim.Node
+	// cols are the table-column elements, a column with span="2" is in it twice
+	cols []*grim.Node
+}
+
+func Init() grim.Plugin {
+	return grim.Plugin{
+		Selector: func(n *grim.Node, c *grim.CSS) bool {
+			d := n.ComputedStyle["display"]
+			return d == "table" || d == "inline-table"
+		},
+		Handler: func(n *grim.Node, c *grim.CSS) {
+			g := buildGrid(n)
+			if len(g.rows) == 0 && len(g.captions) == 0 {
+				return
+			}
+			self := c.State[n.Properties.Id]
+			style := n.ComputedStyle
+
+			// Collapsed borders are shared by the cells and the table has no padding
+			collapse := style["border-collapse"] == "collapse"
+			var hs, vs float32
+			if collapse {
+				self.Padding = grim.BoxSpacing{}
+				collapseBorders(g, n, c)
+			} else {
+				spacing := strings.Fields(style["border-spacing"])
+				if len(spacing) > 0 {
+					hs = c.ConvertToPixels(spacing[0], self.EM, self.Width)
+					vs = hs
+				}
+				if len(spacing) > 1 {
+					vs = c.ConvertToPixels(spacing[1], self.EM, self.Width)
+				}
+			}
+
+			inner := self.Width - self.Padding.Left - self.Padding.Right
+			space := inner - hs*float32(g.columns+1)
+			var widths []float32
+			if style["table-layout"] == "fixed" && style["width"] != "" {
+				widths = fixedWidths(g, c, space)
+			} else {
+				widths = autoWidths(g, c, space, hs, style["width"] != "")
+			}
+
+			tableWidth := hs * float32(g.columns+1)
+			for _, w := range widths {
+				tableWidth += w
+			}
+			if style["width"] == "" || tableWidth > inner {
+				inner = tableWidth
+			}
+
+			// Cells and captions get their width and their content is laid out again
+			for _, v := range g.cells {
+				vState := c.State[v.node.Properties.Id]
+				w := hs * float32(v.colSpan-1)
+				for _, cw := range widths[v.col : v.col+v.colSpan] {
+					w += cw
+				}
+				fit(v.node, c, w-vState.Border.Left.Width-vState.Border.Right.Width)
+			}
+			for _, v := range g.captions {
+				vState := c.State[v.Properties.Id]
+				fit(v, c, inner-vState.Margin.Left-vState.Margin.Right-vState.Border.Left.Width-vState.Border.Right.Width)
+			}
+
+			heights := rowHeights(g, c, vs, self.Height)
+
+			left := self.X + self.Border.Left.Width + self.Padding.Left
+			y := self.Y + self.Border.Top.Width + self.Padding.Top
+			placeCaptions := func(bottom bool) {
+				for _, v := range g.captions {
+					if (v.ComputedStyle["caption-side"] == "bottom") != bottom {
+						continue
+					}
+					vState := c.State[v.Properties.Id]
+					move(v, c, left+vState.Margin.Left-vState.X, y+vState.Margin.Top-vState.Y)
+					y += vState.Margin.Top + vState.Height + vState.Border.Top.Width + vState.Border.Bottom.Width + vState.Margin.Bottom
+				}
+			}
+
+			placeCaptions(false)
+			y += vs
+			rowY := make([]float32, len(g.rows))
+			for i := range g.rows {
+				rowY[i] = y
+				y += heights[i] + vs
+			}
+			colX := make([]float32, g.columns+1)
+			colX[0] = left + hs
+			for i, w := range widths {
+				colX[i+1] = colX[i] + w + hs
+			}
+			rowsWidth := colX[g.columns] - hs - colX[0]
+
+			for _, v := range g.cells {
+				h := vs * float32(v.rowSpan-1)
+				for _, rh := range heights[v.row : v.row+v.rowSpan] {
+					h += rh
+				}
+				placeCell(v.node, c, colX[v.col], rowY[v.row], h)
+			}
+
+			for i, r := range g.rows {
+				if r.node == nil {
+					continue
+				}
+				setBox(r.node, c, colX[0], rowY[i], rowsWidth, heights[i])
+			}
+			for _, gr := range g.groups {
+				if gr.node == nil || gr.last < gr.first {
+					continue
+				}
+				bottom := rowY[gr.last] + heights[gr.last]
+				setBox(gr.node, c, colX[0], rowY[gr.first], rowsWidth, bottom-rowY[gr.first])
+			}
+
+			placeCaptions(true)
+
+			self.Width = inner + self.Padding.Left + self.Padding.Right
+			if h := y - self.Y - self.Border.Top.Width + self.Padding.Bottom; style["height"] == "" || h > self.Height {
+				self.Height = h
+			}
+			self.ScrollWidth = max(self.ScrollWidth, int(self.Width))
+			self.ScrollHeight = max(self.ScrollHeight, int(self.Height))
+			c.State[n.Properties.Id] = self
+			c.DrawBorder(n)
+		},
+	}
+}
+
+// buildGrid finds the rows, cells, captions and columns of the table and gives every cell its place
+func buildGrid(n *grim.Node) *grid {
+	g := &grid{}
+	var headers, bodies, footers []group
+	var anonymous []*grim.Node
+
+	addAnonymous := func() {
+		if len(anonymous) > 0 {
+			bodies = append(bodies, group{anonymous: anonymous})
+			anonymous = nil
+		}
+	}
+	for _, v := range n.Children {
+		display := v.ComputedStyle["display"]
+		if display == "table-cell" {
+			anonymous = append(anonymous, v)
+			continue
+		}
+		addAnonymous()
+		switch display {
+		case "table-caption":
+			g.captions = append(g.captions, v)
+		case "table-column-group":
+			cols := []*grim.Node{}
+			for _, col := range v.Children {
+				if col.ComputedStyle["display"] == "table-column" {
+					cols = append(cols, col)
+				}
+			}
+			if len(cols) == 0 {
+				cols = []*grim.Node{v}
+			}
+			for _, col := range cols {
+				for i := 0; i < span(col, "span"); i++ {
+					g.cols = append(g.cols, col)
+				}
+			}
+		case "table-column":
+			for i := 0; i < span(v, "span"); i++ {
+				g.cols = append(g.cols, v)
+			}
+		case "table-header-group":
+			headers = append(headers, group{node: v})
+		case "table-footer-group":
+			footers = append(footers, group{node: v})
+		case "table-row-group":
+			bodies = append(bodies, group{node: v})
+		case "table-row":
+			bodies = append(bodies, group{node: v})
+		}
+	}
+	addAnonymous()
+
+	for _, gr := range append(append(headers, bodies...), footers...) {
+		gr.first = len(g.rows)
+		switch {
+		case gr.node == nil:
+			g.rows = append(g.rows, row{cells: gr.anonymous, group: len(g.groups)})
+		case gr.node.ComputedStyle["display"] == "table-row":
+			// Rows directly inside of the table are a group of their own
+			g.rows = append(g.rows, row{node: gr.node, cells: cells(gr.node), group: len(g.groups)})
+			gr.node = nil
+		default:
+			for _, r := range gr.node.Children {
+				if r.ComputedStyle["display"] == "table-row" {
+					g.rows = append(g.rows, row{node: r, cells: cells(r), group: len(g.groups)})
+				}
+			}
+		}
+		gr.last = len(g.rows) - 1
+		g.groups = append(g.groups, gr)
+	}
+
+	// Cells go in the first column that isn't taken by a cell spanning down from a row above
+	taken := map[[2]int]bool{}
+	for r, rw := range g.rows {
+		col := 0
+		for _, v := range rw.cells {
+			for taken[[2]int{r, col}] {
+				col++
+			}
+			colSpan := span(v, "colspan")
+			// rowspan="0" spans to the end of the group
+			last := g.groups[rw.group].last
+			rowSpan := span(v, "rowspan")
+			if v.GetAttribute("rowspan") == "0" {
+				rowSpan = last - r + 1
+			}
+			rowSpan = min(rowSpan, last-r+1)
+			for i := r; i < r+rowSpan; i++ {
+				for j := col; j < col+colSpan; j++ {
+					taken[[2]int{i, j}] = true
+				}
+			}
+			g.cells = append(g.cells, &cell{node: v, row: r, col: col, rowSpan: rowSpan, colSpan: colSpan})
+			col += colSpan
+			g.columns = max(g.columns, col)
+		}
+	}
+	g.columns = max(g.columns, len(g.cols))
+	return g
+}
+
+// cells returns the cells of a row
+func cells(r *grim.Node) []*grim.Node {
+	out := []*grim.Node{}
+	for _, v := range r.Children {
+		if v.ComputedStyle["display"] == "table-cell" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// span reads a span attribute, it is at least 1
+func span(n *grim.Node, name string) int {
+	v, err := strconv.Atoi(strings.TrimSpace(n.GetAttribute(name)))
+	if err != nil || v < 1 {
+		return 1
+	}
+	return min(v, 1000)
+}
+
+// fixedWidths sizes the columns from the column elements and the first row, columns without a width share what is left
+func fixedWidths(g *grid, c *grim.CSS, space float32) []float32 {
+	widths := make([]float32, g.columns)
+	set := make([]bool, g.columns)
+	for i, col := range g.cols {
+		if w := col.ComputedStyle["width"]; w != "" && w != "auto" {
+			widths[i] = c.ConvertToPixels(w, c.State[col.Properties.Id].EM, space)
+			set[i] = true
+		}
+	}
+	for _, v := range g.cells {
+		if v.row != 0 {
+			continue
+		}
+		w := v.node.ComputedStyle["width"]
+		if w == "" || w == "auto" || set[v.col] {
+			continue
+		}
+		vState := c.State[v.node.Properties.Id]
+		total := c.ConvertToPixels(w, vState.EM, space) + vState.Padding.Left + vState.Padding.Right + vState.Border.Left.Width + vState.Border.Right.Width
+		for i := v.col; i < v.col+v.colSpan; i++ {
+			widths[i] = total / float32(v.colSpan)
+			set[i] = true
+		}
+	}
+
+	var used float32
+	free := 0
+	for i, w := range widths {
+		used += w
+		if !set[i] {
+			free++
+		}
+	}
+	if free > 0 {
+		for i := range widths {
+			if !set[i] {
+				widths[i] = grim.Max(0, (space-used)/float32(free))
+			}
+		}
+	} else if used < space && used > 0 {
+		// The table is wider than the columns, they grow by the same ratio
+		for i := range widths {
+			widths[i] *= space / used
+		}
+	}
+	return widths
+}
+
+// autoWidths sizes the columns between the min and max content widths of their cells, stretch grows the
+// columns to fill the space when the table has a width
+func autoWidths(g *grid, c *grim.CSS, space, hs float32, stretch bool) []float32 {
+	mins := make([]float32, g.columns)
+	maxs := make([]float32, g.columns)
+	for i, col := range g.cols {
+		if w := col.ComputedStyle["width"]; w != "" && w != "auto" {
+			maxs[i] = c.ConvertToPixels(w, c.State[col.Properties.Id].EM, space)
+		}
+	}
+
+	cellWidths := func(v *cell) (float32, float32) {
+		vState := c.State[v.node.Properties.Id]
+		extra := vState.Padding.Left + vState.Padding.Right + vState.Border.Left.Width + vState.Border.Right.Width
+		cMin, cMax := c.ContentWidths(v.node)
+		cMin, cMax = cMin+extra, cMax+extra
+		if w := v.node.ComputedStyle["width"]; w != "" && w != "auto" {
+			cMax = grim.Max(cMax, c.ConvertToPixels(w, vState.EM, space)+extra)
+		}
+		return cMin, grim.Max(cMin, cMax)
+	}
+
+	for _, v := range g.cells {
+		if v.colSpan == 1 {
+			cMin, cMax := cellWidths(v)
+			mins[v.col] = grim.Max(mins[v.col], cMin)
+			maxs[v.col] = grim.Max(maxs[v.col], cMax)
+		}
+	}
+	// Spanning cells grow the columns they span when they don't fit in them
+	for _, v := range g.cells {
+		if v.colSpan == 1 {
+			continue
+		}
+		cMin, cMax := cellWidths(v)
+		spanMin, spanMax := hs*float32(v.colSpan-1), hs*float32(v.colSpan-1)
+		for i := v.col; i < v.col+v.colSpan; i++ {
+			spanMin += mins[i]
+			spanMax += maxs[i]
+		}
+		for i := v.col; i < v.col+v.colSpan; i++ {
+			if cMin > spanMin {
+				mins[i] += (cMin - spanMin) / float32(v.colSpan)
+			}
+			if cMax > spanMax {
+				maxs[i] += (cMax - spanMax) / float32(v.colSpan)
+			}
+			maxs[i] = grim.Max(maxs[i], mins[i])
+		}
+	}
+
+	var sumMin, sumMax float32
+	for i := range mins {
+		sumMin += mins[i]
+		sumMax += maxs[i]
+	}
+	widths := make([]float32, g.columns)
+	switch {
+	case sumMax <= space:
+		copy(widths, maxs)
+		if stretch && space > sumMax {
+			for i := range widths {
+				if sumMax > 0 {
+					widths[i] += (space - sumMax) * maxs[i] / sumMax
+				} else {
+					widths[i] = space / float32(g.columns)
+				}
+			}
+		}
+	case sumMin < space:
+		// Every column gets its min and a part of what is left by how much more it wants
+		f := (space - sumMin) / (sumMax - sumMin)
+		for i := range widths {
+			widths[i] = mins[i] + (maxs[i]-mins[i])*f
+		}
+	default:
+		copy(widths, mins)
+	}
+	return widths
+}
+
+// rowHeights returns the height of each row, the tallest cell that only spans the row or the height of the row
+func rowHeights(g *grid, c *grim.CSS, vs, tableHeight float32) []float32 {
+	heights := make([]float32, len(g.rows))
+	for i, r := range g.rows {
+		if r.node != nil {
+			if h := r.node.ComputedStyle["height"]; h != "" && h != "auto" {
+				heights[i] = c.ConvertToPixels(h, c.State[r.node.Properties.Id].EM, tableHeight)
+			}
+		}
+	}
+	boxHeight := func(v *cell) float32 {
+		vState := c.State[v.node.Properties.Id]
+		h := vState.Height + vState.Border.Top.Width + vState.Border.Bottom.Width
+		if sh := v.node.ComputedStyle["height"]; sh != "" && sh != "auto" {
+			h = grim.Max(h, c.ConvertToPixels(sh, vState.EM, tableHeight)+vState.Padding.Top+vState.Padding.Bottom+vState.Border.Top.Width+vState.Border.Bottom.Width)
+		}
+		return h
+	}
+	for _, v := range g.cells {
+		if v.rowSpan == 1 {
+			heights[v.row] = grim.Max(heights[v.row], boxHeight(v))
+		}
+	}
+	// Cells that span rows make the last row they span taller if they don't fit
+	for _, v := range g.cells {
+		if v.rowSpan == 1 {
+			continue
+		}
+		h := vs * float32(v.rowSpan-1)
+		for _, rh := range heights[v.row : v.row+v.rowSpan] {
+			h += rh
+		}
+		if need := boxHeight(v) - h; need > 0 {
+			heights[v.row+v.rowSpan-1] += need
+		}
+	}
+	return heights
+}
+
+// collapseBorders gives each shared border to one of the cells next to it, the wider border wins. Borders
+// on the edge of the table are dropped when the table has its own border there
+func collapseBorders(g *grid, n *grim.Node, c *grim.CSS) {
+	at := map[[2]int]*cell{}
+	for _, v := range g.cells {
+		at[[2]int{v.row, v.col}] = v
+	}
+	table := c.State[n.Properties.Id]
+	for _, v := range g.cells {
+		vState := c.State[v.node.Properties.Id]
+		if right, ok := at[[2]int{v.row, v.col + v.colSpan}]; ok {
+			rState := c.State[right.node.Properties.Id]
+			if rState.Border.Left.Width > vState.Border.Right.Width {
+				vState.Border.Right.Width = 0
+			} else {
+				rState.Border.Left.Width = 0
+			}
+			c.State[right.node.Properties.Id] = rState
+		}
+		if below, ok := at[[2]int{v.row + v.rowSpan, v.col}]; ok {
+			bState := c.State[below.node.Properties.Id]
+			if bState.Border.Top.Width > vState.Border.Bottom.Width {
+				vState.Border.Bottom.Width = 0
+			} else {
+				bState.Border.Top.Width = 0
+			}
+			c.State[below.node.Properties.Id] = bState
+		}
+		if v.col == 0 && table.Border.Left.Width > 0 {
+			vState.Border.Left.Width = 0
+		}
+		if v.row == 0 && table.Border.Top.Width > 0 {
+			vState.Border.Top.Width = 0
+		}
+		if v.col+v.colSpan == g.columns && table.Border.Right.Width > 0 {
+			vState.Border.Right.Width = 0
+		}
+		if v.row+v.rowSpan == len(g.rows) && table.Border.Bottom.Width > 0 {
+			vState.Border.Bottom.Width = 0
+		}
+		c.State[v.node.Properties.Id] = vState
+	}
+}
+
+// fit sets the width of n (padding included) and lays out its content again, block children without a width follow it
+func fit(n *grim.Node, c *grim.CSS, width float32) {
+	self := c.State[n.Properties.Id]
+	dw := width - self.Width
+	self.Width = width
+	c.State[n.Properties.Id] = self
+
+	for _, v := range n.Children {
+		if isBlock(v) && v.ComputedStyle["width"] == "" {
+			fit(v, c, c.State[v.Properties.Id].Width+dw)
+		}
+	}
+	c.LayoutInline(n)
+
+	// Without inline content the block children are stacked again since their heights changed
+	self = c.State[n.Properties.Id]
+	if n.ComputedStyle["height"] == "" && !hasInline(n) {
+		y := self.Y + self.Border.Top.Width + self.Padding.Top
+		for _, v := range n.Children {
+			if !isBlock(v) {
+				continue
+			}
+			vState := c.State[v.Properties.Id]
+			move(v, c, 0, y+vState.Margin.Top-vState.Y)
+			y += vState.Margin.Top + vState.Height + vState.Border.Top.Width + vState.Border.Bottom.Width + vState.Margin.Bottom
+		}
+		if len(n.Children) > 0 {
+			self.Height = y - self.Y - self.Border.Top.Width + self.Padding.Bottom
+		}
+	}
+	c.State[n.Properties.Id] = self
+	c.DrawBorder(n)
+}
+
+func isBlock(n *grim.Node) bool {
+	pos := n.ComputedStyle["position"]
+	d := n.ComputedStyle["display"]
+	return d != "none" && d != "inline" && !strings.HasPrefix(d, "inline-") && pos != "absolute" && pos != "fixed" &&
+		n.TagName() != "grim-track"
+}
+
+func hasInline(n *grim.Node) bool {
+	if n.InnerText() != "" && len(n.Children) == 0 {
+		return true
+	}
+	for _, v := range n.Children {
+		if strings.HasPrefix(v.ComputedStyle["display"], "inline") || v.TagName() == "br" {
+			return true
+		}
+	}
+	return false
+}
+
+// placeCell moves a cell to x, y, makes it as tall as the rows it spans and moves its content by vertical-align
+func placeCell(n *grim.Node, c *grim.CSS, x, y, height float32) {
+	self := c.State[n.Properties.Id]
+	move(n, c, x-self.X, y-self.Y)
+	self = c.State[n.Properties.Id]
+
+	inner := height - self.Border.Top.Width - self.Border.Bottom.Width
+	// Cells inherit vertical-align from their row and row group in the master stylesheet
+	align := n.ComputedStyle["vertical-align"]
+	for p := n.Parent(); align == "inherit" && p != nil; p = p.Parent() {
+		align = p.ComputedStyle["vertical-align"]
+	}
+	var offset float32
+	switch align {
+	case "middle":
+		offset = (inner - self.Height) / 2
+	case "bottom":
+		offset = inner - self.Height
+	}
+	if offset > 0 {
+		for i := range self.Fragments {
+			self.Fragments[i].Y += offset
+		}
+		for _, v := range n.Children {
+			move(v, c, 0, offset)
+		}
+	}
+	self.Height = inner
+	c.State[n.Properties.Id] = self
+	c.DrawBorder(n)
+}
+
+// setBox sets the border box of a row or row group
+func setBox(n *grim.Node, c *grim.CSS, x, y, width, height float32) {
+	self := c.State[n.Properties.Id]
+	self.X, self.Y = x, y
+	self.Width = width - self.Border.Left.Width - self.Border.Right.Width
+	self.Height = height - self.Border.Top.Width - self.Border.Bottom.Width
+	c.State[n.Properties.Id] = self
+	c.DrawBorder(n)
+}
+
+// move moves a node and all of its children
+func move(n *grim.Node, c *grim.CSS, dx, dy float32) {
+	if dx == 0 && dy == 0 {
+		return
+	}
+	self := c.State[n.Properties.Id]
+	self.X += dx
+	self.Y += dy
+	c.State[n.Properties.Id] = self
+	for _, v := range n.Children {
+		move(v, c, dx, dy)
+	}
+}
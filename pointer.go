@@ -0,0 +1,323 @@
+package grim
+
+import (
+	"math"
+	"time"
+)
+
+// !DEVMAN: Pointer events come from the mouse and from the touches and pens the adapters send as pointerdown,
+// + pointermove, pointerup and pointercancel with a Pointer as the data. They go to the element on top under the
+// + pointer or to the element that has the pointer captured, a capture is released when the pointer goes up. The
+// + primary touch also moves the mouse (see open in main.go) so hover, click and focus work on touch screens.
+// + Two touches down at once make a pinch, a touch that moves far enough fast enough before it's lifted is a swipe,
+// + both are sent as events with a Pinch or Swipe as the data
+
+// MousePointer is the PointerId of the mouse, the adapters start the ids of touches and pens after it
+const MousePointer = 1
+
+// Pointer is a mouse, pen or touch, Type is "mouse", "pen" or "touch"
+type Pointer struct {
+	Id       int
+	Type     string
+	X        int
+	Y        int
+	Pressure float32
+}
+
+// Pinch is the data of the pinchstart, pinch and pinchend events, Scale is the distance between the touches
+// over the distance when the pinch started and X, Y is the point between them
+type Pinch struct {
+	Scale float32
+	X     float32
+	Y     float32
+}
+
+// Swipe is the data of the swipe event, Direction is "left", "right", "up" or "down" and Velocity is in pixels
+// per millisecond
+type Swipe struct {
+	Direction string
+	Distance  float32
+	Velocity  float32
+}
+
+// A swipe has to be at least swipeDistance pixels long and take less than swipeTime
+const (
+	swipeDistance = 50
+	swipeTime     = 500 * time.Millisecond
+)
+
+// pointerState is a pointer that's down
+type pointerState struct {
+	Pointer
+	primary bool
+	// target is the element the pointer went down on, the pointer started at startX, startY at start
+	target         *Node
+	startX, startY int
+	start          time.Time
+	// pinched pointers were part of a pinch and can't swipe
+	pinched bool
+}
+
+type pointerInput struct {
+	name  string
+	state *pointerState
+}
+
+type pinchState struct {
+	a, b     *pointerState
+	distance float32
+	target   *Node
+}
+
+// queuePointer keeps track of the pointers and queues the event for RunEvents, it returns true for the primary pointer
+func (m *Monitor) queuePointer(name string, p Pointer) bool {
+	if m.pointers == nil {
+		m.pointers = map[int]*pointerState{}
+	}
+	st, ok := m.pointers[p.Id]
+	if !ok {
+		// The mouse is always primary, the first touch or pen down is the primary one until it goes up
+		primary := true
+		if p.Type != "mouse" {
+			for _, v := range m.pointers {
+				if v.Type == p.Type && v.primary {
+					primary = false
+				}
+			}
+		}
+		st = &pointerState{primary: primary, startX: p.X, startY: p.Y, start: time.Now()}
+		if name == "pointerdown" {
+			m.pointers[p.Id] = st
+		}
+	}
+	st.Pointer = p
+	if name == "pointerup" || name == "pointercancel" {
+		delete(m.pointers, p.Id)
+	}
+
+	// The other events get a copy so they have the position the pointer had when they were sent, pointerdown
+	// gets the state that stays in m.pointers so its target can be set
+	send := st
+	if name != "pointerdown" {
+		c := *st
+		send = &c
+	}
+	m.pointerQueue = append(m.pointerQueue, pointerInput{name: name, state: send})
+	return st.primary
+}
+
+// mousePointer is the pointer for the mouse in data
+func mousePointer(data *EventData) Pointer {
+	p := Pointer{Id: MousePointer, Type: "mouse", X: data.Position[0], Y: data.Position[1]}
+	if data.Click {
+		p.Pressure = 0.5
+	}
+	return p
+}
+
+func pointerEvent(name string, st *pointerState) Event {
+	e := Event{
+		Name:        name,
+		Bubbles:     true,
+		Cancelable:  name == "pointerdown" || name == "pointermove" || name == "pointerup",
+		X:           st.X,
+		Y:           st.Y,
+		PointerId:   st.Id,
+		PointerType: st.Type,
+		Pressure:    st.Pressure,
+		IsPrimary:   st.primary,
+	}
+	// Moving doesn't change a button
+	if name == "pointermove" {
+		e.Button = -1
+	}
+	return e
+}
+
+// dispatchPointers sends the queued pointer events and the gestures they make
+func (m *Monitor) dispatchPointers(nodes []*Node) {
+	if m.captures == nil {
+		m.captures = map[int]*Node{}
+	}
+	queue := m.pointerQueue
+	m.pointerQueue = nil
+	for _, in := range queue {
+		st := in.state
+
+		// The capture events are sent when the element with the capture changes
+		c := capturing(nodes, st.Id)
+		if old := m.captures[st.Id]; c != old {
+			if old != nil {
+				old.DispatchEvent(pointerEvent("lostpointercapture", st))
+			}
+			if c != nil {
+				c.DispatchEvent(pointerEvent("gotpointercapture", st))
+			}
+			m.captures[st.Id] = c
+		}
+
+		target := c
+		if target == nil {
+			target = hitTest(nodes, m.CSS.State, float32(st.X), float32(st.Y))
+		}
+		if target == nil {
+			target = nodes[0]
+		}
+		if in.name == "pointerdown" {
+			st.target = target
+		}
+		target.DispatchEvent(pointerEvent(in.name, st))
+
+		if in.name == "pointerup" || in.name == "pointercancel" {
+			if c != nil {
+				delete(c.pointerCapture, st.Id)
+				c.DispatchEvent(pointerEvent("lostpointercapture", st))
+			}
+			delete(m.captures, st.Id)
+		}
+
+		m.gestures(in)
+	}
+}
+
+// gestures sends the pinch and swipe events
+func (m *Monitor) gestures(in pointerInput) {
+	st := in.state
+	if st.Type == "mouse" {
+		return
+	}
+
+	if p := m.pinch; p != nil && (p.a.Id == st.Id || p.b.Id == st.Id) {
+		if p.a.Id == st.Id {
+			p.a.Pointer = st.Pointer
+		} else {
+			p.b.Pointer = st.Pointer
+		}
+		switch in.name {
+		case "pointermove":
+			p.target.DispatchEvent(p.event("pinch"))
+		case "pointerup", "pointercancel":
+			p.target.DispatchEvent(p.event("pinchend"))
+			m.pinch = nil
+		}
+		return
+	}
+
+	switch in.name {
+	case "pointerdown":
+		if st.Type != "touch" || m.pinch != nil {
+			return
+		}
+		for _, v := range m.pointers {
+			if v != st && v.Type == "touch" && v.target != nil {
+				v.pinched, st.pinched = true, true
+				m.pinch = &pinchState{a: v, b: st, target: commonAncestor(v.target, st.target)}
+				m.pinch.distance = m.pinch.span()
+				m.pinch.target.DispatchEvent(m.pinch.event("pinchstart"))
+				return
+			}
+		}
+	case "pointerup":
+		if st.pinched || st.target == nil {
+			return
+		}
+		dx, dy := float32(st.X-st.startX), float32(st.Y-st.startY)
+		distance := float32(math.Hypot(float64(dx), float64(dy)))
+		elapsed := time.Since(st.start)
+		if distance < swipeDistance || elapsed > swipeTime {
+			return
+		}
+		s := Swipe{Distance: distance, Velocity: distance / Max(1, float32(elapsed.Milliseconds()))}
+		switch {
+		case math.Abs(float64(dx)) > math.Abs(float64(dy)) && dx > 0:
+			s.Direction = "right"
+		case math.Abs(float64(dx)) > math.Abs(float64(dy)):
+			s.Direction = "left"
+		case dy > 0:
+			s.Direction = "down"
+		default:
+			s.Direction = "up"
+		}
+		st.target.DispatchEvent(Event{Name: "swipe", Bubbles: true, X: st.X, Y: st.Y, PointerId: st.Id, PointerType: st.Type, Data: s})
+	}
+}
+
+func (p *pinchState) span() float32 {
+	return float32(math.Hypot(float64(p.a.X-p.b.X), float64(p.a.Y-p.b.Y)))
+}
+
+func (p *pinchState) event(name string) Event {
+	pinch := Pinch{
+		Scale: 1,
+		X:     float32(p.a.X+p.b.X) / 2,
+		Y:     float32(p.a.Y+p.b.Y) / 2,
+	}
+	if p.distance > 0 {
+		pinch.Scale = p.span() / p.distance
+	}
+	return Event{Name: name, Bubbles: true, X: int(pinch.X), Y: int(pinch.Y), PointerType: "touch", Data: pinch}
+}
+
+// commonAncestor returns the closest node both a and b are in
+func commonAncestor(a, b *Node) *Node {
+	for v := a; v != nil; v = v.parent {
+		for w := b; w != nil; w = w.parent {
+			if v == w {
+				return v
+			}
+		}
+	}
+	return a
+}
+
+// hitTest returns the element on top at x, y, elements later in the document are drawn over the ones before
+func hitTest(nodes []*Node, s map[string]State, x, y float32) *Node {
+	var hit *Node
+	var z float32
+	for _, v := range nodes {
+		self, ok := s[v.Properties.Id]
+		if ok && visibleAt(self, x, y) && (hit == nil || self.Z >= z) {
+			hit = v
+			z = self.Z
+		}
+	}
+	return hit
+}
+
+// capturing returns the node that has the pointer captured
+func capturing(nodes []*Node, id int) *Node {
+	for _, v := range nodes {
+		if v.pointerCapture[id] {
+			return v
+		}
+	}
+	return nil
+}
+
+// SetPointerCapture sends the events of the pointer to the node until it goes up or the capture is released
+func (n *Node) SetPointerCapture(id int) {
+	root := n
+	for root.parent != nil {
+		root = root.parent
+	}
+	releasePointer(root, id)
+	if n.pointerCapture == nil {
+		n.pointerCapture = map[int]bool{}
+	}
+	n.pointerCapture[id] = true
+}
+
+func (n *Node) ReleasePointerCapture(id int) {
+	delete(n.pointerCapture, id)
+}
+
+func (n *Node) HasPointerCapture(id int) bool {
+	return n.pointerCapture[id]
+}
+
+func releasePointer(n *Node, id int) {
+	delete(n.pointerCapture, id)
+	for _, v := range n.Children {
+		releasePointer(v, id)
+	}
+}
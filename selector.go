@@ -1,8 +1,11 @@
 package grim
 
 import (
+	"errors"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // !TODO: Make var() and :root (root is implide)
@@ -10,6 +13,7 @@ import (
 // !MAN,ELEMENT: QuerySelector is a Node method to select an element using a CSS selector
 // + [!MAN]Note: the CSS query is performed relative to the Node calling it
 // + [!MAN]Usage: element.Node.QuerySelector("css,query") -> element.Node
+// + [!MAN]Note: nil is returned when no element matches like GetElementById
 // + [!DEVMAN]Note: See TestSelector for query information
 func (n *Node) QuerySelector(selectString string) *Node {
 	m, _ := TestSelector(n, selectString)
@@ -19,13 +23,12 @@ func (n *Node) QuerySelector(selectString string) *Node {
 
 	for i := range n.Children {
 		el := n.Children[i]
-		cr := el.QuerySelector(selectString)
-		if cr.Properties.Id != "" {
+		if cr := el.QuerySelector(selectString); cr != nil {
 			return cr
 		}
 	}
 
-	return &Node{}
+	return nil
 }
 
 // !MAN,ELEMENT: QuerySelectorAll is a Node method to select all matching elements using a CSS selector
@@ -50,11 +53,135 @@ func (n *Node) QuerySelectorAll(selectString string) *[]*Node {
 	return &results
 }
 
+// !MAN,ELEMENT: Matches is a Node method to check if the element matches a CSS selector
+// + [!MAN]Usage: element.Node.Matches("css,query") -> bool, error
+// + [!MAN]Note: a error is returned for a invalid selector and for one that ends in a pseudo element (p::before)
+func (n *Node) Matches(selector string) (bool, error) {
+	l, err := elementSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	m, _ := l.match(n, nil)
+	return m, nil
+}
+
+// !MAN,ELEMENT: Closest is a Node method to find the element or the closest element it's in that matches a CSS selector
+// + [!MAN]Usage: element.Node.Closest("css,query") -> element.Node, error
+// + [!MAN]Note: nil is returned when no element matches and a error for a invalid selector
+func (n *Node) Closest(selector string) (*Node, error) {
+	l, err := elementSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	for v := n; v != nil; v = elementParent(v) {
+		if m, _ := l.match(v, nil); m {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// elementSelector compiles a selector for Matches and Closest, a selector that ends in a pseudo element can only
+// match the pseudo element and not the element so it's a error
+func elementSelector(selector string) (*selectorList, error) {
+	l := compileSelector(selector)
+	if l.err != nil {
+		return nil, l.err
+	}
+	for i := range l.complexes {
+		if l.complexes[i].endsInPseudoElement() {
+			return nil, errors.New("invalid selector: " + selector + " ends in a pseudo element")
+		}
+	}
+	return l, nil
+}
+
+// !MAN,ELEMENT: GetElementById is a Node method to find the element with the id in the elements inside of it
+// + [!MAN]Usage: element.Node.GetElementById("id") -> element.Node
+// + [!MAN]Note: nil is returned when there isn't one
+func (n *Node) GetElementById(id string) *Node {
+	for _, v := range n.Children {
+		if v.id == id {
+			return v
+		}
+		if f := v.GetElementById(id); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// !MAN,ELEMENT: GetElementsByClassName is a Node method to get the elements inside of it that have all of the classes
+// + [!MAN]Usage: element.Node.GetElementsByClassName("class names") -> element.HTMLCollection
+// + [!MAN]Note: the collection is live, it changes with the document
+func (n *Node) GetElementsByClassName(names string) HTMLCollection {
+	classes := strings.Fields(names)
+	return HTMLCollection{root: n, filter: func(v *Node) bool {
+		for _, c := range classes {
+			if !slices.Contains(v.ClassList.classes, c) {
+				return false
+			}
+		}
+		return len(classes) > 0
+	}}
+}
+
+// !MAN,ELEMENT: GetElementsByTagName is a Node method to get the elements inside of it with the tag, * gets all of them
+// + [!MAN]Usage: element.Node.GetElementsByTagName("tag") -> element.HTMLCollection
+// + [!MAN]Note: the collection is live, it changes with the document
+func (n *Node) GetElementsByTagName(tag string) HTMLCollection {
+	return HTMLCollection{root: n, filter: func(v *Node) bool {
+		return tag == "*" || strings.EqualFold(v.tagName, tag)
+	}}
+}
+
+// HTMLCollection is a live list of the elements inside of a node that pass a filter, the elements are found again
+// each time it's read so it's never out of date
+type HTMLCollection struct {
+	root   *Node
+	filter func(*Node) bool
+}
+
+// Nodes returns the elements in the collection in document order
+func (c HTMLCollection) Nodes() []*Node {
+	nodes := []*Node{}
+	if c.root == nil {
+		return nodes
+	}
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, v := range n.Children {
+			if c.filter(v) {
+				nodes = append(nodes, v)
+			}
+			walk(v)
+		}
+	}
+	walk(c.root)
+	return nodes
+}
+
+// Len returns the number of elements in the collection
+func (c HTMLCollection) Len() int {
+	return len(c.Nodes())
+}
+
+// Item returns the element at the index or nil if it's out of range
+func (c HTMLCollection) Item(index int) *Node {
+	nodes := c.Nodes()
+	if index < 0 || index >= len(nodes) {
+		return nil
+	}
+	return nodes[index]
+}
+
 type SelectorParts struct {
 	TagName   string
 	Id        string
 	ClassList []string
-	Attribute map[string]string
+	// Attribute has the attributes matched by name or with =, Attributes has all of them with their operators
+	Attribute  map[string]string
+	Attributes []AttributeSelector
 }
 
 func splitSelector(selector string, key rune) []string {
@@ -112,14 +239,20 @@ func splitSelector(selector string, key rune) []string {
 
 // nthChildMatch checks if the given index matches the nth-child pattern.
 func NthChildMatch(pattern string, index int) bool {
+	a, b, ok := parseNth(pattern)
+	return ok && nthMatch(a, b, index)
+}
+
+// parseNth parses a An+B pattern (odd, even, 3, 2n+1, -n+3)
+func parseNth(pattern string) (int, int, bool) {
 	pattern = strings.ReplaceAll(pattern, " ", "")
 	// Handle special cases for "odd" and "even"
 	lowerPattern := strings.ToLower(strings.TrimSpace(pattern))
 	if lowerPattern == "odd" {
-		return index%2 == 1
+		return 2, 1, true
 	}
 	if lowerPattern == "even" {
-		return index%2 == 0
+		return 2, 0, true
 	}
 
 	// Coefficients for "an+b"
@@ -129,7 +262,7 @@ func NthChildMatch(pattern string, index int) bool {
 	// Parse pattern with 'n'
 	if nIndex != -1 {
 		// Parse coefficient of "n" (before 'n')
-		if nIndex == 0 || lowerPattern[0] == '+' {
+		if nIndex == 0 || (lowerPattern[0] == '+' && nIndex == 1) {
 			a = 1
 		} else if lowerPattern[0] == '-' && nIndex == 1 {
 			a = -1
@@ -137,231 +270,234 @@ func NthChildMatch(pattern string, index int) bool {
 			var err error
 			a, err = strconv.Atoi(lowerPattern[:nIndex])
 			if err != nil {
-				return false
+				return 0, 0, false
 			}
 		}
 
 		// Parse constant term (after 'n')
 		if nIndex+1 < len(lowerPattern) {
-			b, _ = strconv.Atoi(lowerPattern[nIndex+1:])
+			var err error
+			b, err = strconv.Atoi(lowerPattern[nIndex+1:])
+			if err != nil {
+				return 0, 0, false
+			}
 		}
 	} else {
 		// Handle single integer patterns like "3"
 		var err error
 		b, err = strconv.Atoi(lowerPattern)
 		if err != nil {
-			return false
+			return 0, 0, false
 		}
 	}
+	return a, b, true
+}
 
-	// Check if index matches the formula a*n + b
+// nthMatch reports if the 1 based index is a*n + b for a n of 0 or more
+func nthMatch(a, b, index int) bool {
 	if a == 0 {
 		return index == b
 	}
 	return (index-b)%a == 0 && (index-b)/a >= 0
 }
 
-// !ISSUE: :where doesn't work and h1+h1 is propagating to all elements on superselector
-// + :where selects properly but the margin doesn't...
-// + h1+h1 selects all but should only select the last two...
-
+// TestSelector reports if the node matches the selector and if the selector ends in a pseudo element (::before),
+// the selector is compiled the first time it's used (see matcher.go)
 func TestSelector(n *Node, selector string) (bool, bool) {
-	selectors := splitSelector(selector, ',')
+	if selector == ":" {
+		return true, true
+	}
+	return compileSelector(selector).match(n, nil)
+}
+
+// splitComplex splits a selector into its compound selectors and the combinators between them, a space is the
+// descendant combinator
+func splitComplex(selector string) ([]string, []byte) {
+	var compounds []string
+	var combinators []byte
+	var current strings.Builder
+	nesting := 0
+	var quote rune
+	combinator := byte(0)
 
-	if selector[0] == ':' && len(selectors) == 1 {
-		if selector == ":" {
-			return true, true
+	flush := func() {
+		if current.Len() == 0 {
+			return
 		}
-		if len(selector) >= 5 && selector[0:5] == ":has(" {
-			m := false
-			for _, v := range n.Children {
-				m, _ = TestSelector(v, selector[5:len(selector)-1])
-				if m {
-					break
-				}
+		if len(compounds) > 0 {
+			if combinator == 0 {
+				combinator = ' '
 			}
-			return m, false
-		} else if len(selector) >= 7 && selector[0:7] == ":where(" {
-			m, _ := TestSelector(n, selector[7:len(selector)-1])
-			return m, false
-		} else if len(selector) >= 4 && selector[0:4] == ":is(" {
-			m, _ := TestSelector(n, selector[4:len(selector)-1])
-			return m, false
-		} else if len(selector) >= 5 && selector[0:5] == ":not(" {
-			m, _ := TestSelector(n, selector[5:len(selector)-1])
-			return !m, false
-		} else if len(selector) >= 11 && selector[0:11] == ":nth-child(" {
-			index := 0
-			if n.parent != nil {
-				for _, v := range n.parent.Children {
-					index++
-					if v.Properties.Id == n.Properties.Id {
-						break
-					}
-				}
-			}
-			m := NthChildMatch(selector[11:len(selector)-1], index)
-			return m, false
-		} else if selector == ":required" {
-			return n.required, false
-		} else if selector == ":enabled" {
-			return !n.disabled, false
-		} else if selector == ":disabled" {
-			return n.disabled, false
-		} else if selector == ":checked" {
-			return n.checked, false
-		} else if selector == ":focus" {
-			return n.focused, false
-		} else if selector == ":hover" {
-			return n.hovered, false
-		} else if selector == ":before" {
-			return true, true
-		} else if selector == ":after" {
-			return true, true
-		} else {
-			return false, false
+			combinators = append(combinators, combinator)
 		}
+		compounds = append(compounds, current.String())
+		current.Reset()
+		combinator = 0
 	}
 
-	has, isPsuedo := false, false
-	for _, s := range selectors {
-		directChildren := splitSelector(s, '>')
-		if len(directChildren) > 1 {
-			currentElement := n
-			match := true
-			for i := len(directChildren) - 1; i >= 0; i-- {
-				m, _ := TestSelector(currentElement, directChildren[i])
-				if !m {
-					match = false
-				}
-				currentElement = currentElement.parent
+	for _, r := range strings.TrimSpace(selector) {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
 			}
-			has = match
-			break
+			current.WriteRune(r)
+			continue
 		}
-		for _, dc := range directChildren {
-			adjacentSiblings := splitSelector(dc, '+')
-			if len(adjacentSiblings) > 1 {
-				for i, v := range n.parent.Children {
-					if v.Properties.Id == n.Properties.Id {
-						// Make sure the current element matches the last selector
-						m, _ := TestSelector(n, adjacentSiblings[len(adjacentSiblings)-1])
-						if i >= len(adjacentSiblings)-1 && m {
-							match := true
-							// Skip the first selector, its been matched to the current node
-							adjIndex := len(adjacentSiblings) - 2
-							for j := i - 1; j >= i-len(adjacentSiblings)+1; j-- {
-								sm, _ := TestSelector(n.parent.Children[j], adjacentSiblings[adjIndex])
-								if !sm {
-									match = false
-									break
-								}
-								adjIndex--
-							}
-							has = match
-						}
-
-						break
-					}
-				}
-
-				break
-			}
-			for _, as := range adjacentSiblings {
-				generalSiblings := splitSelector(as, '~')
-				if len(generalSiblings) > 1 {
-					match := false
-					for _, sel := range generalSiblings {
-						for i := 0; i < len(n.parent.Children); i++ {
-							v := n.parent.Children[i]
-							m, _ := TestSelector(v, sel)
-							if m {
-								if match {
-									has, isPsuedo = true, false
-									break
-								}
-								match = true
-								break
-							}
-						}
-						if !match {
-							break
-						}
-					}
-					has = match
-					break
-				}
-				for _, gs := range generalSiblings {
-					descendants := splitSelector(gs, ' ')
-					if len(descendants) > 1 {
-						// !DEVMAN,ELEMENT,TESTSELECTOR: Descendants selector checks for any parent to have
-						// + the next tag, but doesn't check the main tag if it did and the main didn't
-						// + have the selector then it would move up. So instead the main tag is skipped
-						// + until the next check.
-						currentElement := n.parent
-						match := false
-						for i := len(descendants) - 1; i > 0; i-- {
-							m := false
-							for currentElement.parent != nil && !m {
-								m, _ = TestSelector(currentElement, descendants[i])
-								if m {
-									match = m
-									break
-								}
-								currentElement = currentElement.parent
-							}
-
-							if !m {
-								match = false
-								break
-							}
-						}
-						if !match {
-							break
-						}
-						has = match
-						// A break is not inserted here because of the main element check
-					}
-					for _, d := range descendants {
-						computeAble := splitSelector(d, ':')
-						if len(computeAble) == 0 {
-							continue
-						}
-						baseNode := computeAble[0]
-						if len(computeAble) > 1 {
-							match := true
-							for _, v := range computeAble[1:] {
-								m, p := TestSelector(n, ":"+v)
-								isPsuedo = p
-								if !m {
-									match = false
-								} else if len(v) > 10 && v[0:10] == "nth-child(" {
-									has = CompareSelector(baseNode, n)
-									break
-								}
-							}
-							// !ISSUE: Why is this here??? has = is never reached above
-							has = match
-						}
-						if has || !(len(computeAble) > 1) {
-							m := CompareSelector(baseNode, n)
-							has = m
-						}
-					}
-				}
+		switch {
+		case r == '"' || r == '\'':
+			quote = r
+			current.WriteRune(r)
+		case r == '(' || r == '[':
+			nesting++
+			current.WriteRune(r)
+		case r == ')' || r == ']':
+			if nesting > 0 {
+				nesting--
 			}
+			current.WriteRune(r)
+		case nesting == 0 && (r == '>' || r == '+' || r == '~'):
+			flush()
+			combinator = byte(r)
+		case nesting == 0 && unicode.IsSpace(r):
+			flush()
+		default:
+			current.WriteRune(r)
 		}
-		if s == ":first-child" {
-			has = n.parent.Children[0].Properties.Id == n.Properties.Id
-		} else if s == ":last-child" {
-			has = n.parent.Children[len(n.parent.Children)-1].Properties.Id == n.Properties.Id
+	}
+	flush()
+	return compounds, combinators
+}
+
+// elementParent returns the parent of the node, the document (ROOT) isn't a element so nil is returned for it
+func elementParent(n *Node) *Node {
+	if n.parent == nil || n.parent.tagName == "ROOT" {
+		return nil
+	}
+	return n.parent
+}
+
+// childIndex returns the index of the node in the children of its parent, -1 if it doesn't have a parent
+func childIndex(n *Node) int {
+	if n.parent == nil {
+		return -1
+	}
+	for i, v := range n.parent.Children {
+		if v == n || (v.Properties.Id != "" && v.Properties.Id == n.Properties.Id) {
+			return i
+		}
+	}
+	return -1
+}
+
+func previousSibling(n *Node) *Node {
+	i := childIndex(n)
+	if i < 1 {
+		return nil
+	}
+	return n.parent.Children[i-1]
+}
+
+func nextSibling(n *Node) *Node {
+	i := childIndex(n)
+	if i < 0 || i+1 >= len(n.parent.Children) {
+		return nil
+	}
+	return n.parent.Children[i+1]
+}
+
+// siblingIndex returns the 1 based position of the node in its siblings that pass count (all when it's nil), from
+// the end when last is true. A node without a parent is the first and last child
+func siblingIndex(n *Node, last bool, count func(*Node) bool) int {
+	i := childIndex(n)
+	if i < 0 {
+		return 1
+	}
+	siblings := n.parent.Children
+	index := 0
+	for j := range siblings {
+		if last {
+			j = len(siblings) - 1 - j
 		}
-		if has {
-			return true, isPsuedo
+		v := siblings[j]
+		if count == nil || count(v) {
+			index++
+		}
+		if v == n || j == i {
+			break
 		}
 	}
+	return index
+}
+
+// sameType returns a count for siblingIndex that counts the elements with the tag of the node
+func sameType(n *Node) func(*Node) bool {
+	return func(v *Node) bool {
+		return v.tagName == n.tagName
+	}
+}
+
+// AttributeSelector is a attribute in a selector, Operator is "" when only the name is given ([disabled]) or one of
+// =, ~=, |=, ^=, $= and *=. Insensitive is set by the i flag ([type="a" i])
+type AttributeSelector struct {
+	Name        string
+	Operator    string
+	Value       string
+	Insensitive bool
+}
+
+// Match reports if the node has the attribute with a value that matches
+func (a AttributeSelector) Match(n *Node) bool {
+	value, ok := attributeValue(n, a.Name)
+	if !ok {
+		return false
+	}
+	want := a.Value
+	if a.Insensitive {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+	switch a.Operator {
+	case "":
+		return true
+	case "=":
+		return value == want
+	case "~=":
+		return want != "" && slices.Contains(strings.Fields(value), want)
+	case "|=":
+		return value == want || strings.HasPrefix(value, want+"-")
+	case "^=":
+		return want != "" && strings.HasPrefix(value, want)
+	case "$=":
+		return want != "" && strings.HasSuffix(value, want)
+	case "*=":
+		return want != "" && strings.Contains(value, want)
+	}
+	return false
+}
 
-	return has, isPsuedo
+// attributeValue returns the value of the attribute and if the node has it, the attributes createNode keeps in
+// the fields of the node are read from them
+func attributeValue(n *Node, name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "id":
+		return n.id, n.id != ""
+	case "class":
+		classes := n.ClassList.Classes()
+		return strings.Join(classes, " "), len(classes) > 0
+	case "href":
+		return n.href, n.href != ""
+	case "src":
+		return n.src, n.src != ""
+	case "title":
+		return n.title, n.title != ""
+	case "disabled":
+		return "", n.disabled
+	case "required":
+		return "", n.required
+	case "checked":
+		return "", n.checked
+	}
+	v, ok := n.attribute[name]
+	return v, ok
 }
 
 func ParseSelector(selector string) SelectorParts {
@@ -408,18 +544,30 @@ func ParseSelector(selector string) SelectorParts {
 		case '[': // Attribute
 			i++ // Skip [
 			start = i
-			var attrName string
-			var attrValue string
+			attr := AttributeSelector{}
 
 			// Parse attribute name
-			for i < length && selector[i] != '=' && selector[i] != ']' {
+			for i < length && !strings.ContainsRune("=]^$*~|", rune(selector[i])) {
 				i++
 			}
-			attrName = strings.TrimSpace(selector[start:i])
+			attr.Name = strings.TrimSpace(selector[start:i])
+
+			// Parse the operator, = can have a ^, $, *, ~ or | before it
+			if i < length && selector[i] != ']' {
+				if selector[i] == '=' {
+					attr.Operator = "="
+					i++
+				} else if i+1 < length && selector[i+1] == '=' {
+					attr.Operator = selector[i : i+2]
+					i += 2
+				}
+			}
 
 			// Parse attribute value if present
-			if i < length && selector[i] == '=' {
-				i++ // Skip =
+			if attr.Operator != "" {
+				for i < length && selector[i] == ' ' {
+					i++
+				}
 				// Skip quotes if present
 				if i < length && (selector[i] == '"' || selector[i] == '\'') {
 					quote := selector[i]
@@ -428,28 +576,36 @@ func ParseSelector(selector string) SelectorParts {
 					for i < length && selector[i] != quote {
 						i++
 					}
-					attrValue = selector[start:i]
+					attr.Value = selector[start:i]
 					if i < length {
 						i++ // Skip closing quote
 					}
 				} else {
 					start = i
-					for i < length && selector[i] != ']' {
+					for i < length && selector[i] != ']' && selector[i] != ' ' {
 						i++
 					}
-					attrValue = strings.TrimSpace(selector[start:i])
+					attr.Value = strings.TrimSpace(selector[start:i])
 				}
 			}
 
-			// Skip to closing bracket
+			// The flag is after the value, i compares the value without case and s with case (the default)
+			start = i
 			for i < length && selector[i] != ']' {
 				i++
 			}
+			attr.Insensitive = strings.EqualFold(strings.TrimSpace(selector[start:i]), "i")
 			if i < length {
 				i++ // Skip ]
 			}
 
-			parts.Attribute[attrName] = attrValue
+			if attr.Name == "" {
+				continue
+			}
+			parts.Attributes = append(parts.Attributes, attr)
+			if (attr.Operator == "" || attr.Operator == "=") && !attr.Insensitive {
+				parts.Attribute[attr.Name] = attr.Value
+			}
 
 		default:
 			i++ // Skip any other character
@@ -471,109 +627,8 @@ func isValidIdClassChar(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-'
 }
 
-func CompareSelector(selector string, n *Node) bool {
-	baseParts := ParseSelector(selector)
-	has := false
-	if selector == "*" {
-		return true
-	}
-	if baseParts.Id == n.id || baseParts.Id == "" {
-		if baseParts.TagName == n.tagName || baseParts.TagName == "" {
-			match := true
-			for _, v := range baseParts.ClassList {
-				bpc := false
-				for _, c := range n.ClassList.Classes() {
-					if v == c {
-						bpc = true
-					}
-				}
-
-				if !bpc {
-					match = false
-				}
-			}
-
-			for k, v := range baseParts.Attribute {
-				if n.attribute[k] != v {
-					match = false
-				}
-			}
-
-			if match {
-				has = true
-			}
-		}
-	}
-	return has
-}
-
-func ExtractBaseElements(selector string) [][]string {
-	var baseElements []string
-
-	selectors := splitSelector(selector, ',')
-	for _, s := range selectors {
-		directChild := splitSelector(s, '>')
-		adjacentSibling := splitSelector(directChild[len(directChild)-1], '+')
-		generalSiblings := splitSelector(adjacentSibling[len(adjacentSibling)-1], '~')
-		descendants := splitSelector(generalSiblings[len(generalSiblings)-1], ' ')
-		for _, d := range descendants {
-			computeAble := splitSelector(d, ':')
-
-			// Add valid base elements to the list
-			if len(computeAble) > 0 && computeAble[0] != "" {
-				baseElements = append(baseElements, computeAble[0])
-			}
-		}
-
-	}
-
-	baseParts := [][]string{}
-
-	computeAble := splitSelector(selector, ':')
-
-	for _, v := range computeAble {
-		if strings.Contains(v, "(") {
-			be := ExtractBaseElements(v[strings.Index(v, "(")+1 : len(v)-2])
-			for _, b := range be {
-				baseElements = append(baseElements, b...)
-			}
-		}
-	}
-
-	for _, v := range baseElements {
-		ps := ParseSelector(v)
-		selectors := []string{}
-		selectors = append(selectors, ps.TagName)
-		if ps.Id != "" {
-			selectors = append(selectors, "#"+ps.Id)
-		}
-
-		for _, c := range ps.ClassList {
-			selectors = append(selectors, "."+c)
-		}
-
-		for k, v := range ps.Attribute {
-			selectors = append(selectors, `[`+k+`="`+v+`"]`)
-		}
-
-		baseParts = append(baseParts, selectors)
-	}
-	baseParts = append(baseParts, []string{"*"})
-	return baseParts
-}
-
-func ShouldTestSelector(n *Node, selector string) bool {
-	baseElements := ExtractBaseElements(selector)
-	for _, base := range baseElements {
-		if CompareSelector(strings.Join(base, ""), n) {
-			return true
-		}
-	}
-	return false
-}
-
 func GenBaseElements(n *Node) []string {
-	selectors := []string{}
+	selectors := []string{"*"}
 	selectors = append(selectors, n.tagName)
 	if n.id != "" {
 		selectors = append(selectors, "#"+n.id)
@@ -583,8 +638,13 @@ func GenBaseElements(n *Node) []string {
 		selectors = append(selectors, "."+c)
 	}
 
-	for k, v := range n.attribute {
-		selectors = append(selectors, `[`+k+`="`+v+`"]`)
+	for k := range n.attribute {
+		selectors = append(selectors, `[`+k+`]`)
+	}
+	for _, k := range []string{"id", "class", "href", "src", "title", "disabled", "required", "checked"} {
+		if _, ok := attributeValue(n, k); ok {
+			selectors = append(selectors, `[`+k+`]`)
+		}
 	}
 
 	return selectors
@@ -0,0 +1,124 @@
+package grim
+
+import "testing"
+
+// queryDocument builds html > body > (div#main.box > (p.a.b > span, p.a), ul > li * 2)
+func queryDocument() (body, main, p1, span *Node) {
+	body = el(el(newRoot(), "html"), "body")
+	main = el(body, "div", "box")
+	main.id = "main"
+	p1 = el(main, "p", "a", "b")
+	span = el(p1, "span")
+	el(main, "p", "a")
+	ul := el(body, "ul")
+	el(ul, "li")
+	el(ul, "li").id = "last"
+	return body, main, p1, span
+}
+
+func TestMatches(t *testing.T) {
+	_, _, p1, span := queryDocument()
+	for _, tt := range []struct {
+		selector string
+		n        *Node
+		want     bool
+	}{
+		{"p.a.b", p1, true},
+		{"#main > p", p1, true},
+		{"#main > span", span, false},
+		{"div span, li", span, true},
+	} {
+		got, err := tt.n.Matches(tt.selector)
+		if err != nil || got != tt.want {
+			t.Errorf("Matches(%q) on %s = %v, %v, want %v", tt.selector, tt.n.Properties.Id, got, err, tt.want)
+		}
+	}
+
+	// A selector that ends in a pseudo element can't match the element, a colon needs a name after it
+	for _, selector := range []string{"p::before", "p:after", "span, p::first-line", "a:", "p a:", "p::", "[", "p >"} {
+		if _, err := p1.Matches(selector); err == nil {
+			t.Errorf("Matches(%q) didn't return a error", selector)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	body, main, p1, span := queryDocument()
+	for _, tt := range []struct {
+		selector string
+		want     *Node
+	}{
+		{"span", span},
+		{"p", p1},
+		{".box", main},
+		{"body > div", main},
+		{"ul", nil},
+	} {
+		got, err := span.Closest(tt.selector)
+		if err != nil || got != tt.want {
+			t.Errorf("Closest(%q) = %v, %v, want %v", tt.selector, got, err, tt.want)
+		}
+	}
+	if got, _ := body.Closest("html"); got == nil || got.tagName != "html" {
+		t.Errorf("Closest(\"html\") from the body = %v, want the html element", got)
+	}
+	if _, err := span.Closest("p::before"); err == nil {
+		t.Error("Closest(\"p::before\") didn't return a error")
+	}
+}
+
+func TestGetElementById(t *testing.T) {
+	body, main, _, _ := queryDocument()
+	if got := body.GetElementById("main"); got != main {
+		t.Errorf("GetElementById(\"main\") = %v, want the div", got)
+	}
+	if got := body.GetElementById("last"); got == nil || got.tagName != "li" {
+		t.Errorf("GetElementById(\"last\") = %v, want the second li", got)
+	}
+	// Only the elements inside of the node are searched
+	if got := main.GetElementById("main"); got != nil {
+		t.Errorf("GetElementById on the element with the id = %v, want nil", got)
+	}
+	// A miss is nil from both
+	if got := body.GetElementById("missing"); got != nil {
+		t.Errorf("GetElementById(\"missing\") = %v, want nil", got)
+	}
+	if got := body.QuerySelector("#missing"); got != nil {
+		t.Errorf("QuerySelector(\"#missing\") = %v, want nil", got)
+	}
+}
+
+func TestGetElementsBy(t *testing.T) {
+	body, main, p1, _ := queryDocument()
+
+	a := body.GetElementsByClassName("a")
+	if a.Len() != 2 || a.Item(0) != p1 {
+		t.Fatalf("GetElementsByClassName(\"a\") has %d elements, want 2 starting with the first p", a.Len())
+	}
+	if ab := body.GetElementsByClassName(" b  a "); ab.Len() != 1 || ab.Item(0) != p1 {
+		t.Errorf("GetElementsByClassName(\"b a\") has %d elements, want the first p", ab.Len())
+	}
+	if none := body.GetElementsByClassName(""); none.Len() != 0 {
+		t.Errorf("GetElementsByClassName(\"\") has %d elements, want 0", none.Len())
+	}
+	if a.Item(2) != nil || a.Item(-1) != nil {
+		t.Error("Item out of range didn't return nil")
+	}
+
+	// The collections are live
+	el(main, "section", "a")
+	if a.Len() != 3 {
+		t.Errorf("GetElementsByClassName(\"a\") has %d elements after one was added, want 3", a.Len())
+	}
+
+	if p := body.GetElementsByTagName("P"); p.Len() != 2 {
+		t.Errorf("GetElementsByTagName(\"P\") has %d elements, want 2", p.Len())
+	}
+	// The div, the two p, the span, the section, the ul and the two li are under the body
+	if all := body.GetElementsByTagName("*"); all.Len() != 8 {
+		t.Errorf("GetElementsByTagName(\"*\") has %d elements, want 8", all.Len())
+	}
+	if nodes := main.GetElementsByTagName("li").Nodes(); len(nodes) != 0 {
+		t.Errorf("GetElementsByTagName(\"li\") on the div has %d elements, want 0", len(nodes))
+	}
+}
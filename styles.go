@@ -23,27 +23,64 @@ func ConditionalStyleHandler(n *Node, newStyles map[string]string) {
 		}
 	}
 
-	// Then apply the conditional styles
-	if n.hovered && n.ConditionalStyles[":hover"] != nil {
-		for k, v := range n.ConditionalStyles[":hover"] {
-			n.ComputedStyle[k] = v
-			styles[k] = v
-		}
-	}
-	if n.focused && n.ConditionalStyles[":focus"] != nil {
-		for k, v := range n.ConditionalStyles[":focus"] {
-			n.ComputedStyle[k] = v
-			styles[k] = v
+	// Then apply the conditional styles in the order of the style sheets
+	for _, r := range n.conditionalRules {
+		if n.inState(r.state) {
+			for k, v := range r.styles {
+				n.ComputedStyle[k] = v
+				styles[k] = v
+			}
 		}
 	}
 
-	if n.ConditionalStyles[":hover"] != nil || n.ConditionalStyles[":focus"] != nil {
+	if n.ConditionalStyles[":hover"] != nil || n.ConditionalStyles[":active"] != nil || n.ConditionalStyles[":focus"] != nil || n.ConditionalStyles[":focus-visible"] != nil || n.ConditionalStyles[":focus-within"] != nil {
 		for _, v := range n.Children {
 			ConditionalStyleHandler(v, styles)
 		}
 	}
 }
 
+// conditionalRule is a rule that only applies while the node is in a state (:hover, :focus...). The rules are
+// kept in the order of the style sheets so a later rule wins over a earlier one whatever state it is for, like
+// input[readonly]:focus over :focus-visible
+type conditionalRule struct {
+	state  string
+	styles map[string]string
+}
+
+// inState reports if the node is in the state of a conditional rule
+func (n *Node) inState(state string) bool {
+	switch state {
+	case ":hover":
+		return n.hovered
+	case ":active":
+		return n.active
+	case ":focus":
+		return n.focused
+	case ":focus-visible":
+		return n.focusVisible
+	case ":focus-within":
+		return n.focusWithin
+	}
+	return false
+}
+
+// addConditional adds the styles of a rule that matched the node in the state
+func addConditional(conditionalStyles map[string]map[string]string, rules *[]conditionalRule, state string, m *StyleMap) {
+	if conditionalStyles[state] == nil {
+		conditionalStyles[state] = map[string]string{}
+	}
+	r := conditionalRule{state: state, styles: map[string]string{}}
+	for k, v := range *m.Styles {
+		if v == "" {
+			continue
+		}
+		conditionalStyles[state][k] = v
+		r.styles[k] = v
+	}
+	*rules = append(*rules, r)
+}
+
 type Styles struct {
 	StyleMap     map[string][]*StyleMap
 	PsuedoStyles map[string]map[string]map[string]string
@@ -69,6 +106,11 @@ func (s Styles) StyleTag(css string) {
 // + kinda see that note for a complete list
 
 func (s Styles) GetStyles(n *Node) {
+	s.getStyles(n, ancestorsFilter(n))
+}
+
+// getStyles is GetStyles with the bloom filter of the ancestors of n, createNode builds it as it walks down the tree
+func (s Styles) getStyles(n *Node, filter *ancestorFilter) {
 	if strings.Contains(n.Properties.Id, "head") {
 		return
 	}
@@ -76,6 +118,7 @@ func (s Styles) GetStyles(n *Node) {
 	styles := make(map[string]string)
 	pseudoStyles := make(map[string]map[string]string)
 	conditionalStyles := make(map[string]map[string]string)
+	conditionalRules := []conditionalRule{}
 
 	// Inherit styles from parent
 	if n.parent != nil {
@@ -87,24 +130,37 @@ func (s Styles) GetStyles(n *Node) {
 		}
 	}
 
+	// The dir attribute is a presentational hint so the style sheets can override it
+	switch dir := strings.ToLower(n.GetAttribute("dir")); dir {
+	case "ltr", "rtl", "auto":
+		styles["direction"] = dir
+		styles["unicode-bidi"] = "isolate"
+	}
+
 	baseSelectors := GenBaseElements(n)
-	testedSelectors := map[string]bool{}
 
 	// !DEVMAN: You need to pre-sort the selectors by their .Sheet field to create the
-	// + cascading effect of CSS
+	// + cascading effect of CSS, a selector list can be in more than one of the buckets of the node
 
 	styleMaps := []*StyleMap{}
+	seen := map[*StyleMap]bool{}
 	for _, v := range baseSelectors {
-		sm := s.StyleMap[v]
-		styleMaps = append(styleMaps, sm...)
+		for _, sm := range s.StyleMap[v] {
+			if !seen[sm] {
+				seen[sm] = true
+				styleMaps = append(styleMaps, sm)
+			}
+		}
 	}
 	sort.Slice(styleMaps, func(i, j int) bool {
-		return styleMaps[i].Sheet < styleMaps[j].Sheet
+		if styleMaps[i].Sheet != styleMaps[j].Sheet {
+			return styleMaps[i].Sheet < styleMaps[j].Sheet
+		}
+		return styleMaps[i].order < styleMaps[j].order
 	})
 	for _, m := range styleMaps {
-		if ShouldTestSelector(n, m.Selector) {
-			testedSelectors[m.Selector] = true
-			match, isPseudo := TestSelector(n, m.Selector)
+		if m.matcher().mayMatch(n, filter) {
+			match, isPseudo := m.test(n, filter)
 			if match {
 				if isPseudo {
 					pseudoSelector := "::" + strings.Split(m.Selector, "::")[1]
@@ -133,35 +189,56 @@ func (s Styles) GetStyles(n *Node) {
 					if strings.Contains(m.Selector, ":hover") {
 						n.hovered = true
 
-						match, _ = TestSelector(n, m.Selector)
+						match, _ = m.test(n, filter)
 
 						if match {
-							conditionalStyles[":hover"] = map[string]string{}
-							for k, v := range *m.Styles {
-								if v == "" {
-									continue
-								}
-								conditionalStyles[":hover"][k] = v
-							}
+							addConditional(conditionalStyles, &conditionalRules, ":hover", m)
 						}
 
 						n.hovered = false
 					}
 				}
-				if !n.focused && !isPseudo {
+				if !n.active && !isPseudo && strings.Contains(m.Selector, ":active") {
+					n.active = true
+
+					match, _ = m.test(n, filter)
+
+					if match {
+						addConditional(conditionalStyles, &conditionalRules, ":active", m)
+					}
+
+					n.active = false
+				}
+				if !n.focusWithin && !isPseudo && strings.Contains(m.Selector, ":focus-within") {
+					n.focusWithin = true
+
+					match, _ = m.test(n, filter)
+
+					if match {
+						addConditional(conditionalStyles, &conditionalRules, ":focus-within", m)
+					}
+
+					n.focusWithin = false
+				}
+				// :focus-visible is only set with :focus, it gets its own styles so they only apply for the keyboard
+				if !n.focused && !isPseudo && strings.Contains(m.Selector, ":focus-visible") {
+					n.focused, n.focusVisible = true, true
+
+					match, _ = m.test(n, filter)
+
+					if match {
+						addConditional(conditionalStyles, &conditionalRules, ":focus-visible", m)
+					}
+
+					n.focused, n.focusVisible = false, false
+				} else if !n.focused && !isPseudo {
 					if strings.Contains(m.Selector, ":focus") {
 						n.focused = true
 
-						match, _ = TestSelector(n, m.Selector)
+						match, _ = m.test(n, filter)
 
 						if match {
-							conditionalStyles[":focus"] = map[string]string{}
-							for k, v := range *m.Styles {
-								if v == "" {
-									continue
-								}
-								conditionalStyles[":focus"][k] = v
-							}
+							addConditional(conditionalStyles, &conditionalRules, ":focus", m)
 						}
 
 						n.focused = false
@@ -212,6 +289,7 @@ func (s Styles) GetStyles(n *Node) {
 
 	// can keep
 	n.ConditionalStyles = conditionalStyles
+	n.conditionalRules = conditionalRules
 
 	// needs to move but can keep
 	s.PsuedoStyles[n.Properties.Id] = pseudoStyles
@@ -6,8 +6,7 @@ import (
 	"grim/adapters/raylib"
 	"grim/plugins/crop"
 	"grim/plugins/flex"
-	"grim/plugins/inline"
-	"grim/plugins/textAlign"
+	"grim/plugins/table"
 	"grim/scripts/a"
 	"grim/transformers/banda"
 
@@ -49,7 +48,7 @@ func main() {
 	// !ISSUE: Flex2 doesn't work anymore
 	window := grim.New(raylib.Init(), 850, 400)
 
-	window.Plugins(inline.Init(), textAlign.Init(), flex.Init(), crop.Init())
+	window.Plugins(flex.Init(), table.Init(), crop.Init())
 	window.Transformers(text.Init(), banda.Init(), scrollbar.Init(), marginblock.Init(), ul.Init(), ol.Init())
 	window.Scripts(a.Init())
 
@@ -57,8 +56,9 @@ func main() {
 	document := window.Document()
 
 	// qsa := document.QuerySelectorAll(`:where(h1, h2, h3)`)
-	qsa := document.QuerySelector(".box")
-	fmt.Println("Classlist: ", qsa.ClassList)
+	if qsa := document.QuerySelector(".box"); qsa != nil {
+		fmt.Println("Classlist: ", qsa.ClassList)
+	}
 
 	window.Open()
 
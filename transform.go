@@ -0,0 +1,170 @@
+package grim
+
+import (
+	"grim/gg"
+	"math"
+	"strings"
+)
+
+// !DEVMAN: transform doesn't change the layout, so the matrices are made after the whole tree has been
+// + laid out. State.Transform is the transform of the element and all of its ancestors combined, in window
+// + coordinates, so adapters and hit testing can use it as is. It is nil when nothing is transformed
+
+// setTransforms sets the Transform of n and its children, parent is the transform of the parent
+func setTransforms(units unitContext, n *Node, s map[string]State, parent *gg.Matrix) {
+	self, ok := s[n.Properties.Id]
+	if !ok {
+		return
+	}
+	self.Transform = parent
+	if m, ok := parseTransform(units, n.ComputedStyle, self); ok {
+		if parent != nil {
+			m = m.Multiply(*parent)
+		}
+		self.Transform = &m
+	}
+	s[n.Properties.Id] = self
+
+	for _, v := range n.Children {
+		setTransforms(units, v, s, self.Transform)
+	}
+}
+
+// parseTransform returns the matrix of the transform property around the transform-origin of the element
+func parseTransform(units unitContext, style map[string]string, self State) (gg.Matrix, bool) {
+	value := strings.TrimSpace(style["transform"])
+	if value == "" || value == "none" {
+		return gg.Matrix{}, false
+	}
+
+	width := self.Width + self.Border.Left.Width + self.Border.Right.Width
+	height := self.Height + self.Border.Top.Width + self.Border.Bottom.Width
+	length := func(v string, max float32) float64 {
+		return float64(units.px(v, self.EM, max))
+	}
+	angle := func(v string) float64 {
+		return float64(parseAngle(v)) * math.Pi / 180
+	}
+
+	// CSS applies the last function first, gg multiplies in the order they are applied
+	m := gg.Identity()
+	for _, f := range Token('(', ')', ' ', value) {
+		open := strings.IndexByte(f, '(')
+		if open < 0 || !strings.HasSuffix(f, ")") {
+			return gg.Matrix{}, false
+		}
+		name := strings.ToLower(f[:open])
+		args := strings.Split(f[open+1:len(f)-1], ",")
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+		arg := func(i int) string {
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+
+		var fm gg.Matrix
+		switch name {
+		case "translate":
+			y := 0.0
+			if arg(1) != "" {
+				y = length(arg(1), height)
+			}
+			fm = gg.Translate(length(arg(0), width), y)
+		case "translatex":
+			fm = gg.Translate(length(arg(0), width), 0)
+		case "translatey":
+			fm = gg.Translate(0, length(arg(0), height))
+		case "scale":
+			x := float64(parseAmount(arg(0), 1))
+			y := x
+			if arg(1) != "" {
+				y = float64(parseAmount(arg(1), 1))
+			}
+			fm = gg.Scale(x, y)
+		case "scalex":
+			fm = gg.Scale(float64(parseAmount(arg(0), 1)), 1)
+		case "scaley":
+			fm = gg.Scale(1, float64(parseAmount(arg(0), 1)))
+		case "rotate", "rotatez":
+			fm = gg.Rotate(angle(arg(0)))
+		case "skew":
+			y := 0.0
+			if arg(1) != "" {
+				y = math.Tan(angle(arg(1)))
+			}
+			fm = gg.Shear(math.Tan(angle(arg(0))), y)
+		case "skewx":
+			fm = gg.Shear(math.Tan(angle(arg(0))), 0)
+		case "skewy":
+			fm = gg.Shear(0, math.Tan(angle(arg(0))))
+		case "matrix":
+			if len(args) != 6 {
+				return gg.Matrix{}, false
+			}
+			v := [6]float64{}
+			for i := range v {
+				v[i] = float64(parseAmount(args[i], 0))
+			}
+			fm = gg.Matrix{XX: v[0], YX: v[1], XY: v[2], YY: v[3], X0: v[4], Y0: v[5]}
+		default:
+			// A invalid function makes the whole transform invalid
+			return gg.Matrix{}, false
+		}
+		m = fm.Multiply(m)
+	}
+
+	ox, oy := transformOrigin(units, style["transform-origin"], self, width, height)
+	return gg.Translate(-ox, -oy).Multiply(m).Multiply(gg.Translate(ox, oy)), true
+}
+
+// transformOrigin returns the transform-origin in window coordinates, it defaults to the center of the border box
+func transformOrigin(units unitContext, value string, self State, width, height float32) (float64, float64) {
+	x, y := "50%", "50%"
+	parts := strings.Fields(value)
+	// A single vertical keyword is the y position
+	if len(parts) == 1 && (parts[0] == "top" || parts[0] == "bottom") {
+		parts = []string{"center", parts[0]}
+	}
+	// Keywords can be in either order
+	if len(parts) >= 2 && (parts[0] == "top" || parts[0] == "bottom" || parts[1] == "left" || parts[1] == "right") {
+		parts[0], parts[1] = parts[1], parts[0]
+	}
+	if len(parts) > 0 {
+		x = parts[0]
+	}
+	if len(parts) > 1 {
+		y = parts[1]
+	}
+
+	keywords := map[string]string{"left": "0%", "top": "0%", "center": "50%", "right": "100%", "bottom": "100%"}
+	if k, ok := keywords[x]; ok {
+		x = k
+	}
+	if k, ok := keywords[y]; ok {
+		y = k
+	}
+	return float64(self.X + units.px(x, self.EM, width)), float64(self.Y + units.px(y, self.EM, height))
+}
+
+// transformedBounds returns the bounding box of the border box of the element after it is transformed
+func transformedBounds(self State) (x1, y1, x2, y2 float32) {
+	x1, y1 = self.X, self.Y
+	x2 = self.X + self.Width + self.Border.Left.Width + self.Border.Right.Width
+	y2 = self.Y + self.Height + self.Border.Top.Width + self.Border.Bottom.Width
+	if self.Transform == nil {
+		return x1, y1, x2, y2
+	}
+
+	corners := [4][2]float32{{x1, y1}, {x2, y1}, {x2, y2}, {x1, y2}}
+	x1, y1 = float32(math.Inf(1)), float32(math.Inf(1))
+	x2, y2 = float32(math.Inf(-1)), float32(math.Inf(-1))
+	for _, c := range corners {
+		tx, ty := self.Transform.TransformPoint(float64(c[0]), float64(c[1]))
+		x1, y1 = Min(x1, float32(tx)), Min(y1, float32(ty))
+		x2, y2 = Max(x2, float32(tx)), Max(y2, float32(ty))
+	}
+	return x1, y1, x2, y2
+}
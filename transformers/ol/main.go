@@ -45,7 +45,7 @@ func Init() grim.Transformer {
 					c.Fonts = map[string]*truetype.Font{}
 				}
 
-				fs := grim.ConvertToPixels(n.ComputedStyle["font-size"], 16, c.Width)
+				fs := c.ConvertToPixels(n.ComputedStyle["font-size"], 16, c.Width)
 				em := fs
 
 				fid := n.ComputedStyle["font-family"] + fmt.Sprint(em, n.ComputedStyle["font-weight"], italic)
@@ -14,11 +14,22 @@ var nonRenderTags = map[string]bool{
 	"style": true,
 }
 
+var flexDisplays = map[string]bool{
+	"flex":        true,
+	"inline-flex": true,
+	"grid":        true,
+	"inline-grid": true,
+}
+
+// !DEVMAN: Text is laid out in line boxes by the inline formatting context (see inline.go),
+// + the only text that can't take part in one is the text of a flex or grid container as
+// + all of their children are items. That text is moved into a anonymous text element
+// + so it gets laid out as a item of its own
 func Init() grim.Transformer {
 	return grim.Transformer{
 		Selector: func(n *grim.Node, c *grim.CSS) bool {
 			if len(strings.TrimSpace(n.InnerText())) > 0 && !grim.ChildrenHaveText(n) {
-				return true
+				return flexDisplays[n.ComputedStyle["display"]]
 			} else {
 				return false
 			}
@@ -27,37 +38,15 @@ func Init() grim.Transformer {
 			if nonRenderTags[n.TagName()] {
 				return n
 			}
-			words := strings.Split(strings.TrimSpace(DecodeHTMLEscapes(n.InnerText())), " ")
+			el := n.CreateElement("text")
+			el.InnerText(DecodeHTMLEscapes(n.InnerText()))
+			el.ComputedStyle["display"] = "inline"
+			el.ComputedStyle["font-size"] = "1em"
 			n.InnerText("")
-			if n.ComputedStyle["display"] == "inline" {
-				n.InnerText(DecodeHTMLEscapes(words[0]))
-				for i := 0; i < len(words)-1; i++ {
-					// Add the words backwards because you are inserting adjacent to the parent
-					a := (len(words) - 1) - i
-					if len(strings.TrimSpace(words[a])) > 0 {
-						el := n.CreateElement("text")
-						el.InnerText(words[a])
-						// !CHECK: Idk if this breaks text
-						// el.Parent = n
-						// grim.QuickStyles(&el)
-						el.ComputedStyle["display"] = "inline"
-						el.ComputedStyle["font-size"] = "1em"
-						n.Parent().InsertAfter(&el, n)
-					}
-				}
-
+			if len(n.Children) > 0 {
+				n.InsertBefore(&el, n.Children[0])
 			} else {
-				for i := 0; i < len(words); i++ {
-					if len(strings.TrimSpace(words[i])) > 0 {
-						el := n.CreateElement("text")
-						el.InnerText(words[i])
-						// el.Parent = n
-						// grim.QuickStyles(&el)
-						el.ComputedStyle["display"] = "inline"
-						el.ComputedStyle["font-size"] = "1em"
-						n.AppendChild(&el)
-					}
-				}
+				n.AppendChild(&el)
 			}
 			return n
 		},
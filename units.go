@@ -0,0 +1,365 @@
+package grim
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// !DEVMAN: Lengths are converted with the font size of the element (em) and the length percentages resolve
+// + against (max). The values that are the same for every element of a window (the root font size and the
+// + viewport) are kept in CSS.units, ComputeNodeState sets them when it reaches the html element. ch and ex
+// + are measured in the font of the element, c.unitsOf(style) returns the context for a element and
+// + CSS.ConvertToPixels measures them in the font of the root element
+
+// unitContext is what the relative units resolve against
+type unitContext struct {
+	rootEM         float32
+	viewportWidth  float32
+	viewportHeight float32
+	css            *CSS
+	// style is the style of the element ch and ex are measured in
+	style map[string]string
+}
+
+// unitsOf returns the unit context of the window for a element with the style
+func (c *CSS) unitsOf(style map[string]string) unitContext {
+	u := c.units
+	if u.rootEM == 0 {
+		u.rootEM = 16
+	}
+	u.css = c
+	if style != nil {
+		u.style = style
+	}
+	return u
+}
+
+// ConvertToPixels converts a CSS measurement to pixels with the root font size and the viewport of the window,
+// ch and ex are measured in the font of the root element
+func (c *CSS) ConvertToPixels(value string, em, max float32) float32 {
+	return c.unitsOf(nil).px(value, em, max)
+}
+
+// fontUnit returns the size of a ch or ex in the font of the element, if the font can't be loaded both
+// are 0.5em like the spec suggests. The sizes are cached on the CSS with the font
+func (u unitContext) fontUnit(unit string, em float32) float32 {
+	if u.css == nil || u.css.Adapter == nil || u.style == nil {
+		return em / 2
+	}
+	key := fontId(u.style) + unit + strconv.FormatFloat(float64(em), 'f', -1, 32)
+	if size, ok := u.css.fontUnits[key]; ok {
+		return size
+	}
+	size := em / 2
+	if fnt, err := u.css.loadFont(u.style, em); err == nil {
+		face := truetype.NewFace(fnt, &truetype.Options{
+			Size:    (float64(em) * 72) / 96,
+			DPI:     96,
+			Hinting: font.HintingNone,
+		})
+		switch unit {
+		case "ch":
+			if advance, ok := face.GlyphAdvance('0'); ok {
+				size = float32(advance) / 64
+			}
+		case "ex":
+			if bounds, _, ok := face.GlyphBounds('x'); ok {
+				size = float32(-bounds.Min.Y) / 64
+			}
+		}
+		face.Close()
+	}
+	if u.css.fontUnits == nil {
+		u.css.fontUnits = map[string]float32{}
+	}
+	u.css.fontUnits[key] = size
+	return size
+}
+
+// Absolute units in pixels
+var unitFactors = map[string]float32{
+	"px": 1,
+	"in": 96,
+	"cm": 96 / 2.54,
+	"mm": 96 / 25.4,
+	"q":  96 / 101.6,
+	"pt": 96.0 / 72,
+	"pc": 16,
+}
+
+// ConvertToPixels converts a CSS measurement to pixels without a window, rem is 16px, the viewport units
+// resolve against max and ch and ex are 0.5em. Plugins should use CSS.ConvertToPixels
+func ConvertToPixels(value string, em, max float32) float32 {
+	return unitContext{rootEM: 16}.px(value, em, max)
+}
+
+// px converts a CSS measurement to pixels
+func (u unitContext) px(value string, em, max float32) float32 {
+	value = strings.TrimSpace(value)
+	// Quick check for predefined units
+	switch value {
+	case "thick":
+		return 5
+	case "medium":
+		return 3
+	case "thin":
+		return 1
+	// Relative font sizes, em is the font size of the parent
+	case "smaller":
+		return em / 1.2
+	case "larger":
+		return em * 1.2
+	case "auto":
+		return max
+	}
+
+	// Handle math functions
+	if i := strings.IndexByte(value, '('); i > 0 && mathFunctions[strings.ToLower(value[:i])] {
+		p := calcParser{tokens: tokenizeCalc(value), units: u, em: em, max: max}
+		v := p.expression()
+		if p.err != nil || p.pos != len(p.tokens) {
+			return 0
+		}
+		return v
+	}
+
+	v, unit, err := splitDimension(value)
+	// Unitless lengths other than 0 aren't valid outside of math functions
+	if err != nil || unit == "" {
+		return 0
+	}
+	return u.resolve(v, unit, em, max)
+}
+
+// splitDimension splits a value like 1.5rem into its number and unit
+func splitDimension(value string) (float32, string, error) {
+	i := 0
+	if i < len(value) && (value[i] == '+' || value[i] == '-') {
+		i++
+	}
+	for i < len(value) && (isDigit(value[i]) || value[i] == '.') {
+		i++
+	}
+	// Exponents, the e has to be followed by a number so it isn't confused with em and ex
+	if i+1 < len(value) && (value[i] == 'e' || value[i] == 'E') && (isDigit(value[i+1]) || ((value[i+1] == '-' || value[i+1] == '+') && i+2 < len(value) && isDigit(value[i+2]))) {
+		i += 2
+		for i < len(value) && isDigit(value[i]) {
+			i++
+		}
+	}
+	v, err := strconv.ParseFloat(value[:i], 32)
+	if err != nil {
+		return 0, "", err
+	}
+	return float32(v), strings.ToLower(value[i:]), nil
+}
+
+func (u unitContext) resolve(v float32, unit string, em, max float32) float32 {
+	if factor, ok := unitFactors[unit]; ok {
+		return v * factor
+	}
+
+	vw, vh := u.viewportWidth, u.viewportHeight
+	// Before the window has a size the viewport units fall back to the size of the container
+	if vw == 0 || vh == 0 {
+		vw, vh = max, max
+	}
+
+	switch unit {
+	case "em":
+		return v * em
+	case "rem":
+		return v * u.rootEM
+	case "%":
+		return v * (max / 100)
+	case "vw", "svw", "lvw", "dvw":
+		return v * (vw / 100)
+	case "vh", "svh", "lvh", "dvh":
+		return v * (vh / 100)
+	case "vmin":
+		return v * (Min(vw, vh) / 100)
+	case "vmax":
+		return v * (Max(vw, vh) / 100)
+	case "ch", "ex":
+		return v * u.fontUnit(unit, em)
+	}
+	return 0
+}
+
+var mathFunctions = map[string]bool{
+	"calc":  true,
+	"min":   true,
+	"max":   true,
+	"clamp": true,
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// tokenizeCalc splits a math expression into numbers, function names and operators. + and - followed by
+// a digit are the sign of the number, CSS asks for whitespace around the operators so 1px -2px is two
+// numbers and the expression is invalid
+func tokenizeCalc(s string) []string {
+	tokens := []string{}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("()*/,", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case (c == '+' || c == '-') && !(i+1 < len(s) && (isDigit(s[i+1]) || s[i+1] == '.')):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			if s[j] == '+' || s[j] == '-' {
+				j++
+			}
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.') {
+				j++
+			}
+			for j < len(s) && (isLetter(s[j]) || s[j] == '%' || (s[j] == '-' && j > i && isLetter(s[j-1]) && j+1 < len(s) && isLetter(s[j+1]))) {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// calcParser evaluates calc(), min(), max() and clamp() with a recursive descent parser,
+// * and / bind tighter than + and -
+type calcParser struct {
+	tokens []string
+	pos    int
+	units  unitContext
+	em     float32
+	max    float32
+	err    error
+}
+
+func (p *calcParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *calcParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *calcParser) expect(t string) {
+	if p.next() != t && p.err == nil {
+		p.err = errors.New("expected " + t)
+	}
+}
+
+func (p *calcParser) expression() float32 {
+	v := p.term()
+	for p.err == nil && (p.peek() == "+" || p.peek() == "-") {
+		if p.next() == "+" {
+			v += p.term()
+		} else {
+			v -= p.term()
+		}
+	}
+	return v
+}
+
+func (p *calcParser) term() float32 {
+	v := p.factor()
+	for p.err == nil && (p.peek() == "*" || p.peek() == "/") {
+		if p.next() == "*" {
+			v *= p.factor()
+		} else if d := p.factor(); d != 0 {
+			v /= d
+		} else {
+			p.err = errors.New("division by zero")
+		}
+	}
+	return v
+}
+
+func (p *calcParser) factor() float32 {
+	t := p.next()
+	switch {
+	case t == "":
+		p.err = errors.New("unexpected end of expression")
+		return 0
+	case t == "(":
+		v := p.expression()
+		p.expect(")")
+		return v
+	case t == "-":
+		return -p.factor()
+	case t == "+":
+		return p.factor()
+	case p.peek() == "(":
+		p.next()
+		return p.function(strings.ToLower(t))
+	}
+
+	v, unit, err := splitDimension(t)
+	if err != nil {
+		p.err = err
+		return 0
+	}
+	if unit == "" {
+		return v
+	}
+	return p.units.resolve(v, unit, p.em, p.max)
+}
+
+func (p *calcParser) function(name string) float32 {
+	args := []float32{p.expression()}
+	for p.err == nil && p.peek() == "," {
+		p.next()
+		args = append(args, p.expression())
+	}
+	p.expect(")")
+	if p.err != nil {
+		return 0
+	}
+
+	switch name {
+	case "calc":
+		if len(args) == 1 {
+			return args[0]
+		}
+	case "min", "max":
+		v := args[0]
+		for _, a := range args[1:] {
+			if name == "min" {
+				v = Min(v, a)
+			} else {
+				v = Max(v, a)
+			}
+		}
+		return v
+	case "clamp":
+		if len(args) == 3 {
+			return Max(args[0], Min(args[1], args[2]))
+		}
+	}
+	p.err = errors.New("invalid " + name + "()")
+	return 0
+}
@@ -30,7 +30,12 @@ type BoxSizing struct {
 	Height float32
 }
 
+// FindBounds returns the size, margin and padding of the node without a window (see ConvertToPixels)
 func FindBounds(n Node, style map[string]string, state *map[string]State) (BoxSizing, BoxSpacing, BoxSpacing) {
+	return findBounds(unitContext{rootEM: 16}, n, style, state)
+}
+
+func findBounds(units unitContext, n Node, style map[string]string, state *map[string]State) (BoxSizing, BoxSpacing, BoxSpacing) {
 	s := *state
 	self := s[n.Properties.Id]
 	var parent State
@@ -59,26 +64,31 @@ func FindBounds(n Node, style map[string]string, state *map[string]State) (BoxSi
 		}
 	}
 
+	// Fixed elements are sized against the viewport
+	if style["position"] == "fixed" && units.viewportWidth > 0 {
+		pwh = BoxSizing{Width: units.viewportWidth, Height: units.viewportHeight}
+	}
+
 	wStyle := style["width"]
 
 	if wStyle == "" && style["display"] != "inline" {
 		wStyle = "100%"
 	}
 
-	width := ConvertToPixels(wStyle, fs, pwh.Width)
-	height := ConvertToPixels(style["height"], fs, pwh.Height)
+	width := units.px(wStyle, fs, pwh.Width)
+	height := units.px(style["height"], fs, pwh.Height)
 
 	if minWidth, exists := style["min-width"]; exists {
-		width = Max(width, ConvertToPixels(minWidth, fs, pwh.Width))
+		width = Max(width, units.px(minWidth, fs, pwh.Width))
 	}
 	if maxWidth, exists := style["max-width"]; exists {
-		width = Min(width, ConvertToPixels(maxWidth, fs, pwh.Width))
+		width = Min(width, units.px(maxWidth, fs, pwh.Width))
 	}
 	if minHeight, exists := style["min-height"]; exists {
-		height = Max(height, ConvertToPixels(minHeight, fs, pwh.Height))
+		height = Max(height, units.px(minHeight, fs, pwh.Height))
 	}
 	if maxHeight, exists := style["max-height"]; exists {
-		height = Min(height, ConvertToPixels(maxHeight, fs, pwh.Height))
+		height = Min(height, units.px(maxHeight, fs, pwh.Height))
 	}
 
 	wh := BoxSizing{
@@ -86,20 +96,20 @@ func FindBounds(n Node, style map[string]string, state *map[string]State) (BoxSi
 		Height: height,
 	}
 
-	m := getMP(n, style, wh, state, "margin")
-	padding := getMP(n, style, wh, state, "padding")
+	m := getMP(units, n, style, wh, state, "margin")
+	padding := getMP(units, n, style, wh, state, "padding")
 
 	if p != nil {
 		wh.Width += padding.Left + padding.Right
 		wh.Height += padding.Top + padding.Bottom
 	}
 
-	if wStyle == "100%" && style["position"] != "absolute" {
+	if wStyle == "100%" && !isPositioned(style) {
 		wh.Width -= (m.Right + m.Left + self.Border.Left.Width + self.Border.Right.Width + parent.Padding.Left + parent.Padding.Right + padding.Left + padding.Right)
 	}
 
 	if style["height"] == "100%" {
-		if style["position"] == "absolute" {
+		if isPositioned(style) {
 			wh.Height -= (m.Top + m.Bottom)
 		} else {
 			wh.Height -= (m.Top + m.Bottom + parent.Padding.Top + parent.Padding.Bottom)
@@ -109,7 +119,7 @@ func FindBounds(n Node, style map[string]string, state *map[string]State) (BoxSi
 	return wh, m, padding
 }
 
-func getMP(n Node, style map[string]string, wh BoxSizing, state *map[string]State, t string) BoxSpacing {
+func getMP(units unitContext, n Node, style map[string]string, wh BoxSizing, state *map[string]State, t string) BoxSpacing {
 	s := *state
 	self := s[n.Properties.Id]
 	fs := self.EM
@@ -125,14 +135,14 @@ func getMP(n Node, style map[string]string, wh BoxSizing, state *map[string]Stat
 
 	// Convert left and right properties
 	if leftStyle != "" || rightStyle != "" {
-		m.Left = ConvertToPixels(leftStyle, fs, wh.Width)
-		m.Right = ConvertToPixels(rightStyle, fs, wh.Width)
+		m.Left = units.px(leftStyle, fs, wh.Width)
+		m.Right = units.px(rightStyle, fs, wh.Width)
 	}
 
 	// Convert top and bottom properties
 	if topStyle != "" || bottomStyle != "" {
-		m.Top = ConvertToPixels(topStyle, fs, wh.Height)
-		m.Bottom = ConvertToPixels(bottomStyle, fs, wh.Height)
+		m.Top = units.px(topStyle, fs, wh.Height)
+		m.Bottom = units.px(bottomStyle, fs, wh.Height)
 	}
 
 	p := n.parent
@@ -197,109 +207,6 @@ func getMP(n Node, style map[string]string, wh BoxSizing, state *map[string]Stat
 	return m
 }
 
-var unitFactors = map[string]float64{
-	"px":   1,
-	"rem":  -1, // special handling
-	"em":   -1, // special handling
-	"pt":   1.33,
-	"pc":   16.89,
-	"%":    -1, // special handling
-	"vw":   -1, // special handling
-	"vh":   -1, // special handling
-	"cm":   37.79527559,
-	"in":   96,
-	"auto": -1,
-}
-
-// ConvertToPixels converts a CSS measurement to pixels.
-func ConvertToPixels(value string, em, max float32) float32 {
-	// Quick check for predefined units
-	switch value {
-	case "thick":
-		return 5
-	case "medium":
-		return 3
-	case "thin":
-		return 1
-	}
-
-	// Handle calculation expression
-	if len(value) > 5 && value[:5] == "calc(" {
-		return evaluateCalcExpression(value[5:len(value)-1], em, max)
-	}
-
-	for unit, factor := range unitFactors {
-		if strings.HasSuffix(value, unit) {
-			if unit == "em" && strings.HasSuffix(value, "rem") {
-				continue
-			}
-			cutStr := strings.TrimSuffix(value, unit)
-			numericValue, err := strconv.ParseFloat(cutStr, 64)
-			if err != nil && value != "auto" {
-				return 0
-			}
-			// Handle special units like "em", "%" etc.
-			if factor == -1 {
-				switch unit {
-				case "em":
-					return float32(numericValue) * em
-				// !ISSUE: REM not properly impleamented
-				case "rem":
-					return float32(numericValue) * em
-				case "%", "vw", "vh":
-					return float32(numericValue) * (max / 100)
-				case "pt":
-					return (float32(numericValue) * 96) / 72
-				case "auto":
-					return max
-				}
-			}
-			return float32(numericValue) * float32(factor)
-		}
-	}
-
-	// Default return if no match
-	return 0
-}
-
-// evaluateCalcExpression recursively evaluates 'calc()' expressions
-func evaluateCalcExpression(expression string, em, max float32) float32 {
-	terms := strings.FieldsFunc(expression, func(c rune) bool {
-		return c == '+' || c == '-' || c == '*' || c == '/'
-	})
-
-	operators := strings.FieldsFunc(expression, func(c rune) bool {
-		return c != '+' && c != '-' && c != '*' && c != '/'
-	})
-
-	var result float32
-
-	for i, term := range terms {
-		value := ConvertToPixels(strings.TrimSpace(term), em, max)
-
-		if i > 0 {
-			switch operators[i-1] {
-			case "+":
-				result += value
-			case "-":
-				result -= value
-			case "*":
-				result *= value
-			case "/":
-				if value != 0 {
-					result /= value
-				} else {
-					return 0
-				}
-			}
-		} else {
-			result = value
-		}
-	}
-
-	return result
-}
-
 func Max(a, b float32) float32 {
 	if a > b {
 		return a